@@ -0,0 +1,138 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/audit.go
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"decrypt/n2s"
+)
+
+// nonceReuse is two blobids that, under one password, seal with the same
+// key and nonce.
+type nonceReuse struct{ first, second string }
+
+// auditNonces looks for key and nonce reuse among entries. Blobs with the
+// same salt and KDF parameters derive the same key from a password, so
+// any two of them sharing a nonce are a reuse; different blobids can get
+// there through the digest form's unused bytes or a header added by
+// migrate. The same blob listed twice, whether by one blobid or as a
+// producer digest and the migrated blobid that records it, is only a
+// reuse when the two ciphertexts are known and their bytes differ,
+// however each is encoded. Entries whose blobid or ciphertext does not
+// decode come back in bad.
+func auditNonces(entries []manifestEntry) (reuses []nonceReuse, groups int, bad map[int]error) {
+	type seen struct {
+		id, origin string
+		// sum is the SHA-256 of the decoded ciphertext, or "" if the
+		// entry has none.
+		sum string
+	}
+	nonces := make(map[cacheKey]map[string][]seen)
+	bad = make(map[int]error)
+	for i, e := range entries {
+		blob, err := n2s.ParseBlobID([]byte(e.BlobID))
+		if err != nil {
+			bad[i] = err
+			continue
+		}
+		var sum string
+		if e.Ciphertext != "" {
+			ciphertext, err := decodeBase64(e.Ciphertext)
+			if err != nil {
+				bad[i] = err
+				continue
+			}
+			h := sha256.Sum256(ciphertext)
+			sum = string(h[:])
+		}
+		k := cacheKey{salt: string(blob.Salt), kdf: blob.KDF}
+		group, ok := nonces[k]
+		if !ok {
+			group = make(map[string][]seen)
+			nonces[k] = group
+			groups++
+		}
+		nonce := string(blob.Nonce)
+		cur := seen{blob.ID(), blob.ID(), sum}
+		if blob.Digest != nil {
+			cur.origin = hex.EncodeToString(blob.Digest)
+		}
+		if slices.ContainsFunc(group[nonce], func(prev seen) bool {
+			return prev.origin == cur.origin && (prev.sum == "" || cur.sum == "" || prev.sum == cur.sum)
+		}) {
+			continue
+		}
+		for _, prev := range group[nonce] {
+			reuses = append(reuses, nonceReuse{prev.id, cur.id})
+		}
+		group[nonce] = append(group[nonce], cur)
+	}
+	return reuses, groups, bad
+}
+
+// runAudit prints "blobid<TAB>blobid" for every pair that reuses a key
+// and nonce. It needs no password: the blobids alone say which blobs
+// share a key.
+func runAudit(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	logf := addLogFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return exitUsage
+	}
+	level, err := logf.level()
+	log := newLogger(stderr, level)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintf(stderr, "Usage: %s audit <manifest|->\n", os.Args[0])
+		return exitUsage
+	}
+
+	r, err := openInput(fs.Arg(0), stdin)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	entries, err := parseManifest(r)
+	r.Close()
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+
+	reuses, groups, bad := auditNonces(entries)
+	for i, e := range entries {
+		if err, ok := bad[i]; ok {
+			log.logf(levelError, "FAIL %s: %v", e.name(), err)
+		}
+	}
+	for _, p := range reuses {
+		fmt.Fprintf(stdout, "%s\t%s\n", p.first, p.second)
+	}
+	log.logf(levelWarn, "audit: %d blobs in %d salt groups, %d nonce reuses, %d unparseable",
+		len(entries)-len(bad), groups, len(reuses), len(bad))
+	if len(reuses) > 0 || len(bad) > 0 {
+		return exitFailure
+	}
+	return 0
+}
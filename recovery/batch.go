@@ -0,0 +1,870 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/batch.go
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"decrypt/n2s"
+)
+
+// manifestEntry is one blob to recover. Manifests are either lines of
+// "blobid<TAB>ciphertext_b64" or a JSON array of these objects.
+type manifestEntry struct {
+	BlobID     string `json:"blobid"`
+	Ciphertext string `json:"ciphertext_b64"`
+
+	// For -recurse: src is the .b64 file holding the ciphertext and dest
+	// the output path (relative to -out) instead of <blobid>. For -tar,
+	// src is the entry's name in the archive and data its contents.
+	src, dest string
+	data      []byte
+	// id is BlobID in canonical form, lowercase hex with no whitespace,
+	// once prepare has parsed it; see key.
+	id string
+	// shard is the -shard subdirectory of -out the output goes in, or "".
+	shard string
+	// dup, when set, is why this entry is not decrypted: an earlier entry
+	// has its output. With discard it is decrypted but not written, as a
+	// later entry overwrites its output. See resolveDuplicates.
+	dup     error
+	discard bool
+	// For -line: line is e's line number and cache the key cache for its
+	// password, in place of the run's.
+	line  int
+	cache *keyCache
+	// bad, when set, is why e is refused before any key is derived: a
+	// -line that does not parse, or a headerless blobid under -strict.
+	bad error
+}
+
+// name identifies e in failure messages.
+func (e manifestEntry) name() string {
+	switch {
+	case e.src != "":
+		return e.src
+	case e.line > 0:
+		return fmt.Sprintf("line %d", e.line)
+	}
+	return e.BlobID
+}
+
+// key is e's blobid as batch files it: its output name, duplicate check
+// and ledger line. ParseBlobID accepts uppercase and whitespace, so this
+// is the canonical form, or the blobid as given if it does not parse.
+func (e manifestEntry) key() string {
+	if e.id != "" {
+		return e.id
+	}
+	return e.BlobID
+}
+
+// outPath is where e's plaintext goes under dir.
+func (e manifestEntry) outPath(dir string) string {
+	if e.dest != "" {
+		return filepath.Join(dir, e.shard, e.dest)
+	}
+	return filepath.Join(dir, e.shard, e.key())
+}
+
+// shardDigits checks a -shard bucket count, a power of 16 up to 65536 or
+// 0 for none, and returns how many hex digits name a bucket.
+func shardDigits(n int) (int, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	for digits, buckets := 1, 16; digits <= 4; digits, buckets = digits+1, buckets*16 {
+		if n == buckets {
+			return digits, nil
+		}
+	}
+	return 0, usageErrorf("-shard %d: want 0, 16, 256, 4096 or 65536", n)
+}
+
+// shardOf names blob's bucket: the first digits hex digits of its salt.
+// The salt is random, so buckets fill evenly, and it leads a headerless
+// blobid, so there the bucket is simply the blobid's prefix; a header
+// would put every blob in one bucket.
+func shardOf(blob *n2s.Blob, digits int) string {
+	if digits == 0 {
+		return ""
+	}
+	return hex.EncodeToString(blob.Salt)[:digits]
+}
+
+// parseManifest reads either manifest form. A line that is not two
+// TAB-separated fields comes back as an entry with an empty Ciphertext, so
+// the caller reports it alongside decryption failures instead of aborting.
+func parseManifest(r io.Reader) ([]manifestEntry, error) {
+	br := bufio.NewReader(r)
+	var first byte
+	for {
+		c, err := br.ReadByte()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading manifest: %w", err)
+		}
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			first = c
+			br.UnreadByte()
+			break
+		}
+	}
+
+	if first == '[' {
+		var entries []manifestEntry
+		if err := json.NewDecoder(br).Decode(&entries); err != nil {
+			return nil, fmt.Errorf("parsing JSON manifest: %w", err)
+		}
+		return entries, nil
+	}
+
+	var entries []manifestEntry
+	sc := bufio.NewScanner(br)
+	// Ciphertext lines run to many megabytes.
+	sc.Buffer(nil, 1<<30)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		blobid, ciphertext, _ := strings.Cut(line, "\t")
+		entries = append(entries, manifestEntry{BlobID: blobid, Ciphertext: ciphertext})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+	return entries, nil
+}
+
+// cacheKey identifies a derived key: blobs sharing a salt and KDF
+// parameters under one password share a key.
+type cacheKey struct {
+	salt string
+	kdf  n2s.KDFParams
+}
+
+// keyCache runs the KDF once per unique (salt, params) for a single
+// password. It is safe for concurrent use; workers that ask for the same
+// key while it is being derived wait for the first derivation instead of
+// repeating it. With -key-cache it starts from the keys a previous run
+// saved (see keycache.go).
+type keyCache struct {
+	password string
+	mu       sync.Mutex
+	keys     map[cacheKey]*cachedKey
+	// dirty is set once a key is derived or replaced, so an unchanged
+	// -key-cache file is not rewritten.
+	dirty bool
+}
+
+type cachedKey struct {
+	once sync.Once
+	key  []byte
+	err  error
+
+	// fromDisk marks a key loaded from -key-cache, which was derived
+	// from whatever password that run had; recheck derives it afresh
+	// once if it fails to open a blob.
+	fromDisk bool
+	recheck  sync.Once
+	fresh    []byte
+}
+
+// deriveKey is a variable so tests can count derivations.
+var deriveKey = n2s.DeriveKey
+
+func newKeyCache(password string) *keyCache {
+	return &keyCache{password: password, keys: make(map[cacheKey]*cachedKey)}
+}
+
+func (c *keyCache) key(blob *n2s.Blob) ([]byte, error) {
+	if blob.WrappedKey != nil {
+		return nil, n2s.ErrWrappedKey
+	}
+	k := cacheKey{salt: string(blob.Salt), kdf: blob.KDF}
+	c.mu.Lock()
+	e, ok := c.keys[k]
+	if !ok {
+		e = &cachedKey{}
+		c.keys[k] = e
+	}
+	c.mu.Unlock()
+
+	e.once.Do(func() {
+		e.key, e.err = deriveKey(c.password, blob.Salt, blob.KDF, blob.Cipher.KeySize())
+		if e.err == nil {
+			c.mu.Lock()
+			c.dirty = true
+			c.mu.Unlock()
+		}
+	})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e.fresh != nil {
+		return e.fresh, nil
+	}
+	return e.key, e.err
+}
+
+// wipe zeroes every cached key; the cache must not be used afterwards.
+func (c *keyCache) wipe() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.keys {
+		n2s.Wipe(e.key)
+		n2s.Wipe(e.fresh)
+	}
+}
+
+// decryptEntry recovers one manifest entry into dir/<blobid>, or with an
+// empty dir (-count-only) authenticates it and discards the plaintext.
+// The result is filled in as far as the entry got, for -json. Timings go
+// to log at info level. Once ctx is done the entry is abandoned before
+// its output is created, so an interrupted run leaves no partial file.
+func decryptEntry(ctx context.Context, cache *keyCache, e manifestEntry, dir string, log *logger) (result, error) {
+	res := result{BlobID: e.BlobID}
+	if e.bad != nil {
+		return res, e.bad
+	}
+	if e.Ciphertext == "" && e.src == "" {
+		return res, fmt.Errorf("missing ciphertext")
+	}
+	if e.cache != nil {
+		cache = e.cache
+	}
+	if e.dup != nil {
+		return res, e.dup
+	}
+	// ParseBlobID only accepts hex digits and whitespace, so the blobid
+	// cannot name a path outside dir.
+	blob, err := n2s.ParseBlobID([]byte(e.BlobID))
+	if err != nil {
+		return res, err
+	}
+	res.describe(blob)
+	ciphertext, err := e.ciphertext(blob)
+	if err != nil {
+		return res, err
+	}
+	if err := ctx.Err(); err != nil {
+		return res, errInterrupted
+	}
+	start := time.Now()
+	key, err := cache.key(blob)
+	if err != nil {
+		return res, err
+	}
+	// A cached key shows as the time spent waiting for its derivation.
+	log.infof("%s: key ready (%s) in %v", e.name(), blob.KDF.ID, time.Since(start).Round(time.Millisecond))
+	start = time.Now()
+	plaintext, err := n2s.Open(blob, key, ciphertext, nil)
+	if errors.Is(err, n2s.ErrAuthFailed) {
+		if fresh, ok := cache.recheck(blob); ok {
+			log.warnf("%s: -key-cache key is stale; derived it afresh", e.name())
+			plaintext, err = n2s.Open(blob, fresh, ciphertext, nil)
+		}
+	}
+	if err != nil {
+		return res, err
+	}
+	log.infof("%s: opened %d bytes in %v", e.name(), len(plaintext), time.Since(start).Round(time.Microsecond))
+	defer n2s.Wipe(plaintext)
+	res.PlaintextBytes = len(plaintext)
+	if err := ctx.Err(); err != nil {
+		return res, errInterrupted
+	}
+	if dir == "" || e.discard {
+		return res, nil
+	}
+
+	dest := e.outPath(dir)
+	if e.dest != "" || e.shard != "" {
+		if err := os.MkdirAll(filepath.Dir(dest), 0o700); err != nil {
+			return res, fmt.Errorf("creating output directory: %w", err)
+		}
+	}
+	return res, writeAtomic(dest, plaintext)
+}
+
+// ciphertext returns e's decoded ciphertext, from the manifest line, its
+// src file or the tar entry read into data. A .n2s file must hold blob
+// itself.
+func (e manifestEntry) ciphertext(blob *n2s.Blob) ([]byte, error) {
+	var inFile *n2s.Blob
+	var ciphertext []byte
+	var err error
+	switch {
+	case e.data != nil && strings.HasSuffix(e.src, ".n2s"):
+		inFile, ciphertext, err = parseBlobFile(e.data)
+	case e.data != nil:
+		return decodeBase64(string(e.data))
+	case strings.HasSuffix(e.src, ".n2s"):
+		inFile, ciphertext, err = readBlobFile(e.src, nil)
+	case e.src != "":
+		return readCiphertext(e.src, nil)
+	default:
+		return decodeBase64(e.Ciphertext)
+	}
+	if err == nil && inFile.ID() != blob.ID() {
+		err = &decodeError{fmt.Errorf("blob file holds blobid %s", inFile.ID())}
+	}
+	return ciphertext, err
+}
+
+// walkBlobTree lists every <blobid>.b64 file under root as an entry whose
+// plaintext goes to the same relative path, minus the suffix. Other files
+// are skipped and counted.
+func walkBlobTree(root string) (entries []manifestEntry, skipped int, err error) {
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		name, ok := strings.CutSuffix(d.Name(), ".b64")
+		if !ok || !d.Type().IsRegular() {
+			skipped++
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, manifestEntry{
+			BlobID: name,
+			src:    path,
+			dest:   filepath.Join(filepath.Dir(rel), name),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("walking %s: %w", root, err)
+	}
+	return entries, skipped, nil
+}
+
+// The -on-duplicate policies for entries that write the same output, as
+// when a manifest lists a blobid twice.
+const (
+	onDuplicateError     = "error"
+	onDuplicateSkip      = "skip"
+	onDuplicateOverwrite = "overwrite"
+)
+
+// errSkipped marks an entry -on-duplicate skip left out; it is not a
+// failure.
+var errSkipped = errors.New("skipped")
+
+// checkEntry is what batch can check of an entry without a key: it
+// parsed, and has a ciphertext and a blobid that parses.
+func checkEntry(e manifestEntry) error {
+	if e.bad != nil {
+		return e.bad
+	}
+	if e.Ciphertext == "" && e.src == "" {
+		return fmt.Errorf("missing ciphertext")
+	}
+	_, err := n2s.ParseBlobID([]byte(e.BlobID))
+	return err
+}
+
+// resolveDuplicates applies the -on-duplicate policy to entries sharing
+// an output under dir, before any worker starts, so parallel workers
+// never write one path and the outcome does not depend on -jobs. The
+// first entry to claim an output keeps it; each later one fails with
+// "error" and is left out with "skip". With "overwrite" the last one
+// writes it and the earlier ones are only authenticated. An entry that
+// fails checkEntry claims nothing.
+func resolveDuplicates(entries []manifestEntry, dir, policy string) {
+	claimed := make(map[string]int)
+	for i, e := range entries {
+		if checkEntry(e) != nil {
+			continue
+		}
+		out := e.outPath(dir)
+		prev, ok := claimed[out]
+		switch {
+		case !ok:
+			claimed[out] = i
+		case policy == onDuplicateOverwrite:
+			entries[prev].discard = true
+			claimed[out] = i
+		default:
+			entries[i].dup = duplicateError(out, entries[prev].name(), policy)
+		}
+	}
+}
+
+// duplicateError is why an entry writing out, which prev already claimed,
+// fails or, with -on-duplicate skip, is left out.
+func duplicateError(out, prev, policy string) error {
+	if policy == onDuplicateSkip {
+		return fmt.Errorf("%w: %s is also the output of %s", errSkipped, out, prev)
+	}
+	return fmt.Errorf("%s is also the output of %s; -on-duplicate skip or overwrite allows it", out, prev)
+}
+
+// planEntries checks each entry with checkEntry and reports the
+// duplicates resolveDuplicates found.
+func planEntries(entries []manifestEntry) []error {
+	errs := make([]error, len(entries))
+	for i, e := range entries {
+		if errs[i] = checkEntry(e); errs[i] == nil {
+			errs[i] = e.dup
+		}
+	}
+	return errs
+}
+
+// printPlan is -dry-run: "input<TAB>output" for every entry that would be
+// written, and the reason for every one that would fail or be skipped.
+func printPlan(stdout io.Writer, log *logger, entries []manifestEntry, dir string, resumed int) int {
+	errs := planEntries(entries)
+	var failed, skipped int
+	for i, err := range errs {
+		switch {
+		case errors.Is(err, errSkipped):
+			skipped++
+			log.warnf("SKIP %s: %v", entries[i].name(), err)
+		case err != nil:
+			failed++
+			log.logf(levelError, "FAIL %s: %v", entries[i].name(), err)
+		case entries[i].discard:
+			log.infof("%s: authenticated only; a later entry overwrites %s", entries[i].name(), entries[i].outPath(dir))
+		default:
+			fmt.Fprintf(stdout, "%s\t%s\n", entries[i].name(), entries[i].outPath(dir))
+		}
+	}
+	fmt.Fprintf(stdout, "batch: dry run, %d to decrypt, %d would fail", len(entries)-failed-skipped, failed)
+	if skipped > 0 {
+		fmt.Fprintf(stdout, ", %d skipped", skipped)
+	}
+	if resumed > 0 {
+		fmt.Fprintf(stdout, ", %d already done", resumed)
+	}
+	fmt.Fprintln(stdout)
+	if failed > 0 {
+		return exitFailure
+	}
+	return 0
+}
+
+// formatKinds renders a failure tally as "2 auth, 1 bad hex", in
+// failureKinds order with "other" last.
+func formatKinds(kinds map[string]int) string {
+	var parts []string
+	for _, k := range failureKinds {
+		if n := kinds[k.name]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, k.name))
+		}
+	}
+	if n := kinds["other"]; n > 0 {
+		parts = append(parts, fmt.Sprintf("%d other", n))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// errInterrupted marks an entry left undone because the run was
+// interrupted.
+var errInterrupted = errors.New("interrupted before this entry finished")
+
+// decryptAll recovers entries across jobs workers. Completion order is
+// arbitrary, but results[i] and errs[i] always belong to entries[i]. Each
+// success is recorded in led, if not nil, before the next entry starts.
+// Once ctx is done no further entry starts, in-flight ones are abandoned
+// before writing, and every entry left undone gets errInterrupted.
+func decryptAll(ctx context.Context, cache *keyCache, entries []manifestEntry, dir string, jobs int, log *logger, led *ledger) ([]result, []error) {
+	results := make([]result, len(entries))
+	errs := make([]error, len(entries))
+	for i, e := range entries {
+		results[i], errs[i] = result{BlobID: e.BlobID}, errInterrupted
+	}
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < max(jobs, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				if ctx.Err() != nil {
+					continue
+				}
+				results[i], errs[i] = decryptEntry(ctx, cache, entries[i], dir, log)
+				if errs[i] == nil && led != nil {
+					errs[i] = led.record(entries[i].key())
+				}
+			}
+		}()
+	}
+dispatch:
+	for i := range entries {
+		select {
+		case work <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(work)
+	wg.Wait()
+	return results, errs
+}
+
+func runBatch(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("batch", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	passwordFile := fs.String("password-file", "", "read the password from `file`")
+	keychain := fs.String("keychain", "", "read the password from the OS keychain entry `SERVICE:ACCOUNT`")
+	passwordEnv := fs.String("password-env", "", "read the password from the environment variable `NAME`, e.g. a container secret")
+	outDir := fs.String("out", "", "write each plaintext to `dir`/<blobid>")
+	jobs := fs.Int("jobs", runtime.NumCPU(), "number of blobs to decrypt in parallel")
+	jsonOut := fs.Bool("json", false, "print one JSON result object per manifest entry to stdout instead of the summary")
+	recurse := fs.String("recurse", "", "decrypt every <blobid>.b64 file under `dir` into the same layout under -out, instead of reading a manifest")
+	lineFile := fs.String("line", "", "decrypt every \"blobid password ciphertext\" line of `file` (- for stdin) into -out/<blobid>, each under its own password; quote a password holding spaces")
+	tarFile := fs.String("tar", "", "decrypt every <blobid> entry of the tar archive `file` (- for stdin) into the same layout under -out, streaming it instead of reading a manifest")
+	gz := fs.Bool("gzip", false, "the -tar archive is gzip-compressed; implied by a .gz or .tgz name")
+	restoreNames := fs.Bool("restore-names", false, "with -recurse, read the directory's manifest.json from encrypt -encrypt-dir and restore each blob to its original path")
+	ledgerFile := fs.String("ledger", "", "append each finished blobid to `file` and skip those already in it, so a killed run resumes")
+	dryRun := fs.Bool("dry-run", false, "check every entry's blobid and print the planned input and output paths, without a password or any decryption")
+	normalize := fs.String("normalize", "none", "Unicode-normalize the password before deriving the key: nfc, nfd or none; changes the key")
+	keyCacheFile := fs.String("key-cache", "", "load derived keys from `file` and save new ones to it, sealed under the -key-cache-password-file passphrase, so a rerun skips the KDF")
+	keyCachePasswordFile := fs.String("key-cache-password-file", "", "read the -key-cache passphrase from `file`")
+	countOnly := fs.Bool("count-only", false, "decrypt and authenticate every entry but write nothing; print the total plaintext bytes of those that open (per blob with -v)")
+	onDuplicate := fs.String("on-duplicate", onDuplicateError, "when entries share an output, as a blobid listed twice: `policy` error (the later ones fail), skip (the later ones are left out) or overwrite (the last one is written)")
+	strict := fs.Bool("strict", false, "refuse every legacy blobid without a header instead of decrypting it; migrate gives one a header")
+	shard := fs.Int("shard", 0, "spread outputs over this many `buckets` (16, 256, 4096 or 65536) of -out, named by the leading hex digits of each blob's salt; 0 writes them all into -out")
+	logf := addLogFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return exitUsage
+	}
+	level, err := logf.level()
+	log := newLogger(stderr, level)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	if (*outDir == "") != *countOnly || (*recurse == "" && *tarFile == "" && *lineFile == "" && fs.NArg() < 1) {
+		fmt.Fprintf(stderr, "Usage: %s batch [-password-file file] -out <dir> <manifest|-> [password|-]\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s batch [-password-file file] -out <dir> -recurse <dir> [-restore-names] [password|-]\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s batch [-password-file file] -out <dir> -tar <file|-> [-gzip] [password|-]\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s batch -out <dir> -line <file|->\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s batch [-password-file file] -count-only <manifest|-recurse dir> [password|-]\n", os.Args[0])
+		return exitUsage
+	}
+	rest := fs.Args()
+	var manifest string
+	if *recurse == "" && *tarFile == "" && *lineFile == "" {
+		manifest, rest = rest[0], rest[1:]
+	}
+
+	form, err := parseNormalize(*normalize)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	digits, err := shardDigits(*shard)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	if *dryRun && *jsonOut {
+		log.errorf("-dry-run prints a plan, not results; drop -json")
+		return exitUsage
+	}
+	switch *onDuplicate {
+	case onDuplicateError, onDuplicateSkip, onDuplicateOverwrite:
+	default:
+		log.errorf("-on-duplicate %q: want error, skip or overwrite", *onDuplicate)
+		return exitUsage
+	}
+	if *tarFile != "" && (*recurse != "" || *dryRun) {
+		log.errorf("-tar reads the archive as it decrypts; drop -recurse and -dry-run")
+		return exitUsage
+	}
+	if *tarFile != "" && *onDuplicate == onDuplicateOverwrite {
+		log.errorf("-on-duplicate overwrite picks the last entry before decrypting any, and -tar is read only once; use error or skip")
+		return exitUsage
+	}
+	if *lineFile != "" && (*recurse != "" || *tarFile != "" || len(rest) > 0 || *passwordFile != "" || *keychain != "" || *passwordEnv != "" || *keyCacheFile != "") {
+		log.errorf("-line reads every entry and its password from the file; drop -recurse, -tar, a manifest or password argument, -password-file, -keychain, -password-env and -key-cache")
+		return exitUsage
+	}
+	if *gz && *tarFile == "" {
+		log.errorf("-gzip applies to the -tar archive")
+		return exitUsage
+	}
+	if *restoreNames && *recurse == "" {
+		log.errorf("-restore-names reads the manifest.json in the -recurse directory")
+		return exitUsage
+	}
+	if (*keyCacheFile == "") != (*keyCachePasswordFile == "") {
+		log.errorf("-key-cache and -key-cache-password-file go together")
+		return exitUsage
+	}
+	if *countOnly && (*jsonOut || *dryRun || *ledgerFile != "" || *shard != 0) {
+		log.errorf("-count-only writes no outputs and prints one total; drop -json, -dry-run, -ledger and -shard")
+		return exitUsage
+	}
+	var password string
+	switch {
+	case *dryRun, *lineFile != "":
+	case *keychain != "" && *passwordEnv != "":
+		err = usageErrorf("-keychain and -password-env are mutually exclusive")
+	case *keychain != "":
+		password, err = keychainPassword(*keychain, rest, *passwordFile)
+	case *passwordEnv != "":
+		password, err = envPassword(*passwordEnv, rest, *passwordFile)
+	default:
+		password, err = decryptPassword(rest, *passwordFile, manifest == "-" || *tarFile == "-", stdin, stderr, log)
+	}
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	password = normalizePassword(password, form)
+
+	var entries []manifestEntry
+	var tarIn io.ReadCloser
+	if *tarFile != "" {
+		// The entries are read as the workers take them.
+		if tarIn, err = openTar(*tarFile, *gz, stdin); err != nil {
+			log.errorf("%v", err)
+			return failureCode(err)
+		}
+		defer tarIn.Close()
+	} else if *lineFile != "" {
+		r, err := openInput(*lineFile, stdin)
+		if err != nil {
+			log.errorf("%v", err)
+			return failureCode(err)
+		}
+		var caches map[string]*keyCache
+		entries, caches, err = parseLines(r, form)
+		r.Close()
+		for _, c := range caches {
+			defer c.wipe()
+		}
+		if err != nil {
+			log.errorf("%v", err)
+			return failureCode(err)
+		}
+	} else if *restoreNames {
+		if entries, err = readDirManifest(*recurse); err != nil {
+			log.errorf("%v", err)
+			return failureCode(err)
+		}
+	} else if *recurse != "" {
+		var skipped int
+		entries, skipped, err = walkBlobTree(*recurse)
+		if err != nil {
+			log.errorf("%v", err)
+			return failureCode(err)
+		}
+		if skipped > 0 {
+			log.warnf("batch: skipped %d files without a .b64 suffix", skipped)
+		}
+	} else {
+		r, err := openInput(manifest, stdin)
+		if err != nil {
+			log.errorf("%v", err)
+			return failureCode(err)
+		}
+		entries, err = parseManifest(r)
+		r.Close()
+		if err != nil {
+			log.errorf("%v", err)
+			return failureCode(err)
+		}
+	}
+
+	// prepare finishes an entry once it is read: its canonical blobid,
+	// its -shard bucket, and under -strict the refusal of a headerless
+	// blobid. One that does not parse gets none of these and fails later,
+	// with the reason.
+	prepare := func(e *manifestEntry) {
+		blob, err := n2s.ParseBlobID([]byte(e.BlobID))
+		if err != nil {
+			return
+		}
+		e.id = blob.ID()
+		e.shard = shardOf(blob, digits)
+		if *strict && e.bad == nil {
+			e.bad = strictHeader(blob)
+		}
+	}
+	for i := range entries {
+		prepare(&entries[i])
+	}
+
+	var led *ledger
+	var resumed int
+	if *ledgerFile != "" && !(*dryRun && !fileExists(*ledgerFile)) {
+		if led, err = openLedger(*ledgerFile); err != nil {
+			log.errorf("%v", err)
+			return failureCode(err)
+		}
+		defer led.Close()
+		pending := entries[:0]
+		for _, e := range entries {
+			if led.finished(e, *outDir) {
+				resumed++
+				continue
+			}
+			pending = append(pending, e)
+		}
+		entries = pending
+		if tarIn == nil {
+			// decryptTar checks each entry as it reads it.
+			log.infof("batch: %d entries already in the ledger", resumed)
+		}
+	}
+
+	if !*countOnly {
+		resolveDuplicates(entries, *outDir, *onDuplicate)
+	}
+	if *dryRun {
+		return printPlan(stdout, log, entries, *outDir, resumed)
+	}
+	if !*countOnly {
+		if err := os.MkdirAll(*outDir, 0o700); err != nil {
+			log.errorf("creating output directory: %v", err)
+			return failureCode(err)
+		}
+	}
+
+	// Ctrl-C stops the run between entries; the deferred ledger Close
+	// and key wipe still run, which they would not on the default
+	// signal exit.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	cache := newKeyCache(password)
+	defer cache.wipe()
+	var cachePassword string
+	if *keyCacheFile != "" {
+		if cachePassword, err = readPasswordFile(*keyCachePasswordFile); err == nil {
+			err = cache.load(*keyCacheFile, cachePassword)
+		}
+		if err != nil {
+			log.errorf("%v", err)
+			return failureCode(err)
+		}
+		log.infof("batch: %d keys from %s", len(cache.keys), *keyCacheFile)
+	}
+	var results []result
+	var errs []error
+	var tarErr error
+	if tarIn != nil {
+		var more int
+		entries, results, errs, more, tarErr = decryptTar(ctx, cache, tarIn, *outDir, prepare, *onDuplicate, *jobs, log, led)
+		resumed += more
+		if tarErr != nil {
+			log.errorf("%v; the rest of the archive was not read", tarErr)
+		}
+	} else {
+		results, errs = decryptAll(ctx, cache, entries, *outDir, *jobs, log, led)
+	}
+	if *keyCacheFile != "" {
+		if err := cache.save(*keyCacheFile, cachePassword); err != nil {
+			log.errorf("%v", err)
+		}
+	}
+	var failed, interrupted, skipped int
+	var total int64
+	kinds := make(map[string]int)
+	for i, err := range errs {
+		switch {
+		case errors.Is(err, errInterrupted):
+			interrupted++
+		case errors.Is(err, errSkipped):
+			skipped++
+		case err != nil:
+			failed++
+			kinds[failureKind(err)]++
+		default:
+			total += int64(results[i].PlaintextBytes)
+		}
+		if *jsonOut {
+			res := results[i]
+			res.Status = statusOK
+			switch {
+			case errors.Is(err, errInterrupted):
+				res.Status = statusInterrupted
+			case errors.Is(err, errSkipped):
+				res.Status, res.Error = statusSkipped, err.Error()
+			case err != nil:
+				res.Status, res.Error = statusError, err.Error()
+			}
+			writeJSON(stdout, res)
+		} else if errors.Is(err, errSkipped) {
+			log.warnf("SKIP %s: %v", entries[i].name(), err)
+		} else if err != nil && !errors.Is(err, errInterrupted) {
+			log.logf(levelError, "FAIL %s: %v", entries[i].name(), err)
+		}
+	}
+
+	switch {
+	case *countOnly:
+		// stdout is the bare number, for scripts; the tally of blobs that
+		// did not count goes to stderr.
+		fmt.Fprintln(stdout, total)
+		if failed > 0 || interrupted > 0 {
+			log.warnf("batch: total covers %d of %d entries; %d failed, %d not done", len(entries)-failed-interrupted, len(entries), failed, interrupted)
+		}
+	case !*jsonOut:
+		if interrupted > 0 {
+			fmt.Fprint(stdout, "batch: interrupted; ")
+		} else {
+			fmt.Fprint(stdout, "batch: ")
+		}
+		fmt.Fprintf(stdout, "%d succeeded, %d failed", len(entries)-failed-interrupted-skipped, failed)
+		if failed > 0 {
+			fmt.Fprintf(stdout, " (%s)", formatKinds(kinds))
+		}
+		if skipped > 0 {
+			fmt.Fprintf(stdout, ", %d skipped", skipped)
+		}
+		if interrupted > 0 {
+			fmt.Fprintf(stdout, ", %d not done", interrupted)
+		}
+		if resumed > 0 {
+			fmt.Fprintf(stdout, ", %d already done", resumed)
+		}
+		fmt.Fprintln(stdout)
+	}
+	if failed > 0 || interrupted > 0 || tarErr != nil {
+		return exitFailure
+	}
+	return 0
+}
@@ -0,0 +1,546 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/batch_test.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/pbkdf2"
+
+	"decrypt/n2s"
+)
+
+// sealSharedSalt seals n plaintexts under one salt with distinct nonces,
+// as happens when a passphrase group shares a salt.
+func sealSharedSalt(t *testing.T, password string, n int) []manifestEntry {
+	t.Helper()
+	salt := bytes.Repeat([]byte{0x5a}, n2s.SaltLen)
+	key := pbkdf2.Key([]byte(password), salt, n2s.LegacyIterations, n2s.KeyLen, sha256.New)
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []manifestEntry
+	for i := 0; i < n; i++ {
+		nonce := bytes.Repeat([]byte{byte(i)}, n2s.NonceLen)
+		ct := aead.Seal(nil, nonce, []byte(fmt.Sprintf("plaintext %d", i)), nil)
+		entries = append(entries, manifestEntry{
+			BlobID:     hex.EncodeToString(append(append([]byte{}, salt...), nonce...)),
+			Ciphertext: base64.StdEncoding.EncodeToString(ct),
+		})
+	}
+	return entries
+}
+
+func writeManifest(t *testing.T, entries []manifestEntry) string {
+	t.Helper()
+	var sb strings.Builder
+	for _, e := range entries {
+		sb.WriteString(e.BlobID + "\t" + e.Ciphertext + "\n")
+	}
+	path := filepath.Join(t.TempDir(), "manifest.tsv")
+	if err := os.WriteFile(path, []byte(sb.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBatchMixedEntries(t *testing.T) {
+	entries := sealSharedSalt(t, "pw", 3)
+
+	tampered := entries[1]
+	ct, _ := base64.StdEncoding.DecodeString(tampered.Ciphertext)
+	ct[0] ^= 0x01
+	tampered.Ciphertext = base64.StdEncoding.EncodeToString(ct)
+	entries[1] = tampered
+	entries = append(entries, manifestEntry{BlobID: "zz", Ciphertext: "AAAA"})
+
+	out := t.TempDir()
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"batch", "-out", out, writeManifest(t, entries), "-"}, strings.NewReader("pw\n"), &stdout, &stderr)
+	if code == 0 {
+		t.Fatal("batch with failures exited 0")
+	}
+	if !strings.Contains(stdout.String(), "2 succeeded, 2 failed (1 auth, 1 bad hex)") {
+		t.Errorf("summary %q", stdout.String())
+	}
+
+	for i, e := range entries {
+		got, err := os.ReadFile(filepath.Join(out, e.BlobID))
+		switch i {
+		case 0, 2:
+			if err != nil || string(got) != fmt.Sprintf("plaintext %d", i) {
+				t.Errorf("entry %d: %q, %v", i, got, err)
+			}
+		default:
+			if err == nil {
+				t.Errorf("entry %d: failed entry produced output", i)
+			}
+		}
+	}
+}
+
+func TestBatchJSONManifest(t *testing.T) {
+	entries := sealSharedSalt(t, "pw", 2)
+	data, _ := json.Marshal(entries)
+
+	out := t.TempDir()
+	pwFile := filepath.Join(t.TempDir(), "pw")
+	os.WriteFile(pwFile, []byte("pw"), 0o600)
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"batch", "-password-file", pwFile, "-out", out, "-"}, bytes.NewReader(data), &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr.String())
+	}
+	for i, e := range entries {
+		if got, _ := os.ReadFile(filepath.Join(out, e.BlobID)); string(got) != fmt.Sprintf("plaintext %d", i) {
+			t.Errorf("entry %d: %q", i, got)
+		}
+	}
+}
+
+func TestKeyCacheDerivesOncePerSalt(t *testing.T) {
+	cache := newKeyCache("pw")
+	for _, e := range sealSharedSalt(t, "pw", 4) {
+		if _, err := decryptEntry(context.Background(), cache, e, t.TempDir(), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(cache.keys) != 1 {
+		t.Errorf("cache holds %d keys for one shared salt, want 1", len(cache.keys))
+	}
+}
+
+func TestBatchOutputIndependentOfJobs(t *testing.T) {
+	var entries []manifestEntry
+	for i := 0; i < 6; i++ {
+		blobid, ct, err := n2s.EncryptWith([]byte(fmt.Sprintf("blob %d", i)), "pw",
+			n2s.EncryptOptions{KDF: n2s.KDFParams{ID: n2s.KDFPBKDF2, Iterations: 1 << 10}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, manifestEntry{BlobID: blobid, Ciphertext: ct})
+	}
+	// Shared-salt entries exercise concurrent cache hits.
+	entries = append(entries, sealSharedSalt(t, "pw", 4)...)
+
+	var outputs []map[string]string
+	for _, jobs := range []int{1, 8} {
+		dir := t.TempDir()
+		_, errs := decryptAll(context.Background(), newKeyCache("pw"), entries, dir, jobs, nil, nil)
+		for i, err := range errs {
+			if err != nil {
+				t.Fatalf("jobs %d entry %d: %v", jobs, i, err)
+			}
+		}
+		files := make(map[string]string)
+		for _, e := range entries {
+			data, _ := os.ReadFile(filepath.Join(dir, e.BlobID))
+			files[e.BlobID] = string(data)
+		}
+		outputs = append(outputs, files)
+	}
+	for id, want := range outputs[0] {
+		if got := outputs[1][id]; got != want || want == "" {
+			t.Errorf("%s: jobs=8 wrote %q, jobs=1 wrote %q", id, got, want)
+		}
+	}
+}
+
+func BenchmarkBatchJobs(b *testing.B) {
+	var entries []manifestEntry
+	for i := 0; i < 16; i++ {
+		blobid, ct, err := n2s.Encrypt([]byte("bench"), "pw")
+		if err != nil {
+			b.Fatal(err)
+		}
+		entries = append(entries, manifestEntry{BlobID: blobid, Ciphertext: ct})
+	}
+	for _, jobs := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("jobs=%d", jobs), func(b *testing.B) {
+			dir := b.TempDir()
+			for i := 0; i < b.N; i++ {
+				decryptAll(context.Background(), newKeyCache("pw"), entries, dir, jobs, nil, nil)
+			}
+		})
+	}
+}
+
+func TestBatchRecurse(t *testing.T) {
+	root := t.TempDir()
+	entries := sealSharedSalt(t, "pw", 3)
+	files := map[string]string{
+		entries[0].BlobID + ".b64":               entries[0].Ciphertext,
+		"a/" + entries[1].BlobID + ".b64":        entries[1].Ciphertext + "\n",
+		"a/b/" + entries[2].BlobID + ".b64":      entries[2].Ciphertext,
+		"a/b/README.txt":                         "not a blob",
+		"a/" + strings.Repeat("ab", 28) + ".b64": "AAAA",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(path), 0o700)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := t.TempDir()
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"batch", "-recurse", root, "-out", out, "-"}, strings.NewReader("pw"), &stdout, &stderr)
+	if code != 1 {
+		t.Errorf("exit %d, want 1 for the one bad blob", code)
+	}
+	if got := stdout.String(); got != "batch: 3 succeeded, 1 failed (1 truncated)\n" {
+		t.Errorf("summary %q", got)
+	}
+	if !strings.Contains(stderr.String(), "skipped 1 files") {
+		t.Errorf("stderr %q lacks skip count", stderr.String())
+	}
+	for i, rel := range []string{"", "a", "a/b"} {
+		data, err := os.ReadFile(filepath.Join(out, rel, entries[i].BlobID))
+		if err != nil || string(data) != fmt.Sprintf("plaintext %d", i) {
+			t.Errorf("%s/%s: %q, %v", rel, entries[i].BlobID, data, err)
+		}
+	}
+}
+
+// cancelAfter cancels a context once it has logged n "opened" lines.
+type cancelAfter struct {
+	mu     sync.Mutex
+	n      int
+	cancel context.CancelFunc
+}
+
+func (c *cancelAfter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if bytes.Contains(p, []byte(": opened ")) {
+		if c.n--; c.n == 0 {
+			c.cancel()
+		}
+	}
+	return len(p), nil
+}
+
+func TestBatchInterrupted(t *testing.T) {
+	entries := sealSharedSalt(t, "pw", 5)
+	out := t.TempDir()
+	led, err := openLedger(filepath.Join(t.TempDir(), "ledger"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer led.Close()
+
+	// Interrupted while the second entry is between Open and its write.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	log := newLogger(&cancelAfter{n: 2, cancel: cancel}, levelInfo)
+	_, errs := decryptAll(ctx, newKeyCache("pw"), entries, out, 1, log, led)
+	if errs[0] != nil {
+		t.Errorf("entry 0: %v", errs[0])
+	}
+	for i, err := range errs[1:] {
+		if !errors.Is(err, errInterrupted) {
+			t.Errorf("entry %d: %v, want errInterrupted", i+1, err)
+		}
+	}
+
+	names, err := os.ReadDir(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0].Name() != entries[0].BlobID {
+		var got []string
+		for _, n := range names {
+			got = append(got, n.Name())
+		}
+		t.Errorf("output dir holds %v, want only %s", got, entries[0].BlobID)
+	}
+	if led.finished(entries[1], out) || !led.finished(entries[0], out) {
+		t.Error("ledger does not hold exactly the finished entry")
+	}
+}
+
+func TestBatchDryRun(t *testing.T) {
+	entries := sealSharedSalt(t, "pw", 2)
+	manifest := writeManifest(t, append(entries,
+		manifestEntry{BlobID: "not-hex", Ciphertext: "AAAA"},
+		manifestEntry{BlobID: entries[0].BlobID},
+		manifestEntry{BlobID: entries[1].BlobID, Ciphertext: "AAAA"},
+	))
+	out := filepath.Join(t.TempDir(), "out")
+
+	// No password source at all: -dry-run never asks for one.
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"batch", "-dry-run", "-out", out, manifest}, nil, &stdout, &stderr)
+	if code != exitFailure {
+		t.Errorf("exit %d, want %d: %s", code, exitFailure, stderr.String())
+	}
+	want := entries[0].BlobID + "\t" + filepath.Join(out, entries[0].BlobID) + "\n" +
+		entries[1].BlobID + "\t" + filepath.Join(out, entries[1].BlobID) + "\n" +
+		"batch: dry run, 2 to decrypt, 3 would fail\n"
+	if stdout.String() != want {
+		t.Errorf("plan:\n%s\nwant:\n%s", stdout.String(), want)
+	}
+	for _, msg := range []string{"FAIL not-hex: decoding blobid", "missing ciphertext", "is also the output of " + entries[1].BlobID} {
+		if !strings.Contains(stderr.String(), msg) {
+			t.Errorf("stderr lacks %q:\n%s", msg, stderr.String())
+		}
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("dry run created the output directory: %v", err)
+	}
+}
+
+func TestBatchLedgerResume(t *testing.T) {
+	entries := sealSharedSalt(t, "pw", 5)
+	manifest := writeManifest(t, entries)
+	out := t.TempDir()
+	ledgerPath := filepath.Join(t.TempDir(), "ledger")
+
+	// A run killed after two entries: only those reached the ledger.
+	led, err := openLedger(ledgerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, errs := decryptAll(context.Background(), newKeyCache("pw"), entries[:2], out, 1, nil, led); errs[0] != nil || errs[1] != nil {
+		t.Fatalf("first run: %v", errs)
+	}
+	led.Close()
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"batch", "-json", "-ledger", ledgerPath, "-out", out, manifest, "-"}
+	if code := run(args, strings.NewReader("pw\n"), &stdout, &stderr); code != 0 {
+		t.Fatalf("resumed run: exit %d: %s", code, stderr.String())
+	}
+	var processed []string
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		var res result
+		if err := json.Unmarshal([]byte(line), &res); err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+		processed = append(processed, res.BlobID)
+	}
+	want := []string{entries[2].BlobID, entries[3].BlobID, entries[4].BlobID}
+	if fmt.Sprint(processed) != fmt.Sprint(want) {
+		t.Errorf("resumed run processed %v, want the remaining %v", processed, want)
+	}
+	for i, e := range entries {
+		if got, _ := os.ReadFile(filepath.Join(out, e.BlobID)); string(got) != fmt.Sprintf("plaintext %d", i) {
+			t.Errorf("entry %d: %q", i, got)
+		}
+	}
+	data, _ := os.ReadFile(ledgerPath)
+	if n := strings.Count(string(data), "\n"); n != len(entries) {
+		t.Errorf("ledger has %d lines, want %d", n, len(entries))
+	}
+
+	// A ledgered entry whose output has gone missing runs again.
+	os.Remove(filepath.Join(out, entries[0].BlobID))
+	stdout.Reset()
+	args[1] = "-q"
+	if code := run(args, strings.NewReader("pw\n"), &stdout, &stderr); code != 0 {
+		t.Fatalf("third run: exit %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "1 succeeded, 0 failed, 4 already done") {
+		t.Errorf("third run summary %q", stdout.String())
+	}
+}
+
+func TestKeyCacheWipe(t *testing.T) {
+	cache := newKeyCache("pw")
+	entries := sealSharedSalt(t, "pw", 1)
+	blob, err := n2s.ParseBlobID([]byte(entries[0].BlobID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := cache.key(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.wipe()
+	if !bytes.Equal(key, make([]byte, len(key))) {
+		t.Errorf("cached key not wiped: %x", key)
+	}
+}
+
+func TestBatchShard(t *testing.T) {
+	legacyID, legacyCT := sealClassic(t, []byte("legacy"), "pw")
+	var sealed, errOut bytes.Buffer
+	if code := run([]string{"encrypt", "-allow-weak-password", "-iterations", "2048", "pw"}, strings.NewReader("headered"), &sealed, &errOut); code != 0 {
+		t.Fatalf("encrypt: exit %d: %s", code, errOut.String())
+	}
+	headeredID, headeredB64, _ := strings.Cut(strings.TrimSpace(sealed.String()), "\t")
+	entries := []manifestEntry{
+		{BlobID: string(legacyID), Ciphertext: base64.StdEncoding.EncodeToString(legacyCT)},
+		{BlobID: headeredID, Ciphertext: headeredB64},
+	}
+
+	out := t.TempDir()
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"batch", "-shard", "256", "-out", out, writeManifest(t, entries), "-"}, strings.NewReader("pw"), &stdout, &stderr); code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr.String())
+	}
+	// Headerless: the blobid's first two hex digits. Headered: the
+	// salt's, after the one-byte version 1 header.
+	want := map[string]string{
+		filepath.Join(out, string(legacyID[:2]), string(legacyID)): "legacy",
+		filepath.Join(out, headeredID[2:4], headeredID):            "headered",
+	}
+	for path, plaintext := range want {
+		if got, err := os.ReadFile(path); err != nil || string(got) != plaintext {
+			t.Errorf("%s: %q, %v", path, got, err)
+		}
+	}
+
+	if code := run([]string{"batch", "-shard", "100", "-out", out, writeManifest(t, entries), "-"}, strings.NewReader("pw"), &stdout, &stderr); code != exitUsage {
+		t.Errorf("-shard 100: exit %d", code)
+	}
+}
+
+func TestBatchCountOnly(t *testing.T) {
+	entries := sealSharedSalt(t, "pw", 4)
+	tampered := entries[3]
+	ct, _ := base64.StdEncoding.DecodeString(tampered.Ciphertext)
+	ct[0] ^= 0x01
+	tampered.Ciphertext = base64.StdEncoding.EncodeToString(ct)
+	entries[3] = tampered
+
+	// "plaintext N" is 11 bytes; the tampered fourth does not count.
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"batch", "-count-only", writeManifest(t, entries), "-"}, strings.NewReader("pw"), &stdout, &stderr)
+	if code != 1 || stdout.String() != "33\n" {
+		t.Errorf("exit %d, stdout %q, want 1 and 33: %s", code, stdout.String(), stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "total covers 3 of 4 entries") {
+		t.Errorf("stderr %q", stderr.String())
+	}
+
+	root := t.TempDir()
+	for i, e := range entries[:3] {
+		dir := filepath.Join(root, strings.Repeat("d/", i))
+		os.MkdirAll(dir, 0o700)
+		if err := os.WriteFile(filepath.Join(dir, e.BlobID+".b64"), []byte(e.Ciphertext), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stdout.Reset()
+	stderr.Reset()
+	if code := run([]string{"batch", "-count-only", "-recurse", root, "-"}, strings.NewReader("pw"), &stdout, &stderr); code != 0 || stdout.String() != "33\n" {
+		t.Errorf("-recurse: exit %d, stdout %q: %s", code, stdout.String(), stderr.String())
+	}
+
+	for _, args := range [][]string{
+		{"-count-only", "-out", t.TempDir(), "m.tsv"},
+		{"-count-only", "-json", "m.tsv"},
+		{"-count-only", "-ledger", "l", "m.tsv"},
+	} {
+		if code := run(append([]string{"batch"}, args...), nil, &stdout, &stderr); code != exitUsage {
+			t.Errorf("%q: exit %d, want %d", args, code, exitUsage)
+		}
+	}
+}
+
+func TestBatchOnDuplicate(t *testing.T) {
+	entries := sealSharedSalt(t, "pw", 3)
+	// A second ciphertext under entries[0]'s blobid, so the file shows
+	// which entry wrote it.
+	raw, _ := hex.DecodeString(entries[0].BlobID)
+	key := pbkdf2.Key([]byte("pw"), raw[:n2s.SaltLen], n2s.LegacyIterations, n2s.KeyLen, sha256.New)
+	aead, _ := chacha20poly1305.New(key)
+	dup := manifestEntry{
+		BlobID:     entries[0].BlobID,
+		Ciphertext: base64.StdEncoding.EncodeToString(aead.Seal(nil, raw[n2s.SaltLen:], []byte("duplicate"), nil)),
+	}
+	manifest := writeManifest(t, []manifestEntry{entries[0], entries[1], dup, entries[2]})
+
+	cases := []struct {
+		policy  string
+		code    int
+		content string
+		summary string
+	}{
+		{"error", exitFailure, "plaintext 0", "batch: 3 succeeded, 1 failed (1 other)\n"},
+		{"skip", 0, "plaintext 0", "batch: 3 succeeded, 0 failed, 1 skipped\n"},
+		{"overwrite", 0, "duplicate", "batch: 4 succeeded, 0 failed\n"},
+	}
+	for _, c := range cases {
+		out := t.TempDir()
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"batch", "-jobs", "4", "-on-duplicate", c.policy, "-out", out, manifest, "-"}, strings.NewReader("pw"), &stdout, &stderr)
+		if code != c.code || stdout.String() != c.summary {
+			t.Errorf("%s: exit %d, stdout %q; want %d, %q: %s", c.policy, code, stdout.String(), c.code, c.summary, stderr.String())
+		}
+		got, err := os.ReadFile(filepath.Join(out, entries[0].BlobID))
+		if err != nil || string(got) != c.content {
+			t.Errorf("%s: output %q, %v; want %q", c.policy, got, err, c.content)
+		}
+		assertOnlyFiles(t, out, sortedIDs(entries)...)
+		if c.policy != "overwrite" && !strings.Contains(stderr.String(), "is also the output of "+entries[0].BlobID) {
+			t.Errorf("%s: stderr %q", c.policy, stderr.String())
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"batch", "-on-duplicate", "rename", "-out", t.TempDir(), manifest, "-"}, strings.NewReader("pw"), &stdout, &stderr); code != exitUsage {
+		t.Errorf("-on-duplicate rename: exit %d, want %d", code, exitUsage)
+	}
+}
+
+func TestBatchCanonicalBlobID(t *testing.T) {
+	entries := sealSharedSalt(t, "pw", 2)
+	id := entries[0].BlobID
+	upper := manifestEntry{BlobID: strings.ToUpper(id), Ciphertext: entries[0].Ciphertext}
+	spaced := manifestEntry{BlobID: id[:16] + " " + id[16:], Ciphertext: entries[0].Ciphertext}
+	out := t.TempDir()
+	ledgerPath := filepath.Join(t.TempDir(), "ledger")
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"batch", "-ledger", ledgerPath, "-out", out, writeManifest(t, []manifestEntry{upper, spaced, entries[1]}), "-"}
+	if code := run(args, strings.NewReader("pw"), &stdout, &stderr); code != exitFailure {
+		t.Fatalf("exit %d, want %d: %s", code, exitFailure, stderr.String())
+	}
+	if stdout.String() != "batch: 2 succeeded, 1 failed (1 other)\n" || !strings.Contains(stderr.String(), "is also the output of "+upper.BlobID) {
+		t.Errorf("spellings of one blobid not taken for duplicates: %q, %q", stdout.String(), stderr.String())
+	}
+	assertOnlyFiles(t, out, sortedIDs(entries)...)
+	if got, _ := os.ReadFile(filepath.Join(out, id)); string(got) != "plaintext 0" {
+		t.Errorf("output %q", got)
+	}
+
+	// The ledger holds the canonical form, so another spelling resumes.
+	stdout.Reset()
+	args[len(args)-2] = writeManifest(t, []manifestEntry{spaced, entries[1]})
+	if code := run(args, strings.NewReader("pw"), &stdout, &stderr); code != 0 || stdout.String() != "batch: 0 succeeded, 0 failed, 2 already done\n" {
+		t.Errorf("resumed: exit %d, %q: %s", code, stdout.String(), stderr.String())
+	}
+}
+
+func sortedIDs(entries []manifestEntry) []string {
+	var ids []string
+	for _, e := range entries {
+		ids = append(ids, e.BlobID)
+	}
+	slices.Sort(ids)
+	return ids
+}
@@ -0,0 +1,41 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/bench_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestBenchCommand(t *testing.T) {
+	var out, errOut bytes.Buffer
+	if code := run([]string{"bench", "-n", "2", "-iterations", "1000", "-size", "1024"}, nil, &out, &errOut); code != 0 {
+		t.Fatalf("exit %d: %s", code, errOut.String())
+	}
+	for _, want := range []string{"kdf", "open", "1024-byte blobs", "blobs/sec"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("table lacks %q:\n%s", want, out.String())
+		}
+	}
+
+	out.Reset()
+	args := []string{"bench", "-json", "-n", "2", "-size", "100", "-kdf", "argon2id", "-argon2-time", "1", "-argon2-memory", "64", "-cipher", "aes-256-gcm"}
+	if code := run(args, nil, &out, &errOut); code != 0 {
+		t.Fatalf("exit %d: %s", code, errOut.String())
+	}
+	var report benchReport
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.KDF != "argon2id" || report.Cipher != "aes-256-gcm" || len(report.Stages) != 2 || report.Stages[1].Ops != 2 || report.BlobsPerSec <= 0 {
+		t.Errorf("report %+v", report)
+	}
+}
@@ -0,0 +1,103 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/blobfile.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"decrypt/n2s"
+)
+
+// A .n2s file keeps a blob self-contained so the blobid cannot be
+// separated from its ciphertext:
+//
+//	"N2S1" | blobid length uint16 (big-endian) | blobid bytes | ciphertext
+//
+// The blobid bytes are exactly what the hex blobid decodes to (header,
+// salt, nonce), so KDF parameters travel the same way in both forms and
+// the ciphertext is raw, not base64.
+var blobFileMagic = []byte("N2S1")
+
+// parseBlobFile splits a .n2s file into its blob and ciphertext.
+func parseBlobFile(data []byte) (*n2s.Blob, []byte, error) {
+	r := bytes.NewReader(data)
+	blob, err := readBlobFileHeader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return blob, data[len(data)-r.Len():], nil
+}
+
+// readBlobFileHeader consumes the magic and blobid of a .n2s file from r,
+// leaving r at the start of the ciphertext.
+func readBlobFileHeader(r io.Reader) (*n2s.Blob, error) {
+	magic := make([]byte, len(blobFileMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, blobFileMagic) {
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		return nil, &decodeError{fmt.Errorf("not a .n2s blob file: missing %q magic", blobFileMagic)}
+	}
+	var size [2]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, &decodeError{fmt.Errorf(".n2s blob file truncated")}
+	}
+	n := int(binary.BigEndian.Uint16(size[:]))
+	raw := make([]byte, n)
+	if got, err := io.ReadFull(r, raw); err != nil {
+		return nil, &decodeError{fmt.Errorf(".n2s blob file truncated: blobid wants %d bytes, %d left", n, got)}
+	}
+	return n2s.ParseBlob(raw)
+}
+
+func readBlobFile(name string, stdin io.Reader) (*n2s.Blob, []byte, error) {
+	blob, r, err := openBlobFile(name, stdin, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+	data, err := readAllCapped(r, defaultMaxCiphertext)
+	if err != nil {
+		return nil, nil, err
+	}
+	return blob, data, nil
+}
+
+// openBlobFile reads a .n2s file's blobid and returns the blob with a
+// reader positioned at its raw ciphertext. The file's bytes count
+// towards meter, which may be nil.
+func openBlobFile(name string, stdin io.Reader, meter *progress) (*n2s.Blob, io.ReadCloser, error) {
+	f, err := openInput(name, stdin)
+	if err != nil {
+		return nil, nil, err
+	}
+	f = meter.input(f)
+	r := &labelReader{r: f, label: "reading blob file"}
+	blob, err := readBlobFileHeader(r)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return blob, readCloser{r, f}, nil
+}
+
+func encodeBlobFile(blobid, ciphertext []byte) []byte {
+	out := make([]byte, 0, len(blobFileMagic)+2+len(blobid)+len(ciphertext))
+	out = append(out, blobFileMagic...)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(blobid)))
+	out = append(out, blobid...)
+	return append(out, ciphertext...)
+}
+
+func writeBlobFile(path string, blobid, ciphertext []byte) error {
+	return writeAtomic(path, encodeBlobFile(blobid, ciphertext))
+}
@@ -0,0 +1,88 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/blobfile_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"decrypt/n2s"
+)
+
+func TestBlobFileRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	pwFile := filepath.Join(dir, "pw")
+	os.WriteFile(pwFile, []byte("pw\n"), 0o600)
+	path := filepath.Join(dir, "note.n2s")
+
+	var out, errOut bytes.Buffer
+	code := run([]string{"encrypt", "-allow-weak-password", "-password-file", pwFile, "-iterations", "2048", "-blobfile", path},
+		strings.NewReader("single file"), &out, &errOut)
+	if code != 0 {
+		t.Fatalf("encrypt exit %d: %s", code, errOut.String())
+	}
+	blobid := strings.TrimSpace(out.String())
+
+	out.Reset()
+	if code := run([]string{"-password-file", pwFile, "-blobfile", path}, nil, &out, &errOut); code != 0 {
+		t.Fatalf("decrypt exit %d: %s", code, errOut.String())
+	}
+	if out.String() != "single file" {
+		t.Errorf("plaintext %q", out.String())
+	}
+
+	// The file and the printed hex blobid describe the same blob.
+	data, _ := os.ReadFile(path)
+	fromFile, _, err := parseBlobFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromHex, err := n2s.ParseBlobID([]byte(blobid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromFile, fromHex) {
+		t.Errorf("file blob %+v != hex blob %+v", fromFile, fromHex)
+	}
+	if fromFile.KDF.Iterations != 2048 {
+		t.Errorf("iterations %d, want 2048", fromFile.KDF.Iterations)
+	}
+}
+
+func TestParseBlobFileMalformed(t *testing.T) {
+	blobid, _ := hex.DecodeString(strings.Repeat("ab", 32))
+	good := encodeBlobFile(blobid, []byte("ciphertext"))
+	cases := map[string][]byte{
+		"empty":          nil,
+		"bad magic":      append([]byte("N2S0"), good[4:]...),
+		"no length":      good[:5],
+		"short blobid":   good[:20],
+		"invalid blobid": encodeBlobFile(blobid[:10], nil),
+	}
+	for name, data := range cases {
+		if _, _, err := parseBlobFile(data); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+	if _, ct, err := parseBlobFile(good); err != nil || string(ct) != "ciphertext" {
+		t.Errorf("good file: %q, %v", ct, err)
+	}
+}
+
+func TestDecryptBlobFileRejectsIn(t *testing.T) {
+	var out, errOut bytes.Buffer
+	if code := run([]string{"-blobfile", "x.n2s", "-in", "y"}, nil, &out, &errOut); code == 0 {
+		t.Fatal("-blobfile with -in accepted")
+	}
+}
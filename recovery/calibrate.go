@@ -0,0 +1,128 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/calibrate.go
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"decrypt/n2s"
+)
+
+// calibrate returns the cost at which one derivation takes about target.
+// The cost doubles from start until the median of trials measurements
+// reaches target, then is scaled back linearly, since both KDFs take time
+// proportional to their cost. The result never exceeds maxCost.
+func calibrate(target time.Duration, trials, start, maxCost int, measure func(cost int) time.Duration) int {
+	cost := start
+	for {
+		elapsed := medianDuration(trials, func() time.Duration { return measure(cost) })
+		if elapsed >= target || cost >= maxCost {
+			if elapsed <= 0 {
+				return cost
+			}
+			scaled := int(float64(cost) * float64(target) / float64(elapsed))
+			return min(max(scaled, 1), maxCost)
+		}
+		cost = min(2*cost, maxCost)
+	}
+}
+
+// medianDuration runs measure trials times and returns the median, which
+// shrugs off the odd run slowed by a context switch.
+func medianDuration(trials int, measure func() time.Duration) time.Duration {
+	times := make([]time.Duration, max(trials, 1))
+	for i := range times {
+		times[i] = measure()
+	}
+	slices.Sort(times)
+	return times[len(times)/2]
+}
+
+// timeDerive measures one key derivation with params.
+func timeDerive(params n2s.KDFParams) time.Duration {
+	salt := make([]byte, n2s.SaltLen)
+	start := time.Now()
+	key, err := n2s.DeriveKey("calibrate", salt, params, n2s.KeyLen)
+	elapsed := time.Since(start)
+	if err == nil {
+		n2s.Wipe(key)
+	}
+	return elapsed
+}
+
+// runCalibrate prints encrypt flags whose derivation takes about
+// -target-ms on this machine.
+func runCalibrate(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("calibrate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	targetMS := fs.Int("target-ms", 500, "derivation time to aim for, in `milliseconds`")
+	kdfName := fs.String("kdf", "pbkdf2", "key derivation to calibrate: pbkdf2 or argon2id")
+	trials := fs.Int("trials", 3, "measurements per step; the median is used")
+	argonMemory := fs.Uint("argon2-memory", n2s.DefaultArgon2Memory, "Argon2id memory in `KiB`, held fixed while the pass count is tuned")
+	argonThreads := fs.Uint("argon2-threads", n2s.DefaultArgon2Threads, "Argon2id parallelism")
+	logf := addLogFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return exitUsage
+	}
+	level, err := logf.level()
+	log := newLogger(stderr, level)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	if *targetMS < 1 || fs.NArg() != 0 {
+		fmt.Fprintf(stderr, "Usage: %s calibrate [-target-ms N] [-kdf pbkdf2|argon2id] [-trials N]\n", os.Args[0])
+		return exitUsage
+	}
+	target := time.Duration(*targetMS) * time.Millisecond
+
+	var params n2s.KDFParams
+	var flags string
+	switch *kdfName {
+	case "pbkdf2":
+		n := calibrate(target, *trials, 1024, 1<<32-1, func(cost int) time.Duration {
+			return timeDerive(n2s.KDFParams{ID: n2s.KDFPBKDF2, Iterations: cost})
+		})
+		params = n2s.KDFParams{ID: n2s.KDFPBKDF2, Iterations: n}
+		flags = fmt.Sprintf("-kdf pbkdf2 -iterations %d", n)
+	case "argon2id":
+		if *argonThreads < 1 || *argonThreads > 255 {
+			log.errorf("-argon2-threads %d out of range [1, 255]", *argonThreads)
+			return exitUsage
+		}
+		mem, threads := uint32(*argonMemory), uint8(*argonThreads)
+		probe := n2s.KDFParams{ID: n2s.KDFArgon2id, Time: 1, Memory: mem, Threads: threads}
+		if _, err := n2s.DeriveKey("calibrate", make([]byte, n2s.SaltLen), probe, n2s.KeyLen); err != nil {
+			log.errorf("%v", err)
+			return exitUsage
+		}
+		t := calibrate(target, *trials, 1, 1<<16, func(cost int) time.Duration {
+			return timeDerive(n2s.KDFParams{ID: n2s.KDFArgon2id, Time: uint32(cost), Memory: mem, Threads: threads})
+		})
+		params = n2s.KDFParams{ID: n2s.KDFArgon2id, Time: uint32(t), Memory: mem, Threads: threads}
+		flags = fmt.Sprintf("-kdf argon2id -argon2-time %d -argon2-memory %d -argon2-threads %d", t, mem, threads)
+	default:
+		log.errorf("unknown -kdf %q", *kdfName)
+		return exitUsage
+	}
+
+	elapsed := medianDuration(*trials, func() time.Duration { return timeDerive(params) })
+	log.logf(levelWarn, "calibrate: %s takes %v here (target %v)", params.ID, elapsed.Round(time.Millisecond), target)
+	fmt.Fprintln(stdout, flags)
+	return 0
+}
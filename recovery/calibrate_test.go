@@ -0,0 +1,61 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/calibrate_test.go
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"decrypt/n2s"
+)
+
+func TestCalibrateMonotonic(t *testing.T) {
+	// A fake KDF costing 3µs per unit, with one slow outlier per step that
+	// the median must ignore.
+	var calls int
+	measure := func(cost int) time.Duration {
+		calls++
+		if calls%3 == 0 {
+			return time.Hour
+		}
+		return time.Duration(cost) * 3 * time.Microsecond
+	}
+
+	prev := 0
+	for _, ms := range []int{10, 50, 250, 500, 2000} {
+		got := calibrate(time.Duration(ms)*time.Millisecond, 3, 1024, 1<<32-1, measure)
+		if got <= prev {
+			t.Errorf("target %dms: %d iterations, not above %d", ms, got, prev)
+		}
+		want := ms * 1000 / 3
+		if got < want*9/10 || got > want*11/10 {
+			t.Errorf("target %dms: %d iterations, want about %d", ms, got, want)
+		}
+		prev = got
+	}
+}
+
+func TestCalibrateCapsCost(t *testing.T) {
+	got := calibrate(time.Second, 1, 1, 64, func(int) time.Duration { return time.Millisecond })
+	if got != 64 {
+		t.Errorf("calibrate = %d, want the cap 64", got)
+	}
+}
+
+func TestCalibratePBKDF2Real(t *testing.T) {
+	small := calibrate(5*time.Millisecond, 3, 1024, 1<<32-1, func(n int) time.Duration {
+		return timeDerive(n2s.KDFParams{ID: n2s.KDFPBKDF2, Iterations: n})
+	})
+	large := calibrate(40*time.Millisecond, 3, 1024, 1<<32-1, func(n int) time.Duration {
+		return timeDerive(n2s.KDFParams{ID: n2s.KDFPBKDF2, Iterations: n})
+	})
+	if large <= small {
+		t.Errorf("40ms target gave %d iterations, 5ms gave %d", large, small)
+	}
+}
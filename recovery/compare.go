@@ -0,0 +1,121 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/compare.go
+
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"decrypt/n2s"
+)
+
+// runCompare decrypts two blobs in memory and reports whether their
+// plaintexts are byte-identical, for dedup tooling that must not write
+// either to disk. It exits 0 when they are and exitDifferent when they
+// differ; any failure to compare them exits above that, with
+// exitTrouble in place of exitFailure.
+func runCompare(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("compare", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	passwordFile := fs.String("password-file", "", "read the first blob's password from `file`")
+	passwordFile2 := fs.String("password-file2", "", "read the second blob's password from `file`; without it, and without a second password argument, the first password opens both")
+	normalize := fs.String("normalize", "none", "Unicode-normalize the passwords before deriving the keys: nfc, nfd or none; changes the keys")
+	logf := addLogFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return exitUsage
+	}
+	level, err := logf.level()
+	log := newLogger(stderr, level)
+	if err != nil {
+		log.errorf("%v", err)
+		return compareFailureCode(err)
+	}
+
+	// <blobid> [password|-] <encrypted_b64>, twice: four arguments
+	// without password arguments, six with.
+	pos := fs.Args()
+	var blobids, ciphertexts [2]string
+	var passwords [2][]string
+	switch len(pos) {
+	case 4:
+		blobids, ciphertexts = [2]string{pos[0], pos[2]}, [2]string{pos[1], pos[3]}
+	case 6:
+		blobids, ciphertexts = [2]string{pos[0], pos[3]}, [2]string{pos[2], pos[5]}
+		passwords = [2][]string{{pos[1]}, {pos[4]}}
+		if pos[1] == "-" && pos[4] == "-" {
+			log.errorf("stdin can carry only one of the two passwords")
+			return exitUsage
+		}
+	default:
+		fmt.Fprintf(stderr, "Usage: %s compare [-password-file file] [-password-file2 file] <blobid1> <encrypted_b64_1> <blobid2> <encrypted_b64_2>\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s compare <blobid1> <password1|-> <encrypted_b64_1> <blobid2> <password2|-> <encrypted_b64_2>\n", os.Args[0])
+		fmt.Fprintf(stderr, "Exit status: 0 identical, %d different, %d or more if the two could not be compared\n", exitDifferent, exitUsage)
+		return exitUsage
+	}
+	form, err := parseNormalize(*normalize)
+	if err != nil {
+		log.errorf("%v", err)
+		return compareFailureCode(err)
+	}
+
+	var plaintexts [2][]byte
+	defer func() {
+		n2s.Wipe(plaintexts[0])
+		n2s.Wipe(plaintexts[1])
+	}()
+	var password string
+	for i := range 2 {
+		switch {
+		case i == 0:
+			password, err = decryptPassword(passwords[0], *passwordFile, false, stdin, stderr, log)
+		case passwords[1] != nil || *passwordFile2 != "":
+			password, err = decryptPassword(passwords[1], *passwordFile2, false, stdin, stderr, log)
+		}
+		if err == nil {
+			plaintexts[i], err = compareOpen(blobids[i], ciphertexts[i], normalizePassword(password, form))
+		}
+		if err != nil {
+			log.errorf("blob %d: %v", i+1, err)
+			return compareFailureCode(err)
+		}
+		fmt.Fprintf(stdout, "blob %d: %d bytes\n", i+1, len(plaintexts[i]))
+	}
+
+	// ConstantTimeCompare returns at once on a length mismatch, but the
+	// lengths are no secret: they have just been printed.
+	if subtle.ConstantTimeCompare(plaintexts[0], plaintexts[1]) == 1 {
+		fmt.Fprintln(stdout, "identical")
+		return 0
+	}
+	fmt.Fprintln(stdout, "different")
+	return exitDifferent
+}
+
+// compareFailureCode is failureCode, but never exitDifferent.
+func compareFailureCode(err error) int {
+	if code := failureCode(err); code != exitFailure {
+		return code
+	}
+	return exitTrouble
+}
+
+func compareOpen(blobid, ciphertextB64, password string) ([]byte, error) {
+	ciphertext, err := decodeBase64(ciphertextB64)
+	if err != nil {
+		return nil, err
+	}
+	return n2s.Decrypt([]byte(blobid), ciphertext, nil, password)
+}
@@ -0,0 +1,78 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/compare_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"decrypt/n2s"
+)
+
+func TestCompare(t *testing.T) {
+	seal := func(plaintext, password string) (string, string) {
+		blobid, ct := sealClassic(t, []byte(plaintext), password)
+		return string(blobid), base64.StdEncoding.EncodeToString(ct)
+	}
+	id1, ct1 := seal("the same plaintext", "pw")
+	pwFile := writePasswordFile(t, "pw")
+
+	cases := []struct {
+		name     string
+		other    string
+		code     int
+		wantLine string
+	}{
+		{"equal", "the same plaintext", 0, "identical"},
+		{"unequal, same length", "the same plaintexT", exitDifferent, "different"},
+		{"unequal, different length", "the same plaintext!", exitDifferent, "different"},
+	}
+	for _, c := range cases {
+		id2, ct2 := seal(c.other, "pw")
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"compare", "-password-file", pwFile, id1, ct1, id2, ct2}, nil, &stdout, &stderr)
+		want := fmt.Sprintf("blob 1: 18 bytes\nblob 2: %d bytes\n%s\n", len(c.other), c.wantLine)
+		if code != c.code || stdout.String() != want {
+			t.Errorf("%s: exit %d, stdout %q; want %d, %q: %s", c.name, code, stdout.String(), c.code, want, stderr.String())
+		}
+	}
+
+	// A different password per blob, given as arguments.
+	id2, ct2 := seal("the same plaintext", "other")
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"compare", id1, "-", ct1, id2, "other", ct2}, strings.NewReader("pw"), &stdout, &stderr); code != 0 {
+		t.Errorf("six arguments: exit %d: %s", code, stderr.String())
+	}
+	stdout.Reset()
+	if code := run([]string{"compare", "-password-file", pwFile, id1, ct1, id2, ct2}, nil, &stdout, &stderr); code != exitAuthFailed {
+		t.Errorf("wrong second password: exit %d, want %d", code, exitAuthFailed)
+	}
+	if code := run([]string{"compare", "-password-file", pwFile, "-password-file2", writePasswordFile(t, "other"), id1, ct1, id2, ct2}, nil, &stdout, &stderr); code != 0 {
+		t.Errorf("-password-file2: exit %d: %s", code, stderr.String())
+	}
+}
+
+func TestCompareFailureCode(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		want int
+	}{
+		{errors.New("something else"), exitTrouble},
+		{usageErrorf("bad"), exitUsage},
+		{n2s.ErrAuthFailed, exitAuthFailed},
+	} {
+		if got := compareFailureCode(c.err); got != c.want || got == exitDifferent {
+			t.Errorf("compareFailureCode(%v) = %d, want %d", c.err, got, c.want)
+		}
+	}
+}
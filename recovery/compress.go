@@ -0,0 +1,92 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/compress.go
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+
+	"decrypt/n2s"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	// n2s itself compresses with lz4, which this tool does not decode.
+	lz4Magic = []byte{0x04, 0x22, 0x4d, 0x18}
+)
+
+// newDecompressor picks a decompressor from the magic bytes at the start
+// of data. Uncompressed input is an error: silently passing it through
+// would hide a wrong -decompress assumption.
+func newDecompressor(data []byte) (io.ReadCloser, error) {
+	switch {
+	case bytes.HasPrefix(data, gzipMagic):
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		return zr, nil
+	case bytes.HasPrefix(data, zstdMagic):
+		zr, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("zstd: %w", err)
+		}
+		return zr.IOReadCloser(), nil
+	case bytes.HasPrefix(data, lz4Magic):
+		return nil, fmt.Errorf("plaintext is lz4-compressed; pipe the output through 'lz4 -d' instead")
+	}
+	return nil, fmt.Errorf("plaintext has no gzip or zstd header")
+}
+
+// defaultMaxPlaintext caps decompressed output, which is held in memory:
+// a few kilobytes of gzip can expand to gigabytes of zeros.
+const defaultMaxPlaintext = 4 << 30
+
+// decompress fully expands gzip- or zstd-compressed data, failing once
+// the output passes limit bytes (0 is no limit).
+func decompress(data []byte, limit int64) ([]byte, error) {
+	zr, err := newDecompressor(data)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	out, err := readExpanded(zr, limit)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing: %w", err)
+	}
+	return out, nil
+}
+
+// readExpanded is io.ReadAll of a decompressor refusing more than limit
+// bytes, or without a cap when limit is 0. It reads at most one byte past
+// limit to find out, and wipes what it read before giving up.
+func readExpanded(r io.Reader, limit int64) ([]byte, error) {
+	if limit > 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+	out, err := io.ReadAll(r)
+	if err == nil && limit > 0 && int64(len(out)) > limit {
+		err = exceedsLimit(limit)
+	}
+	if err != nil {
+		n2s.Wipe(out)
+		return nil, err
+	}
+	return out, nil
+}
+
+// exceedsLimit is the error for output expanded past -max-plaintext.
+func exceedsLimit(limit int64) error {
+	return &decodeError{fmt.Errorf("decompressed output exceeds limit of %d bytes; raise -max-plaintext, or 0 for none", limit)}
+}
@@ -0,0 +1,105 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/compress_test.go
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zw.Close()
+	return zw.EncodeAll(data, nil)
+}
+
+func TestDecryptDecompress(t *testing.T) {
+	note := []byte(strings.Repeat("field report ", 50))
+	cases := map[string][]byte{
+		"gzip": gzipBytes(t, note),
+		"zstd": zstdBytes(t, note),
+	}
+	for name, compressed := range cases {
+		blobid, ciphertext := sealClassic(t, compressed, "pw")
+		var out, errOut bytes.Buffer
+		code := run([]string{"-decompress", string(blobid), "-", base64.StdEncoding.EncodeToString(ciphertext)},
+			strings.NewReader("pw"), &out, &errOut)
+		if code != 0 {
+			t.Fatalf("%s: exit %d: %s", name, code, errOut.String())
+		}
+		if !bytes.Equal(out.Bytes(), note) {
+			t.Errorf("%s: decompressed output mismatch", name)
+		}
+	}
+}
+
+func TestDecryptDecompressRejectsUncompressed(t *testing.T) {
+	blobid, ciphertext := sealClassic(t, []byte("plain text"), "pw")
+	b64 := base64.StdEncoding.EncodeToString(ciphertext)
+
+	var out, errOut bytes.Buffer
+	if code := run([]string{"-decompress", string(blobid), "-", b64}, strings.NewReader("pw"), &out, &errOut); code == 0 {
+		t.Fatal("-decompress accepted uncompressed plaintext")
+	}
+	if out.Len() != 0 {
+		t.Errorf("stdout %q, want empty", out.String())
+	}
+
+	out.Reset()
+	if code := run([]string{string(blobid), "-", b64}, strings.NewReader("pw"), &out, &errOut); code != 0 || out.String() != "plain text" {
+		t.Errorf("without -decompress: exit %d, %q", code, out.String())
+	}
+}
+
+func TestDecryptDecompressLimit(t *testing.T) {
+	bomb := make([]byte, 1<<20)
+	cases := map[string][]string{
+		"-decompress":      {"-decompress"},
+		"-pipeline gunzip": {"-pipeline", "gunzip"},
+	}
+	blobid, ciphertext := sealClassic(t, gzipBytes(t, bomb), "pw")
+	b64 := base64.StdEncoding.EncodeToString(ciphertext)
+	for name, flags := range cases {
+		var out, errOut bytes.Buffer
+		args := append(append([]string{"-max-plaintext", "65536"}, flags...), string(blobid), "-", b64)
+		if code := run(args, strings.NewReader("pw"), &out, &errOut); code != exitDecode {
+			t.Errorf("%s: exit %d, want %d: %s", name, code, exitDecode, errOut.String())
+		}
+		if out.Len() != 0 || !strings.Contains(errOut.String(), "decompressed output exceeds limit of 65536 bytes") {
+			t.Errorf("%s: stdout %d bytes, stderr %q", name, out.Len(), errOut.String())
+		}
+
+		out.Reset()
+		errOut.Reset()
+		args = append(append([]string{"-max-plaintext", "0", "-force-binary"}, flags...), string(blobid), "-", b64)
+		if code := run(args, strings.NewReader("pw"), &out, &errOut); code != 0 || out.Len() != len(bomb) {
+			t.Errorf("%s with no limit: exit %d, %d bytes: %s", name, code, out.Len(), errOut.String())
+		}
+	}
+}
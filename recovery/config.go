@@ -0,0 +1,87 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/config.go
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// configKeys maps each -config key to the flag whose default it sets.
+// Only encrypt and rekey take -config; decrypt reads everything it needs
+// from the blob.
+var configKeys = map[string]string{
+	"kdf":            "kdf",
+	"iterations":     "iterations",
+	"argon2_time":    "argon2-time",
+	"argon2_memory":  "argon2-memory",
+	"argon2_threads": "argon2-threads",
+	"cipher":         "cipher",
+	"nonce_length":   "nonce-length",
+	"normalize":      "normalize",
+}
+
+// applyConfig reads a JSON object of project defaults, e.g.
+//
+//	{"kdf": "argon2id", "argon2_memory": 262144, "cipher": "aes-256-gcm"}
+//
+// and sets each flag it names that was not given on the command line, so
+// an explicit flag still wins. Unknown keys are all reported at once.
+func applyConfig(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("config %s: %w", path, err)
+	}
+	var unknown []string
+	for key := range values {
+		if _, ok := configKeys[key]; !ok {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		slices.Sort(unknown)
+		return usageErrorf("config %s: unknown keys %s (have %s)", path, strings.Join(unknown, ", "),
+			strings.Join(slices.Sorted(maps.Keys(configKeys)), ", "))
+	}
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		name := configKeys[key]
+		if given[name] {
+			continue
+		}
+		var v any
+		if err := json.Unmarshal(values[key], &v); err != nil {
+			return fmt.Errorf("config %s: %s: %w", path, key, err)
+		}
+		var s string
+		switch v := v.(type) {
+		case string:
+			s = v
+		case float64:
+			s = string(values[key])
+		default:
+			return usageErrorf("config %s: %s must be a string or a number", path, key)
+		}
+		if err := fs.Set(name, s); err != nil {
+			return usageErrorf("config %s: %s: %v", path, key, err)
+		}
+	}
+	return nil
+}
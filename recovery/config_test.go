@@ -0,0 +1,67 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/config_test.go
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"decrypt/n2s"
+)
+
+func encryptBlob(t *testing.T, args ...string) *n2s.Blob {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	if code := run(append([]string{"encrypt", "-allow-weak-password"}, args...), strings.NewReader("pw\nplaintext"), &out, &errOut); code != 0 {
+		t.Fatalf("encrypt %q: exit %d: %s", args, code, errOut.String())
+	}
+	blobid, _, _ := strings.Cut(out.String(), "\t")
+	blob, err := n2s.ParseBlobID([]byte(blobid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blob
+}
+
+func TestEncryptConfig(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "n2s.json")
+	os.WriteFile(config, []byte(`{"iterations": 4096, "cipher": "aes-256-gcm"}`), 0o600)
+
+	blob := encryptBlob(t, "-config", config)
+	if blob.KDF.Iterations != 4096 || blob.Cipher != n2s.CipherAES256GCM {
+		t.Errorf("from config: %d iterations, %s", blob.KDF.Iterations, blob.CipherName())
+	}
+	blob = encryptBlob(t, "-config", config, "-iterations", "2048")
+	if blob.KDF.Iterations != 2048 || blob.Cipher != n2s.CipherAES256GCM {
+		t.Errorf("flag over config: %d iterations, %s", blob.KDF.Iterations, blob.CipherName())
+	}
+}
+
+func TestConfigUnknownKeys(t *testing.T) {
+	dir := t.TempDir()
+	cases := map[string]string{
+		"unknown keys": `{"iteration": 4096, "ciphr": "aes-256-gcm", "kdf": "pbkdf2"}`,
+		"bad value":    `{"iterations": "many"}`,
+		"not scalar":   `{"cipher": ["aes-256-gcm"]}`,
+	}
+	for name, content := range cases {
+		config := filepath.Join(dir, name)
+		os.WriteFile(config, []byte(content), 0o600)
+		var out, errOut bytes.Buffer
+		if code := run([]string{"encrypt", "-config", config}, strings.NewReader("pw\nx"), &out, &errOut); code != exitUsage || out.Len() != 0 {
+			t.Errorf("%s: exit %d, stdout %q", name, code, out.String())
+		}
+		if name == "unknown keys" && !strings.Contains(errOut.String(), "unknown keys ciphr, iteration") {
+			t.Errorf("%s: stderr %q", name, errOut.String())
+		}
+	}
+}
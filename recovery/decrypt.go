@@ -9,61 +9,630 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha256"
-	"encoding/base64"
-	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"time"
 
-	"golang.org/x/crypto/chacha20poly1305"
-	"golang.org/x/crypto/pbkdf2"
+	"decrypt/n2s"
 )
 
 func main() {
-	if len(os.Args) != 4 {
-		fmt.Fprintf(os.Stderr, "Usage: %s <blobid> <password> <encrypted_b64>\n", os.Args[0])
-		os.Exit(1)
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run dispatches to a subcommand and returns the process exit code. The
+// classic three-argument form (no subcommand) decrypts.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "audit":
+			return runAudit(args[1:], stdin, stdout, stderr)
+		case "batch":
+			return runBatch(args[1:], stdin, stdout, stderr)
+		case "bench":
+			return runBench(args[1:], stdout, stderr)
+		case "calibrate":
+			return runCalibrate(args[1:], stdout, stderr)
+		case "compare":
+			return runCompare(args[1:], stdin, stdout, stderr)
+		case "derivekey":
+			return runDerivekey(args[1:], stdin, stdout, stderr)
+		case "encrypt":
+			return runEncrypt(args[1:], stdin, stdout, stderr)
+		case "formats":
+			return runFormats(args[1:], stdout, stderr)
+		case "info":
+			return runInfo(args[1:], stdin, stdout, stderr)
+		case "migrate":
+			return runMigrate(args[1:], stdin, stdout, stderr)
+		case "rekey":
+			return runRekey(args[1:], stdin, stdout, stderr)
+		case "seal-manifest":
+			return runSealManifest(args[1:], stdin, stdout, stderr)
+		case "selftest":
+			return runSelftest(args[1:], stdout, stderr)
+		case "serve":
+			return runServe(args[1:], stderr)
+		case "verify-manifest":
+			return runVerifyManifest(args[1:], stdin, stdout, stderr)
+		}
 	}
+	return runDecrypt(args, stdin, stdout, stderr)
+}
 
-	blobid := os.Args[1]
-	password := os.Args[2]
-	encryptedB64 := os.Args[3]
+func decryptUsage(fs *flag.FlagSet, stderr io.Writer) func() {
+	return func() {
+		fmt.Fprintf(stderr, "Usage: %s [flags] <blobid> [password|-] <encrypted_b64>\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s [flags] -in <file|-> <blobid> [password]\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s [flags] -blobfile <file.n2s|-> [password]\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s [flags] -salt <hex> -nonce <hex> [password|-] <encrypted_b64>\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s encrypt [flags] [password] < plaintext\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s audit <manifest|->\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s batch [flags] -out <dir> <manifest|-> [password|-]\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s bench [-n N] [-size bytes] [-kdf pbkdf2|argon2id] [-json]\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s calibrate [-target-ms N] [-kdf pbkdf2|argon2id]\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s compare [flags] <blobid1> <encrypted_b64_1> <blobid2> <encrypted_b64_2>\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s derivekey -unsafe-print-key [flags] <blobid> [password|-]\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s formats [-json]\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s info [flags] <blobid> [encrypted_b64]\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s migrate [flags] <blobid> [password|-] <encrypted_b64>\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s rekey [flags] <blobid> <encrypted_b64>\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s seal-manifest -key-file <file> -out <signature> <manifest|-|-recurse dir>\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s selftest\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s serve -secret-file <file> [-addr host:port]\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s verify-manifest -key-file <file> -sig <signature> <manifest|-|-recurse dir>\n", os.Args[0])
+		fmt.Fprintln(stderr, "\nWith no password argument, the password is prompted for on the terminal.")
+		writeExitCodes(stderr)
+		fmt.Fprintln(stderr, "\nFlags:")
+		fs.PrintDefaults()
+	}
+}
 
-	// Decode blobid from hex
-	blobBytes, err := hex.DecodeString(blobid)
+func runDecrypt(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = decryptUsage(fs, stderr)
+	passwordFile := fs.String("password-file", "", "read the password from `file`")
+	in := fs.String("in", "", "read base64 ciphertext from `file` ('-' for stdin, or an http:// or https:// URL)")
+	blobFile := fs.String("blobfile", "", "read blobid and ciphertext from a single .n2s `file` ('-' for stdin)")
+	verify := fs.Bool("verify", false, "authenticate the ciphertext and discard the plaintext; only the exit status reports the result")
+	outFile := fs.String("out", "", "write the plaintext atomically to `file` (mode 0600) instead of stdout")
+	outEncoding := fs.String("out-encoding", encodingRaw, "write the plaintext as raw bytes, hex or base64 (`encoding`), to stdout, -out and -tee alike")
+	noNewline := fs.Bool("no-newline", false, "with -out-encoding hex or base64, leave off the trailing newline")
+	tee := fs.String("tee", "", "write the plaintext to stdout and also atomically to `file` (mode 0600)")
+	aad := fs.String("aad", "", "associated `data` the blob was sealed with, e.g. its file name")
+	noWipe := fs.Bool("no-wipe", false, "leave the plaintext buffer in memory after writing it")
+	decompressOut := fs.Bool("decompress", false, "gunzip or zstd-decompress the plaintext (detected by magic bytes)")
+	jsonOut := fs.Bool("json", false, "print a JSON result object (stdout on success, stderr on failure); the plaintext then needs -out")
+	forceBinary := fs.Bool("force-binary", false, "write binary plaintext to stdout even when it is a terminal")
+	keychain := fs.String("keychain", "", "read the password from the OS keychain entry `SERVICE:ACCOUNT`")
+	passwordEnv := fs.String("password-env", "", "read the password from the environment variable `NAME`, e.g. a container secret")
+	keyHex := fs.String("key", "", "use this raw 32-byte key (64 hex characters) instead of deriving one from a password")
+	passwordList := fs.String("password-list", "", "try each password in `file` (one per line) against a single-shot blob and report the line that opens it")
+	masterKeyFile := fs.String("master-key-file", "", "unwrap an envelope blob's data key with the master key in `file` (64 hex characters)")
+	logf := addLogFlags(fs)
+	b64 := fs.String("b64", "", "decode the ciphertext as this base64 `variant` (std, url or raw) instead of detecting it")
+	rawIn := fs.Bool("raw", false, "the -in ciphertext is raw binary, not base64")
+	normalize := fs.String("normalize", "none", "Unicode-normalize the password before deriving the key: nfc, nfd or none; changes the key")
+	harden := fs.Duration("harden", 0, "hold every failure back until this `duration` (e.g. 2s) has passed, so malformed input and wrong passwords are indistinguishable by timing; 0 is off")
+	pipeline := fs.String("pipeline", "", "pass the plaintext through these comma-separated `stages` in order before output: gunzip, zstd, json (pretty-print) or cat")
+	verifyHash := fs.Bool("verify-hash", false, "check the plaintext against the SHA-256 stored in the blobid header")
+	timeout := fs.Duration("timeout", 0, "give up on the whole decryption, from any -in URL download through key derivation to the last chunk, after this `duration`; 0 waits indefinitely")
+	maxCiphertext := fs.Int64("max-ciphertext", defaultMaxCiphertext, "refuse a classic (unchunked) ciphertext larger than this many `bytes`")
+	maxPlaintext := fs.Int64("max-plaintext", defaultMaxPlaintext, "abort -decompress or a -pipeline gunzip, zstd or json stage once its output passes this many `bytes`; 0 is no limit")
+	maxFetch := fs.Int64("max-fetch", defaultMaxFetch, "refuse an -in URL whose body exceeds this many `bytes`")
+	saltHex := fs.String("salt", "", "the blob's 16-byte salt as `hex`, with -nonce, in place of the blobid argument")
+	strict := fs.Bool("strict", false, "refuse a legacy blobid without a header instead of decrypting it; migrate gives one a header")
+	layoutDesc := fs.String("layout", "", "read a headerless blobid in this salt and nonce `layout` from another producer, e.g. n24s16 (nonce first) or s24n12; see README")
+	nonceHex := fs.String("nonce", "", "the blob's 12- or 24-byte nonce as `hex`, with -salt, in place of the blobid argument")
+	offset := fs.Int64("offset", 0, "with a chunked blob, start the plaintext output at this `byte`, opening only the chunks the range covers")
+	length := fs.Int64("length", -1, "with a chunked blob, write at most this many `bytes` from -offset; -1 is to the end")
+	showProgress := fs.Bool("progress", false, "while streaming a chunked blob from -in or -blobfile, report bytes read and throughput on stderr a few times a second")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return exitUsage
+	}
+	level, levelErr := logf.level()
+	log := newLogger(stderr, level)
+	rp := &reporter{json: *jsonOut, stdout: stdout, stderr: stderr, log: log, floor: *harden, start: time.Now()}
+	if levelErr != nil {
+		return rp.fail(levelErr)
+	}
+	encoding, err := parseOutEncoding(*outEncoding)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error decoding blobid: %v\n", err)
-		os.Exit(1)
+		return rp.fail(err)
+	}
+	plainOut := stdout
+	if !*forceBinary && encoding == encodingRaw && isTerminal(stdout) {
+		plainOut = &binaryGuard{w: stdout}
 	}
 
-	// Extract salt (first 16 bytes) and nonce (last 12 bytes)
-	salt := blobBytes[:16]
-	nonce := blobBytes[len(blobBytes)-12:]
-
-	// Derive key using PBKDF2-SHA256
-	key := pbkdf2.Key([]byte(password), salt, 100000, 32, sha256.New)
+	// Positional form: <blobid> [password|-] [encrypted_b64]; the blobid
+	// is absent with -blobfile or -salt/-nonce, and the trailing
+	// ciphertext with -in or -blobfile.
+	pos := fs.Args()
+	parts := *saltHex != "" || *nonceHex != ""
+	var blobid, encryptedB64 string
+	switch {
+	case *blobFile != "" && *in != "":
+		return rp.fail(usageErrorf("-blobfile already carries the ciphertext; drop -in"))
+	case parts && (*saltHex == "" || *nonceHex == ""):
+		return rp.fail(usageErrorf("-salt and -nonce go together"))
+	case parts && *blobFile != "":
+		return rp.fail(usageErrorf("-blobfile already carries the salt and nonce; drop -salt and -nonce"))
+	case *blobFile != "":
+	case parts:
+		if err := checkNoBlobID(pos, *in != ""); err != nil {
+			return rp.fail(err)
+		}
+		if *in == "" {
+			if len(pos) == 0 {
+				fs.Usage()
+				return exitUsage
+			}
+			encryptedB64, pos = pos[len(pos)-1], pos[:len(pos)-1]
+		}
+	case len(pos) == 0 || (*in == "" && len(pos) < 2):
+		fs.Usage()
+		return exitUsage
+	case *in == "":
+		blobid, encryptedB64 = pos[0], pos[len(pos)-1]
+		pos = pos[1 : len(pos)-1]
+	default:
+		blobid, pos = pos[0], pos[1:]
+	}
 
-	// Create ChaCha20-Poly1305 cipher
-	cipher, err := chacha20poly1305.New(key)
+	if *jsonOut && *outFile == "" && !*verify {
+		return rp.fail(usageErrorf("-json keeps stdout for the report; write the plaintext with -out"))
+	}
+	switch {
+	case *tee != "" && *outFile != "":
+		return rp.fail(usageErrorf("-tee writes stdout and a file; -out writes only the file; drop one"))
+	case *tee != "" && (*verify || *jsonOut):
+		return rp.fail(usageErrorf("-tee needs the plaintext on stdout; drop -verify and -json"))
+	case encoding != encodingRaw && *verify:
+		return rp.fail(usageErrorf("-verify writes no plaintext to encode; drop -out-encoding"))
+	case *noNewline && encoding == encodingRaw:
+		return rp.fail(usageErrorf("-no-newline applies to -out-encoding hex or base64; raw output never gets one"))
+	}
+	if *jsonOut && *showProgress {
+		return rp.fail(usageErrorf("-progress writes to stderr, where -json reports failures; drop one"))
+	}
+	rp.res.BlobID = blobid
+	variant, err := parseBase64Variant(*b64)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating cipher: %v\n", err)
-		os.Exit(1)
+		return rp.fail(err)
+	}
+	stages, err := parsePipeline(*pipeline)
+	if err != nil {
+		return rp.fail(err)
+	}
+	if *maxPlaintext < 0 {
+		return rp.fail(usageErrorf("-max-plaintext must be positive, or 0 for no limit"))
+	}
+	ranged := *offset != 0 || *length != -1
+	switch {
+	case *offset < 0:
+		return rp.fail(usageErrorf("-offset must not be negative"))
+	case *length < -1 || *length == 0:
+		return rp.fail(usageErrorf("-length must be positive, or -1 for the rest of the plaintext"))
+	case ranged && *verifyHash:
+		return rp.fail(usageErrorf("-verify-hash needs the whole plaintext; drop -offset and -length"))
+	}
+	form, err := parseNormalize(*normalize)
+	if err != nil {
+		return rp.fail(err)
+	}
+	layout, err := parseLayoutFlag(*layoutDesc)
+	if err != nil {
+		return rp.fail(err)
+	}
+	if layout != nil && (*blobFile != "" || parts) {
+		return rp.fail(usageErrorf("-layout describes a blobid argument; drop -blobfile or -salt and -nonce"))
 	}
+	switch {
+	case *rawIn && *b64 != "":
+		return rp.fail(usageErrorf("-raw ciphertext is not base64; drop -b64"))
+	case *rawIn && *in == "":
+		return rp.fail(usageErrorf("-raw needs the ciphertext from -in"))
+	}
+
+	var password string
+	var rawKey, masterKey []byte
+	var candidates []candidate
+	sources := 0
+	for _, s := range []string{*keyHex, *keychain, *passwordEnv, *masterKeyFile, *passwordList} {
+		if s != "" {
+			sources++
+		}
+	}
+	switch {
+	case sources > 1:
+		return rp.fail(usageErrorf("-key, -keychain, -password-env, -master-key-file and -password-list are mutually exclusive"))
+	case *passwordList != "":
+		if len(pos) > 0 || *passwordFile != "" {
+			return rp.fail(usageErrorf("-password-list replaces the password; drop the password argument or -password-file"))
+		}
+		if candidates, err = readPasswordList(*passwordList, form); err != nil {
+			return rp.fail(err)
+		}
+	case *masterKeyFile != "":
+		if len(pos) > 0 || *passwordFile != "" {
+			return rp.fail(usageErrorf("-master-key-file replaces the password; drop the password argument or -password-file"))
+		}
+		if masterKey, err = readMasterKeyFile(*masterKeyFile); err != nil {
+			return rp.fail(err)
+		}
+		defer n2s.Wipe(masterKey)
+	case *keychain != "":
+		if password, err = keychainPassword(*keychain, pos, *passwordFile); err != nil {
+			return rp.fail(err)
+		}
+	case *passwordEnv != "":
+		if password, err = envPassword(*passwordEnv, pos, *passwordFile); err != nil {
+			return rp.fail(err)
+		}
+	case *keyHex != "":
+		if len(pos) > 0 || *passwordFile != "" {
+			return rp.fail(usageErrorf("-key replaces the password; drop the password argument or -password-file"))
+		}
+		if rawKey, err = parseKeyHex(*keyHex); err != nil {
+			return rp.fail(err)
+		}
+		defer n2s.Wipe(rawKey)
+	default:
+		stdinBusy := *in == "-" || *blobFile == "-"
+		if password, err = decryptPassword(pos, *passwordFile, stdinBusy, stdin, stderr, log); err != nil {
+			return rp.fail(err)
+		}
+	}
+
+	password = normalizePassword(password, form)
 
-	// Decode base64 encrypted data
-	encryptedData, err := base64.StdEncoding.DecodeString(encryptedB64)
+	// Ctrl-C stops a chunked stream between chunks, so a half-written
+	// -out temp file is removed rather than left behind.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	var meter *progress
+	if *showProgress {
+		meter = newProgress(stderr)
+	}
+
+	// -in and -blobfile stay streams until the blob says whether it is
+	// chunked; only then is a classic ciphertext read into memory.
+	var blob *n2s.Blob
+	var body io.ReadCloser
+	var encryptedData []byte
+	switch {
+	case *blobFile != "":
+		blob, body, err = openBlobFile(*blobFile, stdin, meter)
+	case parts:
+		blob, err = blobFromParts(*saltHex, *nonceHex)
+	default:
+		blob, err = n2s.ParseBlobIDLayout([]byte(blobid), layout)
+	}
+	err = withFormatsHint(err)
+	if err == nil && *strict {
+		err = strictHeader(blob)
+	}
+	if err == nil && *blobFile == "" {
+		if *in != "" {
+			body, err = openDecryptInput(ctx, *in, stdin, variant, *rawIn, *maxFetch, meter)
+		} else {
+			encryptedData, err = decodeBase64As(encryptedB64, variant)
+		}
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error decoding base64: %v\n", err)
-		os.Exit(1)
+		return rp.fail(err)
+	}
+	if body != nil {
+		defer body.Close()
+	}
+
+	rp.res.describe(blob)
+	checkHash := *verifyHash && blob.PlaintextHash != nil
+	if *verifyHash && !checkHash {
+		log.warnf("blob has no stored plaintext hash; -verify-hash checks nothing")
+	}
+
+	// The header says which kind of key a blob has; a master key for a
+	// password blob, or the reverse, is a mistake worth naming.
+	switch {
+	case blob.WrappedKey != nil && masterKey == nil && rawKey == nil:
+		return rp.fail(usageErrorf("blob key is wrapped under a master key; decrypt it with -master-key-file"))
+	case blob.WrappedKey == nil && masterKey != nil:
+		return rp.fail(usageErrorf("blob key is derived from a password; drop -master-key-file"))
+	}
+
+	key := rawKey
+	switch {
+	case key != nil:
+	case candidates != nil:
+		if blob.ChunkSize > 0 {
+			return rp.fail(usageErrorf("-password-list needs a single-shot blob; a chunked stream cannot be reread for each candidate"))
+		}
+	case masterKey != nil:
+		if key, err = n2s.UnwrapKey(blob, masterKey); err != nil {
+			return rp.fail(err)
+		}
+		defer n2s.Wipe(key)
+		log.infof("%s: unwrapped data key", blob.ID())
+	default:
+		start := time.Now()
+		if key, err = n2s.DeriveKeyCtx(ctx, password, blob.Salt, blob.KDF, blob.Cipher.KeySize(), nil); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				err = fmt.Errorf("gave up after -timeout %v: %w", *timeout, err)
+			}
+			return rp.fail(err)
+		}
+		defer n2s.Wipe(key)
+		log.infof("%s: derived key (%s) in %v", blob.ID(), blob.KDF.ID, time.Since(start).Round(time.Millisecond))
 	}
 
-	// Decrypt
-	plaintext, err := cipher.Open(nil, nonce, encryptedData, nil)
+	if blob.ChunkSize > 0 {
+		if *decompressOut || stages != nil {
+			return rp.fail(usageErrorf("-decompress and -pipeline do not stream; pipe the output through gunzip or 'zstd -d' instead"))
+		}
+		if body == nil {
+			body = io.NopCloser(bytes.NewReader(encryptedData))
+		}
+		out := chunkedOutput{
+			outFile:   *outFile,
+			teePath:   *tee,
+			verify:    *verify,
+			checkHash: checkHash,
+			stdout:    plainOut,
+			encoding:  encoding,
+			newline:   !*noNewline,
+			meter:     meter,
+		}
+		if ranged {
+			out.span = &byteRange{*offset, *length}
+		}
+		return decryptChunked(ctx, rp, blob, key, body, []byte(*aad), out)
+	}
+	if ranged {
+		return rp.fail(usageErrorf("-offset and -length need a chunked blob; a single-shot blob has one tag over the whole plaintext, so it cannot be opened in part"))
+	}
+	if body != nil {
+		if encryptedData, err = readAllCapped(body, *maxCiphertext); err != nil {
+			return rp.fail(err)
+		}
+	}
+
+	start := time.Now()
+	var plaintext []byte
+	if candidates != nil {
+		var match candidate
+		if plaintext, match, err = tryPasswords(ctx, blob, encryptedData, []byte(*aad), candidates, log); err != nil {
+			return rp.fail(err)
+		}
+		if !*jsonOut {
+			log.logf(levelWarn, "password list: line %d opens the blob", match.line)
+		}
+	} else if plaintext, err = n2s.Open(blob, key, encryptedData, []byte(*aad)); err != nil {
+		return rp.fail(err)
+	}
+	log.infof("%s: opened %d bytes in %v", blob.ID(), len(plaintext), time.Since(start).Round(time.Microsecond))
+	rp.res.PlaintextBytes = len(plaintext)
+	if !*noWipe {
+		defer func() { n2s.Wipe(plaintext) }()
+	}
+	if checkHash {
+		sum := sha256.Sum256(plaintext)
+		if err := n2s.CheckPlaintextHash(blob, sum[:]); err != nil {
+			return rp.fail(err)
+		}
+	}
+
+	// A successful Open means the Poly1305 tag checked out, which is all
+	// -verify needs; the plaintext never reaches stdout.
+	if *verify {
+		if !*jsonOut {
+			log.logf(levelWarn, "Verified: password and ciphertext authenticate")
+		}
+		return rp.done(statusVerified)
+	}
+
+	if *decompressOut {
+		expanded, err := decompress(plaintext, *maxPlaintext)
+		if err != nil {
+			return rp.fail(err)
+		}
+		if !*noWipe {
+			n2s.Wipe(plaintext)
+		}
+		plaintext = expanded
+	}
+	if stages != nil {
+		out, err := runPipeline(stages, plaintext, !*noWipe, *maxPlaintext)
+		if err != nil {
+			return rp.fail(err)
+		}
+		if !*noWipe && !sameBuffer(plaintext, out) {
+			n2s.Wipe(plaintext)
+		}
+		plaintext = out
+	}
+	rp.res.PlaintextBytes = len(plaintext)
+	if encoding != encodingRaw {
+		encoded := encodeOutput(plaintext, encoding, !*noNewline)
+		if !*noWipe {
+			n2s.Wipe(plaintext)
+		}
+		plaintext = encoded
+	}
+
+	if *outFile != "" {
+		if err := writeAtomic(*outFile, plaintext); err != nil {
+			return rp.fail(err)
+		}
+		return rp.done(statusOK)
+	}
+	if *tee != "" {
+		w, f, err := createTee(*tee, plainOut)
+		if err != nil {
+			return rp.fail(err)
+		}
+		defer f.Abort()
+		if _, err := w.Write(plaintext); err != nil {
+			return rp.fail(err)
+		}
+		if err := f.Commit(); err != nil {
+			return rp.fail(err)
+		}
+		return 0
+	}
+
+	if _, err := plainOut.Write(plaintext); err != nil {
+		return rp.fail(err)
+	}
+	return 0
+}
+
+// byteRange is the -offset and -length slice of a chunked plaintext; a
+// negative length runs to the end.
+type byteRange struct{ offset, length int64 }
+
+// chunkedOutput is where decryptChunked sends a chunked blob's plaintext
+// and what it checks on the way.
+type chunkedOutput struct {
+	outFile, teePath string
+	verify           bool
+	// checkHash compares the plaintext with the blob's stored hash.
+	checkHash bool
+	// span, if not nil, opens only the chunks it covers.
+	span     *byteRange
+	stdout   io.Writer
+	encoding string
+	newline  bool
+	meter    *progress
+}
+
+// decryptChunked streams a chunked blob to -out, stdout (and -tee) or,
+// for -verify, nowhere. With -out or -tee the file only appears once
+// every chunk has authenticated, and with checkHash once the plaintext
+// matches its stored hash; stdout may already have received the leading
+// chunks when a later one fails.
+func decryptChunked(ctx context.Context, rp *reporter, blob *n2s.Blob, key []byte, body io.Reader, aad []byte, out chunkedOutput) int {
+	var w io.Writer = out.stdout
+	var f *atomicFile
+	var tf *teeFile
+	switch {
+	case out.verify:
+		w = io.Discard
+	case out.outFile != "":
+		var err error
+		if f, err = createAtomic(out.outFile); err != nil {
+			return rp.fail(err)
+		}
+		defer f.Abort()
+		w = f
+	case out.teePath != "":
+		var err error
+		if w, tf, err = createTee(out.teePath, out.stdout); err != nil {
+			return rp.fail(err)
+		}
+		defer tf.Abort()
+	}
+	if !out.verify {
+		w = newEncodedWriter(w, out.encoding, out.newline)
+	}
+	enc, _ := w.(*encodedWriter)
+
+	h := sha256.New()
+	if out.checkHash {
+		w = io.MultiWriter(w, h)
+	}
+	cw := &countingWriter{w: w}
+	start := time.Now()
+	out.meter.begin()
+	var err error
+	if out.span != nil {
+		err = n2s.OpenRangeContext(ctx, blob, key, body, cw, aad, out.span.offset, out.span.length)
+	} else {
+		err = n2s.OpenStreamContext(ctx, blob, key, body, cw, aad)
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Decryption failed: %v\n", err)
-		os.Exit(1)
+		return rp.fail(err)
+	}
+	out.meter.finish()
+	if enc != nil {
+		if err := enc.Close(); err != nil {
+			return rp.fail(err)
+		}
+	}
+	rp.log.infof("%s: opened %d bytes in %v", blob.ID(), cw.n, time.Since(start).Round(time.Microsecond))
+	rp.res.PlaintextBytes = int(cw.n)
+	if out.checkHash {
+		if err := n2s.CheckPlaintextHash(blob, h.Sum(nil)); err != nil {
+			return rp.fail(err)
+		}
 	}
+	switch {
+	case out.verify:
+		if !rp.json {
+			rp.log.logf(levelWarn, "Verified: password and ciphertext authenticate")
+		}
+		return rp.done(statusVerified)
+	case f != nil:
+		if err := f.Commit(); err != nil {
+			return rp.fail(err)
+		}
+	case tf != nil:
+		if err := tf.Commit(); err != nil {
+			return rp.fail(err)
+		}
+	}
+	return rp.done(statusOK)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
 
-	// Write plaintext to stdout
-	os.Stdout.Write(plaintext)
-}
\ No newline at end of file
+// openDecryptInput opens decrypt's -in ciphertext: a file, stdin or a URL,
+// base64 unless raw. Its bytes as read, before decoding, count towards
+// meter, which may be nil.
+func openDecryptInput(ctx context.Context, name string, stdin io.Reader, variant string, raw bool, maxFetch int64, meter *progress) (io.ReadCloser, error) {
+	u, remote, err := remoteURL(name)
+	if err != nil {
+		return nil, err
+	}
+	var r io.ReadCloser
+	if remote {
+		r, err = openURL(ctx, u, maxFetch)
+	} else {
+		r, err = openInput(name, stdin)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r = meter.input(r)
+	if raw {
+		return r, nil
+	}
+	return decodeCiphertext(r, variant), nil
+}
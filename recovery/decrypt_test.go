@@ -0,0 +1,427 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/decrypt_test.go
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/pbkdf2"
+
+	"decrypt/n2s"
+)
+
+// sealClassic builds a blob the way the n2s producer does: a 32-byte
+// blobid whose first 16 bytes are the salt and last 12 the nonce.
+func sealClassic(t *testing.T, plaintext []byte, password string) (blobid, ciphertext []byte) {
+	t.Helper()
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatal(err)
+	}
+	key := pbkdf2.Key([]byte(password), raw[:n2s.SaltLen], n2s.LegacyIterations, n2s.KeyLen, sha256.New)
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext = aead.Seal(nil, raw[len(raw)-n2s.NonceLen:], plaintext, nil)
+	return []byte(hex.EncodeToString(raw)), ciphertext
+}
+
+func TestVerifyWritesNothingToStdout(t *testing.T) {
+	blobid, ciphertext := sealClassic(t, []byte("sensitive note"), "right")
+	b64 := base64.StdEncoding.EncodeToString(ciphertext)
+
+	cases := []struct {
+		password string
+		wantCode int
+	}{
+		{"right", 0},
+		{"wrong", exitAuthFailed},
+	}
+	for _, tc := range cases {
+		var out, errOut bytes.Buffer
+		code := run([]string{"-verify", string(blobid), "-", b64}, strings.NewReader(tc.password), &out, &errOut)
+		if code != tc.wantCode {
+			t.Errorf("password %q: exit %d, want %d (%s)", tc.password, code, tc.wantCode, errOut.String())
+		}
+		if out.Len() != 0 {
+			t.Errorf("password %q: stdout %q, want empty", tc.password, out.String())
+		}
+	}
+}
+
+func TestDecryptTruncatedVersusCorrupted(t *testing.T) {
+	blobid, ciphertext := sealClassic(t, []byte("long enough to matter"), "pw")
+	flipped := bytes.Clone(ciphertext)
+	flipped[len(flipped)/2] ^= 0x40
+
+	cases := []struct {
+		name       string
+		ciphertext []byte
+		wantErr    error
+		wantCode   int
+	}{
+		{"empty", nil, n2s.ErrTruncated, exitDecode},
+		{"10 bytes", ciphertext[:10], n2s.ErrTruncated, exitDecode},
+		{"bit flipped", flipped, n2s.ErrAuthFailed, exitAuthFailed},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := n2s.Decrypt(blobid, tc.ciphertext, nil, "pw"); !errors.Is(err, tc.wantErr) {
+				t.Errorf("Decrypt error %v, want %v", err, tc.wantErr)
+			}
+			var out, errOut bytes.Buffer
+			code := run([]string{string(blobid), "-", base64.StdEncoding.EncodeToString(tc.ciphertext)},
+				strings.NewReader("pw"), &out, &errOut)
+			if code != tc.wantCode {
+				t.Errorf("exit %d, want %d (%s)", code, tc.wantCode, errOut.String())
+			}
+		})
+	}
+}
+
+func TestDecryptRawKey(t *testing.T) {
+	key := make([]byte, n2s.KeyLen)
+	raw := make([]byte, n2s.SaltLen+n2s.NonceLen)
+	rand.Read(key)
+	rand.Read(raw)
+	aead, _ := chacha20poly1305.New(key)
+	ciphertext := aead.Seal(nil, raw[n2s.SaltLen:], []byte("hsm sealed"), nil)
+	blobid := hex.EncodeToString(raw)
+	b64 := base64.StdEncoding.EncodeToString(ciphertext)
+
+	var out, errOut bytes.Buffer
+	if code := run([]string{"-key", hex.EncodeToString(key), blobid, b64}, nil, &out, &errOut); code != 0 {
+		t.Fatalf("exit %d: %s", code, errOut.String())
+	}
+	if out.String() != "hsm sealed" {
+		t.Errorf("plaintext %q", out.String())
+	}
+
+	cases := map[string][]string{
+		"short key":    {"-key", hex.EncodeToString(key[:31]), blobid, b64},
+		"long key":     {"-key", hex.EncodeToString(append(key, 0)), blobid, b64},
+		"not hex":      {"-key", strings.Repeat("zz", n2s.KeyLen), blobid, b64},
+		"and password": {"-key", hex.EncodeToString(key), blobid, "pw", b64},
+	}
+	for name, args := range cases {
+		out.Reset()
+		errOut.Reset()
+		if code := run(args, nil, &out, &errOut); code == 0 || out.Len() != 0 {
+			t.Errorf("%s: exit %d, stdout %q", name, code, out.String())
+		}
+		if name == "and password" && !strings.Contains(errOut.String(), "-key replaces the password") {
+			t.Errorf("%s: stderr %q", name, errOut.String())
+		}
+	}
+}
+
+func TestVerifyHash(t *testing.T) {
+	plaintext := []byte("archived before the migration")
+	blobid, ctB64, err := n2s.EncryptWith(plaintext, "pw", n2s.EncryptOptions{StoreHash: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := n2s.ParseBlobID([]byte(blobid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum := sha256.Sum256(plaintext); !bytes.Equal(blob.PlaintextHash, sum[:]) {
+		t.Fatalf("stored hash %x, want %x", blob.PlaintextHash, sum)
+	}
+
+	// The header is not authenticated, so a rewritten hash still opens
+	// and only -verify-hash notices.
+	raw, _ := hex.DecodeString(blobid)
+	raw[len(raw)-n2s.SaltLen-n2s.NonceLen-2] ^= 0x01
+	tampered := hex.EncodeToString(raw)
+
+	unhashed, unhashedB64, _ := n2s.EncryptWith(plaintext, "pw", n2s.EncryptOptions{})
+
+	chunkedID, chunkedB64, _ := n2s.EncryptWith(plaintext, "pw", n2s.EncryptOptions{ChunkSize: 8})
+	chunked, _ := n2s.ParseBlobID([]byte(chunkedID))
+	// KDF and chunk size as sealed, plus an all-zero SHA256 field.
+	header := append(mustHex("402f"+"010501000186a0"+"020400000008"+"0420"), make([]byte, sha256.Size)...)
+	wrongChunked := hex.EncodeToString(append(header, append(chunked.Salt, chunked.Nonce...)...))
+
+	cases := []struct {
+		name     string
+		blobid   string
+		b64      string
+		wantCode int
+		wantErr  string
+	}{
+		{"match", blobid, ctB64, 0, ""},
+		{"mismatch", tampered, ctB64, exitAuthFailed, "does not match the SHA-256"},
+		{"no stored hash", unhashed, unhashedB64, 0, "no stored plaintext hash"},
+		{"chunked mismatch", wrongChunked, chunkedB64, exitAuthFailed, "does not match the SHA-256"},
+	}
+	for _, tc := range cases {
+		var out, errOut bytes.Buffer
+		code := run([]string{"-verify-hash", tc.blobid, "-", tc.b64}, strings.NewReader("pw"), &out, &errOut)
+		if code != tc.wantCode {
+			t.Errorf("%s: exit %d, want %d (%s)", tc.name, code, tc.wantCode, errOut.String())
+		}
+		if !strings.Contains(errOut.String(), tc.wantErr) {
+			t.Errorf("%s: stderr %q lacks %q", tc.name, errOut.String(), tc.wantErr)
+		}
+		if tc.wantCode == 0 && out.String() != string(plaintext) {
+			t.Errorf("%s: plaintext %q", tc.name, out.String())
+		}
+	}
+
+	if _, _, err := n2s.EncryptWith(plaintext, "pw", n2s.EncryptOptions{StoreHash: true, ChunkSize: 8}); err == nil {
+		t.Error("StoreHash with ChunkSize accepted")
+	}
+}
+
+func TestHardenFloor(t *testing.T) {
+	blobid, ciphertext := sealClassic(t, []byte("timed"), "pw")
+	b64 := base64.StdEncoding.EncodeToString(ciphertext)
+	const floor = 300 * time.Millisecond
+
+	cases := map[string][]string{
+		"short blobid":   {"-harden", floor.String(), "abcd", "-", b64},
+		"bad base64":     {"-harden", floor.String(), string(blobid), "-", "!!!"},
+		"wrong password": {"-harden", floor.String(), string(blobid), "-", b64},
+	}
+	for name, args := range cases {
+		var out, errOut bytes.Buffer
+		start := time.Now()
+		code := run(args, strings.NewReader("wrong"), &out, &errOut)
+		if elapsed := time.Since(start); elapsed < floor {
+			t.Errorf("%s: failed after %v, before the %v floor", name, elapsed, floor)
+		}
+		if code == 0 {
+			t.Errorf("%s: exit 0", name)
+		}
+	}
+}
+
+func TestDecryptMasterKey(t *testing.T) {
+	dir := t.TempDir()
+	master, other := dir+"/master", dir+"/other"
+	for _, name := range []string{master, other} {
+		key := make([]byte, n2s.KeyLen)
+		rand.Read(key)
+		if err := os.WriteFile(name, []byte(hex.EncodeToString(key)+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var sealed, errOut bytes.Buffer
+	if code := run([]string{"encrypt", "-master-key-file", master}, strings.NewReader("envelope"), &sealed, &errOut); code != 0 {
+		t.Fatalf("encrypt: exit %d: %s", code, errOut.String())
+	}
+	blobid, b64, _ := strings.Cut(strings.TrimSpace(sealed.String()), "\t")
+
+	var out bytes.Buffer
+	if code := run([]string{"-master-key-file", master, blobid, b64}, nil, &out, &errOut); code != 0 || out.String() != "envelope" {
+		t.Fatalf("decrypt: exit %d, %q: %s", code, out.String(), errOut.String())
+	}
+
+	cases := map[string]struct {
+		args []string
+		code int
+	}{
+		"wrong master key": {[]string{"-master-key-file", other, blobid, b64}, exitAuthFailed},
+		"no master key":    {[]string{blobid, "pw", b64}, exitUsage},
+		"password blob":    {[]string{"-master-key-file", master, hex.EncodeToString(make([]byte, 28)), b64}, exitUsage},
+	}
+	for name, c := range cases {
+		out.Reset()
+		errOut.Reset()
+		if code := run(c.args, nil, &out, &errOut); code != c.code || out.Len() != 0 {
+			t.Errorf("%s: exit %d, want %d, stdout %q: %s", name, code, c.code, out.String(), errOut.String())
+		}
+	}
+}
+
+func TestDecryptTimeout(t *testing.T) {
+	// A version 1 header asking for 2^24 PBKDF2 iterations: seconds of
+	// work before the (garbage) ciphertext is even looked at.
+	raw := make([]byte, 1+n2s.SaltLen+n2s.NonceLen)
+	rand.Read(raw)
+	raw[0] = 0x20 | 24
+	blobid := hex.EncodeToString(raw)
+	b64 := base64.StdEncoding.EncodeToString(make([]byte, 32))
+
+	var out, errOut bytes.Buffer
+	start := time.Now()
+	code := run([]string{"-timeout", "50ms", blobid, "-", b64}, strings.NewReader("pw"), &out, &errOut)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("returned after %v, long past the timeout", elapsed)
+	}
+	if code != exitFailure || !strings.Contains(errOut.String(), "-timeout 50ms") {
+		t.Errorf("exit %d, stderr %q", code, errOut.String())
+	}
+}
+
+func TestDecryptSaltNonce(t *testing.T) {
+	blobid, ciphertext := sealClassic(t, []byte("split"), "pw")
+	b64 := base64.StdEncoding.EncodeToString(ciphertext)
+	id := string(blobid)
+	salt, nonce := id[:2*n2s.SaltLen], id[len(id)-2*n2s.NonceLen:]
+
+	var out, errOut bytes.Buffer
+	if code := run([]string{"-salt", salt, "-nonce", nonce, "-", b64}, strings.NewReader("pw"), &out, &errOut); code != 0 || out.String() != "split" {
+		t.Fatalf("exit %d, %q: %s", code, out.String(), errOut.String())
+	}
+
+	cases := map[string]struct {
+		args []string
+		code int
+	}{
+		"blobid too":       {[]string{"-salt", salt, "-nonce", nonce, id, "-", b64}, exitUsage},
+		"blobid as pw":     {[]string{"-salt", salt, "-nonce", nonce, id, b64}, exitUsage},
+		"salt only":        {[]string{"-salt", salt, "-", b64}, exitUsage},
+		"with -blobfile":   {[]string{"-salt", salt, "-nonce", nonce, "-blobfile", "x.n2s"}, exitUsage},
+		"short salt":       {[]string{"-salt", salt[2:], "-nonce", nonce, "-", b64}, exitDecode},
+		"bad nonce length": {[]string{"-salt", salt, "-nonce", nonce + "00", "-", b64}, exitDecode},
+		"bad hex":          {[]string{"-salt", "zz" + salt[2:], "-nonce", nonce, "-", b64}, exitDecode},
+	}
+	for name, c := range cases {
+		out.Reset()
+		errOut.Reset()
+		if code := run(c.args, strings.NewReader("pw"), &out, &errOut); code != c.code {
+			t.Errorf("%s: exit %d, want %d: %s", name, code, c.code, errOut.String())
+		}
+	}
+}
+
+func TestDecryptLayout(t *testing.T) {
+	// A sibling producer's blobid: 24-byte nonce, then a 24-byte salt.
+	raw := make([]byte, n2s.XNonceLen+24)
+	rand.Read(raw)
+	nonce, salt := raw[:n2s.XNonceLen], raw[n2s.XNonceLen:]
+	key := pbkdf2.Key([]byte("pw"), salt, n2s.LegacyIterations, n2s.KeyLen, sha256.New)
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := hex.EncodeToString(raw)
+	b64 := base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, []byte("sibling"), nil))
+
+	var out, errOut bytes.Buffer
+	if code := run([]string{"-layout", "n24s24", id, "pw", b64}, nil, &out, &errOut); code != 0 || out.String() != "sibling" {
+		t.Fatalf("exit %d, %q: %s", code, out.String(), errOut.String())
+	}
+
+	cases := map[string]struct {
+		args []string
+		code int
+	}{
+		"default layout": {[]string{id, "pw", b64}, exitDecode},
+		"too long":       {[]string{"-layout", "n24s16", id, "pw", b64}, exitDecode},
+		"bad descriptor": {[]string{"-layout", "n24q24", id, "pw", b64}, exitUsage},
+		"with -blobfile": {[]string{"-layout", "n24s24", "-blobfile", "x.n2s", "pw"}, exitUsage},
+		"info":           {[]string{"info", "-layout", "n24s24", id}, 0},
+		"info too short": {[]string{"info", "-layout", "n24s32", id}, exitDecode},
+	}
+	for name, c := range cases {
+		out.Reset()
+		errOut.Reset()
+		if code := run(c.args, nil, &out, &errOut); code != c.code {
+			t.Errorf("%s: exit %d, want %d: %s", name, code, c.code, errOut.String())
+		}
+	}
+}
+
+func TestDecryptRange(t *testing.T) {
+	plaintext := make([]byte, 20000)
+	rand.Read(plaintext)
+	file := filepath.Join(t.TempDir(), "note.n2s")
+	var out, errOut bytes.Buffer
+	if code := run([]string{"encrypt", "-allow-weak-password", "-chunk-size", "4096", "-blobfile", file, "pw"}, bytes.NewReader(plaintext), &out, &errOut); code != 0 {
+		t.Fatalf("encrypt: exit %d: %s", code, errOut.String())
+	}
+
+	out.Reset()
+	if code := run([]string{"-force-binary", "-blobfile", file, "pw"}, nil, &out, &errOut); code != 0 {
+		t.Fatalf("full decrypt: exit %d: %s", code, errOut.String())
+	}
+	full := bytes.Clone(out.Bytes())
+
+	for _, r := range []struct {
+		args []string
+		want []byte
+	}{
+		{[]string{"-offset", "5000", "-length", "6000"}, full[5000:11000]},
+		{[]string{"-length", "100"}, full[:100]},
+		{[]string{"-offset", "19990"}, full[19990:]},
+	} {
+		out.Reset()
+		args := append(append([]string{"-force-binary", "-blobfile", file}, r.args...), "pw")
+		if code := run(args, nil, &out, &errOut); code != 0 || !bytes.Equal(out.Bytes(), r.want) {
+			t.Errorf("%v: exit %d, %d bytes, want %d: %s", r.args, code, out.Len(), len(r.want), errOut.String())
+		}
+	}
+
+	errOut.Reset()
+	if code := run([]string{"-blobfile", file, "-offset", "19000", "-length", "2000", "-out", filepath.Join(t.TempDir(), "part"), "pw"}, nil, &out, &errOut); code != exitUsage ||
+		!strings.Contains(errOut.String(), "bytes [19000, 21000) requested, plaintext has 20000") {
+		t.Errorf("past the end: exit %d: %s", code, errOut.String())
+	}
+
+	blobid, ciphertext := sealClassic(t, []byte("single shot"), "pw")
+	errOut.Reset()
+	if code := run([]string{"-offset", "2", string(blobid), "pw", base64.StdEncoding.EncodeToString(ciphertext)}, nil, &out, &errOut); code != exitUsage ||
+		!strings.Contains(errOut.String(), "need a chunked blob") {
+		t.Errorf("single-shot: exit %d: %s", code, errOut.String())
+	}
+}
+
+func TestDecryptStrict(t *testing.T) {
+	legacyID, legacyCT := sealClassic(t, []byte("legacy"), "pw")
+	legacy := []string{string(legacyID), "pw", base64.StdEncoding.EncodeToString(legacyCT)}
+	var sealed, out, errOut bytes.Buffer
+	if code := run([]string{"encrypt", "-allow-weak-password", "-iterations", "2048", "pw"}, strings.NewReader("headered"), &sealed, &errOut); code != 0 {
+		t.Fatalf("encrypt: exit %d: %s", code, errOut.String())
+	}
+	headeredID, headeredB64, _ := strings.Cut(strings.TrimSpace(sealed.String()), "\t")
+
+	if code := run(append([]string{"-strict"}, legacy...), nil, &out, &errOut); code != exitDecode || !strings.Contains(errOut.String(), "legacy headerless blob rejected in strict mode") {
+		t.Errorf("legacy under -strict: exit %d, want %d: %s", code, exitDecode, errOut.String())
+	}
+	if out.Len() != 0 {
+		t.Errorf("legacy under -strict wrote %q", out.String())
+	}
+	for name, args := range map[string][]string{
+		"legacy":            legacy,
+		"headered -strict":  {"-strict", headeredID, "pw", headeredB64},
+		"headered, lenient": {headeredID, "pw", headeredB64},
+	} {
+		out.Reset()
+		errOut.Reset()
+		if code := run(args, nil, &out, &errOut); code != 0 || out.Len() == 0 {
+			t.Errorf("%s: exit %d, stdout %q: %s", name, code, out.String(), errOut.String())
+		}
+	}
+
+	manifest := writeManifest(t, []manifestEntry{
+		{BlobID: string(legacyID), Ciphertext: base64.StdEncoding.EncodeToString(legacyCT)},
+		{BlobID: headeredID, Ciphertext: headeredB64},
+	})
+	out.Reset()
+	code := run([]string{"batch", "-strict", "-out", t.TempDir(), manifest, "pw"}, nil, &out, &errOut)
+	if code != exitFailure || !strings.Contains(out.String(), "1 succeeded, 1 failed (1 legacy rejected)") {
+		t.Errorf("batch -strict: exit %d, %q", code, out.String())
+	}
+}
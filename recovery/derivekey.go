@@ -0,0 +1,84 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/derivekey.go
+
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"decrypt/n2s"
+)
+
+// runDerivekey prints the hex key a password derives for a blobid's salt
+// and KDF, for import elsewhere; decrypt -key takes it back. Only the
+// blobid is read, never a ciphertext.
+func runDerivekey(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("derivekey", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	passwordFile := fs.String("password-file", "", "read the password from `file`")
+	unsafePrint := fs.Bool("unsafe-print-key", false, "required: acknowledge that the key is printed in the clear")
+	normalize := fs.String("normalize", "none", "Unicode-normalize the password before deriving the key: nfc, nfd or none; changes the key")
+	logf := addLogFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return exitUsage
+	}
+	level, err := logf.level()
+	log := newLogger(stderr, level)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fmt.Fprintf(stderr, "Usage: %s derivekey -unsafe-print-key [-password-file file] <blobid> [password|-]\n", os.Args[0])
+		return exitUsage
+	}
+	if !*unsafePrint {
+		log.errorf("derivekey prints secret key material; pass -unsafe-print-key to confirm")
+		return exitUsage
+	}
+
+	form, err := parseNormalize(*normalize)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+
+	blob, err := n2s.ParseBlobID([]byte(fs.Arg(0)))
+	if err == nil && blob.WrappedKey != nil {
+		err = n2s.ErrWrappedKey
+	}
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	password, err := decryptPassword(fs.Args()[1:], *passwordFile, false, stdin, stderr, log)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	key, err := n2s.DeriveKey(normalizePassword(password, form), blob.Salt, blob.KDF, blob.Cipher.KeySize())
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	defer n2s.Wipe(key)
+
+	// Not subject to -q: whoever reads this stderr should know what just
+	// went to stdout.
+	fmt.Fprintln(stderr, "Warning: the key below opens every blob with this salt and KDF without the password; handle it like the password")
+	fmt.Fprintln(stdout, hex.EncodeToString(key))
+	return 0
+}
@@ -0,0 +1,51 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/derivekey_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestDerivekey(t *testing.T) {
+	// Legacy blobid: salt 00..0f, PBKDF2-SHA256 with 100000 iterations.
+	blobid := "000102030405060708090a0b0c0d0e0f" + strings.Repeat("aa", 12)
+	const want = "57f2c2f0739748d516419b062a884666323c583ea4ae165504a81f7b53c62a09\n"
+
+	var out, errOut bytes.Buffer
+	if code := run([]string{"derivekey", "-unsafe-print-key", blobid, "-"}, strings.NewReader("correct horse"), &out, &errOut); code != 0 {
+		t.Fatalf("exit %d: %s", code, errOut.String())
+	}
+	if out.String() != want {
+		t.Errorf("key %q, want %q", out.String(), want)
+	}
+	if !strings.Contains(errOut.String(), "Warning:") {
+		t.Errorf("no warning on stderr: %q", errOut.String())
+	}
+
+	out.Reset()
+	if code := run([]string{"derivekey", blobid, "-"}, strings.NewReader("correct horse"), &out, &errOut); code != exitUsage || out.Len() != 0 {
+		t.Errorf("without -unsafe-print-key: exit %d, stdout %q", code, out.String())
+	}
+}
+
+func TestDerivekeyOpensBlob(t *testing.T) {
+	blobid, ciphertext := sealClassic(t, []byte("via hsm"), "pw")
+	var key, errOut bytes.Buffer
+	if code := run([]string{"derivekey", "-unsafe-print-key", string(blobid), "-"}, strings.NewReader("pw"), &key, &errOut); code != 0 {
+		t.Fatalf("exit %d: %s", code, errOut.String())
+	}
+	var out bytes.Buffer
+	args := []string{"-key", strings.TrimSpace(key.String()), string(blobid), base64.StdEncoding.EncodeToString(ciphertext)}
+	if code := run(args, nil, &out, &errOut); code != 0 || out.String() != "via hsm" {
+		t.Errorf("decrypt -key: exit %d, %q: %s", code, out.String(), errOut.String())
+	}
+}
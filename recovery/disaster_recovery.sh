@@ -99,14 +99,14 @@ recover_blob() {
     # Decrypt and decompress pipeline
     if [ -n "$output_file" ]; then
         # Output to file
-        if ! echo "$encrypted_b64" | "$DECRYPT_BIN" "$blob_id" "$passphrase" /dev/stdin | lz4 -d > "$output_file"; then
+        if ! echo "$encrypted_b64" | "$DECRYPT_BIN" -in - "$blob_id" "$passphrase" | lz4 -d > "$output_file"; then
             echo "Error: Decryption/decompression failed" >&2
             exit 1
         fi
         echo "Recovered to: $output_file" >&2
     else
         # Output to stdout
-        if ! echo "$encrypted_b64" | "$DECRYPT_BIN" "$blob_id" "$passphrase" /dev/stdin | lz4 -d; then
+        if ! echo "$encrypted_b64" | "$DECRYPT_BIN" -in - "$blob_id" "$passphrase" | lz4 -d; then
             echo "Error: Decryption/decompression failed" >&2
             exit 1
         fi
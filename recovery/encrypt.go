@@ -0,0 +1,257 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/encrypt.go
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"decrypt/n2s"
+)
+
+// kdfFlags are the -kdf flags shared by encrypt and bench.
+type kdfFlags struct {
+	name                  *string
+	iterations            *int
+	time, memory, threads *uint
+}
+
+func addKDFFlags(fs *flag.FlagSet) kdfFlags {
+	return kdfFlags{
+		name:       fs.String("kdf", "pbkdf2", "key derivation: pbkdf2 or argon2id"),
+		iterations: fs.Int("iterations", n2s.LegacyIterations, "PBKDF2 iteration `count`"),
+		time:       fs.Uint("argon2-time", n2s.DefaultArgon2Time, "Argon2id passes"),
+		memory:     fs.Uint("argon2-memory", n2s.DefaultArgon2Memory, "Argon2id memory in `KiB`"),
+		threads:    fs.Uint("argon2-threads", n2s.DefaultArgon2Threads, "Argon2id parallelism"),
+	}
+}
+
+func (f kdfFlags) params() (n2s.KDFParams, error) {
+	switch *f.name {
+	case "pbkdf2":
+		return n2s.KDFParams{ID: n2s.KDFPBKDF2, Iterations: *f.iterations}, nil
+	case "argon2id":
+		if *f.threads > 255 {
+			return n2s.KDFParams{}, usageErrorf("-argon2-threads %d exceeds 255", *f.threads)
+		}
+		return n2s.KDFParams{ID: n2s.KDFArgon2id, Time: uint32(*f.time), Memory: uint32(*f.memory), Threads: uint8(*f.threads)}, nil
+	}
+	return n2s.KDFParams{}, usageErrorf("unknown -kdf %q", *f.name)
+}
+
+// parseCipher maps a -cipher name to its id.
+func parseCipher(name string) (n2s.CipherID, error) {
+	switch name {
+	case "chacha20-poly1305":
+		return n2s.CipherChaCha20Poly1305, nil
+	case "aes-256-gcm":
+		return n2s.CipherAES256GCM, nil
+	}
+	return 0, usageErrorf("unknown -cipher %q", name)
+}
+
+// runEncrypt reads plaintext from stdin and prints "blobid<TAB>ciphertext_b64",
+// or with -blobfile writes a .n2s file and prints just the blobid.
+func runEncrypt(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("encrypt", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	passwordFile := fs.String("password-file", "", "read the password from `file`")
+	blobFile := fs.String("blobfile", "", "write a single .n2s `file` instead of printing blobid and ciphertext")
+	kdfFlags := addKDFFlags(fs)
+	aad := fs.String("aad", "", "bind associated `data`, e.g. the file name, into the authentication tag")
+	cipherName := fs.String("cipher", "chacha20-poly1305", "AEAD: chacha20-poly1305 or aes-256-gcm")
+	nonceLen := fs.Int("nonce-length", n2s.NonceLen, "nonce `bytes`: 12, or 24 for XChaCha20-Poly1305")
+	configFile := fs.String("config", "", "read default -kdf, -iterations, -argon2-*, -cipher, -nonce-length and -normalize values from a JSON `file`; flags override it")
+	chunkSize := fs.Int("chunk-size", 0, "seal in chunks of `bytes` (e.g. 65536) so both sides stream in constant memory; 0 seals in one shot")
+	normalize := fs.String("normalize", "none", "Unicode-normalize the password before deriving the key: nfc, nfd or none; changes the key")
+	storeHash := fs.Bool("store-hash", false, "record the plaintext's SHA-256 in the blobid header for decrypt -verify-hash (visible without the password)")
+	timestamp := fs.Bool("timestamp", false, "record the creation time in the blobid header for info (visible without the password, authenticated with the ciphertext)")
+	strength := addStrengthFlags(fs)
+	encryptDirSrc := fs.String("encrypt-dir", "", "seal every file under `dir` into -out as <blobid>.b64 and write -out/manifest.json mapping original paths to blobids")
+	dirOut := fs.String("out", "", "output `dir` for -encrypt-dir")
+	dirN2S := fs.Bool("n2s", false, "with -encrypt-dir, write <blobid>.n2s files instead of <blobid>.b64")
+	masterKeyFile := fs.String("master-key-file", "", "seal an envelope blob: a random data key wrapped under the master key in `file` (64 hex characters), with no password or KDF")
+	logf := addLogFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return exitUsage
+	}
+	level, err := logf.level()
+	log := newLogger(stderr, level)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	if *configFile != "" {
+		if err := applyConfig(fs, *configFile); err != nil {
+			log.errorf("%v", err)
+			return failureCode(err)
+		}
+	}
+
+	kdf, err := kdfFlags.params()
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+
+	aeadID, err := parseCipher(*cipherName)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+
+	switch {
+	case (*encryptDirSrc == "") != (*dirOut == ""):
+		log.errorf("-encrypt-dir and -out go together")
+		return exitUsage
+	case *encryptDirSrc == "" && *dirN2S:
+		log.errorf("-n2s needs -encrypt-dir")
+		return exitUsage
+	case *encryptDirSrc != "" && (*blobFile != "" || *aad != ""):
+		log.errorf("-encrypt-dir names its own outputs and seals without associated data; drop -blobfile and -aad")
+		return exitUsage
+	}
+
+	if *storeHash && *chunkSize > 0 {
+		log.errorf("-store-hash needs the whole plaintext before the blobid; it cannot be combined with -chunk-size")
+		return exitUsage
+	}
+
+	form, err := parseNormalize(*normalize)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+
+	var password string
+	var masterKey []byte
+	plaintextIn := stdin
+	if *masterKeyFile != "" {
+		if fs.NArg() > 0 || *passwordFile != "" {
+			log.errorf("-master-key-file replaces the password; drop the password argument or -password-file")
+			return exitUsage
+		}
+		if masterKey, err = readMasterKeyFile(*masterKeyFile); err != nil {
+			log.errorf("%v", err)
+			return failureCode(err)
+		}
+		defer n2s.Wipe(masterKey)
+		kdf = n2s.KDFParams{}
+	} else {
+		if password, plaintextIn, err = encryptPassword(fs.Args(), *passwordFile, stdin, stderr); err != nil {
+			log.errorf("%v", err)
+			return failureCode(err)
+		}
+		password = normalizePassword(password, form)
+		if err := strength.check(password); err != nil {
+			log.errorf("%v", err)
+			return failureCode(err)
+		}
+	}
+
+	opts := n2s.EncryptOptions{KDF: kdf, Cipher: aeadID, NonceLen: *nonceLen, AdditionalData: []byte(*aad), ChunkSize: *chunkSize,
+		StoreHash: *storeHash, MasterKey: masterKey}
+	if *timestamp {
+		opts.Created = time.Now()
+	}
+	if *encryptDirSrc != "" {
+		skipped, err := encryptDir(*encryptDirSrc, *dirOut, password, opts, *dirN2S, stdout)
+		if skipped > 0 {
+			log.warnf("skipped %d entries that are not regular files", skipped)
+		}
+		if err != nil {
+			log.errorf("%v", err)
+			return failureCode(err)
+		}
+		return 0
+	}
+	if *chunkSize > 0 {
+		if err := encryptChunked(plaintextIn, password, opts, *blobFile, stdout); err != nil {
+			log.errorf("%v", err)
+			return failureCode(err)
+		}
+		return 0
+	}
+
+	plaintext, err := io.ReadAll(plaintextIn)
+	if err != nil {
+		log.errorf("reading plaintext: %v", err)
+		return failureCode(err)
+	}
+
+	if *blobFile != "" {
+		raw, ciphertext, err := n2s.Seal(plaintext, password, opts)
+		if err == nil {
+			err = writeBlobFile(*blobFile, raw, ciphertext)
+		}
+		if err != nil {
+			log.errorf("%v", err)
+			return failureCode(err)
+		}
+		fmt.Fprintln(stdout, hex.EncodeToString(raw))
+		return 0
+	}
+
+	blobid, ciphertextB64, err := n2s.EncryptWith(plaintext, password, opts)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+
+	fmt.Fprintf(stdout, "%s\t%s\n", blobid, ciphertextB64)
+	return 0
+}
+
+// encryptChunked streams a chunked blob straight from r into the .n2s
+// file or, without one, onto stdout as "blobid<TAB>ciphertext_b64".
+func encryptChunked(r io.Reader, password string, opts n2s.EncryptOptions, blobFile string, stdout io.Writer) error {
+	s, err := n2s.NewSealer(password, opts)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if blobFile != "" {
+		f, err := createAtomic(blobFile)
+		if err != nil {
+			return err
+		}
+		defer f.Abort()
+		if _, err := f.Write(encodeBlobFile(s.BlobID(), nil)); err != nil {
+			return fmt.Errorf("writing output: %w", err)
+		}
+		if err := s.SealTo(f, r); err != nil {
+			return err
+		}
+		if err := f.Commit(); err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, hex.EncodeToString(s.BlobID()))
+		return nil
+	}
+
+	fmt.Fprintf(stdout, "%s\t", hex.EncodeToString(s.BlobID()))
+	enc := base64.NewEncoder(base64.StdEncoding, stdout)
+	if err := s.SealTo(enc, r); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("writing ciphertext: %w", err)
+	}
+	fmt.Fprintln(stdout)
+	return nil
+}
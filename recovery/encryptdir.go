@@ -0,0 +1,159 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/encryptdir.go
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"decrypt/n2s"
+)
+
+// dirManifestName is the manifest encrypt -encrypt-dir leaves beside its
+// blobs, and batch -restore-names reads.
+const dirManifestName = "manifest.json"
+
+// dirManifest records where each blob of an encrypted tree came from.
+// Paths use forward slashes on every platform.
+type dirManifest struct {
+	Version int            `json:"version"`
+	Files   []dirFileEntry `json:"files"`
+}
+
+type dirFileEntry struct {
+	// Path is the original file, relative to the encrypted directory.
+	Path   string `json:"path"`
+	BlobID string `json:"blobid"`
+	// File is the blob's file name in the output directory:
+	// <blobid>.b64 or <blobid>.n2s.
+	File string `json:"file"`
+}
+
+// encryptDir seals every regular file under src into dst as
+// <blobid>.b64 (base64 ciphertext) or, with asN2S, <blobid>.n2s, then
+// writes dst/manifest.json last, so a manifest only exists for a
+// complete tree. Each file gets its own salt and nonce; should the random
+// source ever repeat a pair, which would reuse a nonce under the same key,
+// the run stops with errNonceReuse before that blob is written. Other
+// file types are skipped and counted; dst itself is skipped if it lies
+// inside src.
+func encryptDir(src, dst, password string, opts n2s.EncryptOptions, asN2S bool, stdout io.Writer) (skipped int, err error) {
+	if err := os.MkdirAll(dst, 0o700); err != nil {
+		return 0, fmt.Errorf("creating output directory: %w", err)
+	}
+	dstAbs, err := filepath.Abs(dst)
+	if err != nil {
+		return 0, err
+	}
+	manifest := dirManifest{Version: 1}
+	// sealed maps each salt and nonce emitted so far to its file.
+	sealed := make(map[string]string)
+	err = filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if abs, err := filepath.Abs(path); err == nil && abs == dstAbs {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			skipped++
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		plaintext, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading plaintext: %w", err)
+		}
+		raw, ciphertext, err := n2s.Seal(plaintext, password, opts)
+		n2s.Wipe(plaintext)
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		blob, err := n2s.ParseBlob(raw)
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		pair := string(blob.Salt) + string(blob.Nonce)
+		if prev, ok := sealed[pair]; ok {
+			return fmt.Errorf("%w: %s drew the salt and nonce of %s; neither it nor the manifest was written, and the random source cannot be trusted", errNonceReuse, rel, prev)
+		}
+		sealed[pair] = rel
+		blobid := hex.EncodeToString(raw)
+		name := blobid + ".b64"
+		if asN2S {
+			name = blobid + ".n2s"
+			err = writeBlobFile(filepath.Join(dst, name), raw, ciphertext)
+		} else {
+			err = writeAtomic(filepath.Join(dst, name), []byte(base64.StdEncoding.EncodeToString(ciphertext)+"\n"))
+		}
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, dirFileEntry{Path: filepath.ToSlash(rel), BlobID: blobid, File: name})
+		fmt.Fprintf(stdout, "%s\t%s\n", filepath.ToSlash(rel), blobid)
+		return nil
+	})
+	if err != nil {
+		return skipped, fmt.Errorf("encrypting %s: %w", src, err)
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return skipped, err
+	}
+	return skipped, writeAtomic(filepath.Join(dst, dirManifestName), append(data, '\n'))
+}
+
+// errNonceReuse stops encrypt -encrypt-dir when two files would share a
+// salt and nonce: the same password then derives the same key, and a
+// repeated nonce under one key exposes both plaintexts.
+var errNonceReuse = errors.New("NONCE REUSE: refusing to write a blob that would repeat a key and nonce")
+
+// readDirManifest turns dir/manifest.json into batch entries that restore
+// each blob to its original path. A path or file name that is absolute
+// or climbs out of its directory is refused outright: the manifest sits
+// beside the ciphertexts and is no more trustworthy than they are.
+func readDirManifest(dir string) ([]manifestEntry, error) {
+	data, err := os.ReadFile(filepath.Join(dir, dirManifestName))
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+	var m dirManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, &decodeError{fmt.Errorf("parsing %s: %w", dirManifestName, err)}
+	}
+	if m.Version != 1 {
+		return nil, &decodeError{fmt.Errorf("%s: unsupported version %d", dirManifestName, m.Version)}
+	}
+	entries := make([]manifestEntry, 0, len(m.Files))
+	for _, f := range m.Files {
+		path := filepath.FromSlash(f.Path)
+		switch {
+		case !filepath.IsLocal(path):
+			return nil, &decodeError{fmt.Errorf("%s: path %q leaves the output directory", dirManifestName, f.Path)}
+		case !filepath.IsLocal(f.File) || strings.ContainsAny(f.File, `/\`):
+			return nil, &decodeError{fmt.Errorf("%s: blob file %q is not a plain file name", dirManifestName, f.File)}
+		}
+		entries = append(entries, manifestEntry{BlobID: f.BlobID, src: filepath.Join(dir, f.File), dest: path})
+	}
+	return entries, nil
+}
@@ -0,0 +1,125 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/encryptdir_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"decrypt/n2s"
+)
+
+func TestEncryptDirRestoreNames(t *testing.T) {
+	for _, format := range []string{"b64", "n2s"} {
+		t.Run(format, func(t *testing.T) {
+			src := t.TempDir()
+			files := map[string]string{
+				"top.txt":           "top",
+				"a/report.csv":      "id,name\n1,x\n",
+				"a/b/notes copy.md": "nested",
+			}
+			for name, content := range files {
+				path := filepath.Join(src, name)
+				os.MkdirAll(filepath.Dir(path), 0o700)
+				if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			enc := t.TempDir()
+			args := []string{"encrypt", "-allow-weak-password", "-encrypt-dir", src, "-out", enc, "-password-file", writePasswordFile(t, "pw")}
+			if format == "n2s" {
+				args = append(args, "-n2s")
+			}
+			var stdout, stderr bytes.Buffer
+			if code := run(args, nil, &stdout, &stderr); code != 0 {
+				t.Fatalf("encrypt: exit %d: %s", code, stderr.String())
+			}
+			if n := strings.Count(stdout.String(), "\n"); n != len(files) {
+				t.Errorf("encrypt printed %d lines, want %d: %q", n, len(files), stdout.String())
+			}
+			blobs, _ := filepath.Glob(filepath.Join(enc, "*."+format))
+			if len(blobs) != len(files) {
+				t.Errorf("%d .%s files in the output, want %d", len(blobs), format, len(files))
+			}
+
+			out := t.TempDir()
+			stdout.Reset()
+			stderr.Reset()
+			if code := run([]string{"batch", "-recurse", enc, "-restore-names", "-out", out, "-"}, strings.NewReader("pw"), &stdout, &stderr); code != 0 {
+				t.Fatalf("batch: exit %d: %s", code, stderr.String())
+			}
+			for name, want := range files {
+				if got, err := os.ReadFile(filepath.Join(out, name)); err != nil || string(got) != want {
+					t.Errorf("%s: %q, %v", name, got, err)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreNamesRefusesEscapes(t *testing.T) {
+	for _, f := range []dirFileEntry{
+		{Path: "../outside", File: "x.b64"},
+		{Path: "/etc/passwd", File: "x.b64"},
+		{Path: "ok", File: "../x.b64"},
+	} {
+		dir := t.TempDir()
+		data, _ := json.Marshal(dirManifest{Version: 1, Files: []dirFileEntry{f}})
+		if err := os.WriteFile(filepath.Join(dir, dirManifestName), data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"batch", "-recurse", dir, "-restore-names", "-out", t.TempDir(), "-"}, strings.NewReader("pw"), &stdout, &stderr)
+		if code != exitDecode {
+			t.Errorf("%+v: exit %d, want %d: %s", f, code, exitDecode, stderr.String())
+		}
+	}
+}
+
+func TestEncryptDirFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-encrypt-dir", "src"},
+		{"-out", "dst"},
+		{"-n2s"},
+		{"-encrypt-dir", "src", "-out", "dst", "-aad", "x"},
+	} {
+		var stdout, stderr bytes.Buffer
+		if code := run(append([]string{"encrypt"}, args...), nil, &stdout, &stderr); code != exitUsage {
+			t.Errorf("%q: exit %d, want %d", args, code, exitUsage)
+		}
+	}
+}
+
+func TestEncryptDirAbortsOnNonceReuse(t *testing.T) {
+	src := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(name), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dst := t.TempDir()
+	// A source of nothing but zeros hands every file the same salt and
+	// nonce, and so, under one password, the same key.
+	opts := n2s.EncryptOptions{Rand: bytes.NewReader(make([]byte, 1<<12))}
+	_, err := encryptDir(src, dst, "pw", opts, false, io.Discard)
+	if !errors.Is(err, errNonceReuse) || !strings.Contains(err.Error(), "b.txt drew the salt and nonce of a.txt") {
+		t.Fatalf("err %v, want errNonceReuse naming b.txt and a.txt", err)
+	}
+	blobs, _ := filepath.Glob(filepath.Join(dst, "*.b64"))
+	if len(blobs) != 1 || fileExists(filepath.Join(dst, dirManifestName)) {
+		t.Errorf("%d blobs and manifest %v after the abort, want only a.txt's blob", len(blobs), fileExists(filepath.Join(dst, dirManifestName)))
+	}
+}
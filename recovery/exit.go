@@ -0,0 +1,122 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/exit.go
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"decrypt/n2s"
+)
+
+// Exit codes, so scripts and monitoring can tell a bad invocation from a
+// wrong password from a damaged blob from a full disk. They are part of
+// the interface: never renumber them.
+const (
+	exitOK         = 0
+	exitFailure    = 1 // anything not covered below, e.g. some batch entries failed
+	exitUsage      = 2
+	exitAuthFailed = 3
+	exitDecode     = 4
+	exitIO         = 5
+	// exitTrouble is compare's exitFailure: compare keeps 1 for plaintexts
+	// that differ, as cmp and diff do, so anything above it is trouble.
+	exitTrouble   = 6
+	exitDifferent = exitFailure
+)
+
+// writeExitCodes documents the exit codes for -h.
+func writeExitCodes(w io.Writer) {
+	fmt.Fprintln(w, "\nExit status:")
+	fmt.Fprintf(w, "  %d  success\n", exitOK)
+	fmt.Fprintf(w, "  %d  other failure (batch: at least one entry failed)\n", exitFailure)
+	fmt.Fprintf(w, "  %d  usage error: bad flags or arguments, no password source\n", exitUsage)
+	fmt.Fprintf(w, "  %d  authentication failed: wrong password or key, corrupted ciphertext, or a -verify-hash mismatch\n", exitAuthFailed)
+	fmt.Fprintf(w, "  %d  decode error: malformed blobid, header, base64 or blob file, or truncated ciphertext\n", exitDecode)
+	fmt.Fprintf(w, "  %d  I/O error reading input, including an -in URL download, or writing output\n", exitIO)
+	fmt.Fprintf(w, "  %d  compare: any other failure, %d meaning only that the plaintexts differ\n", exitTrouble, exitDifferent)
+}
+
+// usageError marks a bad invocation rather than bad input.
+type usageError struct{ msg string }
+
+func (e *usageError) Error() string { return e.msg }
+
+func usageErrorf(format string, a ...any) error {
+	return &usageError{fmt.Sprintf(format, a...)}
+}
+
+// decodeError marks a blob file that does not parse; a malformed blobid
+// or header comes back from n2s as an *n2s.FormatError.
+type decodeError struct{ err error }
+
+func (e *decodeError) Error() string { return e.err.Error() }
+func (e *decodeError) Unwrap() error { return e.err }
+
+// failureCode maps an error to its exit code.
+func failureCode(err error) int {
+	var (
+		usage     *usageError
+		decode    *decodeError
+		format    *n2s.FormatError
+		pathErr   *fs.PathError
+		linkErr   *os.LinkError
+		fetchErr  *fetchError
+		corrupt   base64.CorruptInputError
+		badHex    hex.InvalidByteError
+		badSyntax *json.SyntaxError
+	)
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &usage), errors.Is(err, n2s.ErrOutOfRange):
+		return exitUsage
+	case errors.Is(err, n2s.ErrAuthFailed), errors.Is(err, n2s.ErrHashMismatch):
+		return exitAuthFailed
+	case errors.As(err, &pathErr), errors.As(err, &linkErr), errors.As(err, &fetchErr):
+		return exitIO
+	case errors.Is(err, n2s.ErrTruncated), errors.Is(err, n2s.ErrBadBase64), errors.Is(err, n2s.ErrBadHex), errors.As(err, &decode), errors.As(err, &format), errors.As(err, &corrupt),
+		errors.As(err, &badHex), errors.Is(err, hex.ErrLength), errors.As(err, &badSyntax):
+		return exitDecode
+	}
+	return exitFailure
+}
+
+// failureKinds names the classes of failure a batch summary tallies, in
+// the order it lists them.
+var failureKinds = []struct {
+	err  error
+	name string
+}{
+	{n2s.ErrAuthFailed, "auth"},
+	{n2s.ErrHashMismatch, "hash mismatch"},
+	{n2s.ErrBlobTooShort, "blobid too short"},
+	{n2s.ErrBadHex, "bad hex"},
+	{n2s.ErrLegacyRejected, "legacy rejected"},
+	{n2s.ErrUnsupportedFormat, "unsupported format"},
+	{n2s.ErrMalformedHeader, "malformed header"},
+	{n2s.ErrBadBase64, "bad base64"},
+	{n2s.ErrTruncated, "truncated"},
+}
+
+// failureKind is the failureKinds name matching err, or "other".
+func failureKind(err error) string {
+	for _, k := range failureKinds {
+		if errors.Is(err, k.err) {
+			return k.name
+		}
+	}
+	return "other"
+}
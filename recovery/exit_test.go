@@ -0,0 +1,92 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/exit_test.go
+
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain lets exit-code tests re-run this test binary as the real
+// command, so os.Exit itself is exercised.
+func TestMain(m *testing.M) {
+	if os.Getenv("DECRYPT_TEST_MAIN") == "1" {
+		main()
+	}
+	os.Exit(m.Run())
+}
+
+func runBinary(t *testing.T, stdin string, args ...string) (int, string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "DECRYPT_TEST_MAIN=1")
+	cmd.Stdin = strings.NewReader(stdin)
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0, string(out)
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode(), string(out)
+	}
+	t.Fatalf("running %v: %v", args, err)
+	return 0, ""
+}
+
+func TestExitCodes(t *testing.T) {
+	blobid, ciphertext := sealClassic(t, []byte("exit codes"), "pw")
+	b64 := base64.StdEncoding.EncodeToString(ciphertext)
+	id := string(blobid)
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	cases := []struct {
+		name  string
+		stdin string
+		args  []string
+		want  int
+	}{
+		{"success", "pw", []string{id, "-", b64}, exitOK},
+		{"help", "", []string{"-h"}, exitOK},
+		{"no arguments", "", nil, exitUsage},
+		{"unknown flag", "", []string{"-bogus", id, b64}, exitUsage},
+		{"no password source", "", []string{id, b64}, exitUsage},
+		{"conflicting flags", "", []string{"-in", "x", "-blobfile", "y"}, exitUsage},
+		{"wrong password", "nope", []string{id, "-", b64}, exitAuthFailed},
+		{"bad base64", "pw", []string{id, "-", "!!!"}, exitDecode},
+		{"bad blobid hex", "pw", []string{"zz" + id[2:], "-", b64}, exitDecode},
+		{"bad blobid length", "pw", []string{id[:40], "-", b64}, exitDecode},
+		{"truncated", "pw", []string{id, "-", "AAAA"}, exitDecode},
+		{"missing input file", "pw", []string{"-in", missing, id, "-"}, exitIO},
+		{"unwritable output", "pw", []string{"-out", filepath.Join(missing, "plain"), id, "-", b64}, exitIO},
+		{"encrypt bad kdf", "pw\n", []string{"encrypt", "-kdf", "md5"}, exitUsage},
+		{"info bad blobid", "", []string{"info", "abc"}, exitDecode},
+		{"selftest", "", []string{"selftest"}, exitOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if code, out := runBinary(t, tc.stdin, tc.args...); code != tc.want {
+				t.Errorf("exit %d, want %d\n%s", code, tc.want, out)
+			}
+		})
+	}
+}
+
+func TestHelpDocumentsExitCodes(t *testing.T) {
+	_, out := runBinary(t, "", "-h")
+	for _, want := range []string{"Exit status:", "usage error", "authentication failed", "decode error", "I/O error"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("-h output lacks %q", want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/fetch.go
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// defaultMaxFetch caps a download when -max-fetch is not given.
+const defaultMaxFetch = 1 << 30
+
+// fetcher streams the body a URL names. Fetch returns only once the
+// response is known to be good; the body is read afterwards, still under
+// ctx.
+type fetcher interface {
+	Fetch(ctx context.Context, u *url.URL) (io.ReadCloser, error)
+}
+
+// fetchers maps a URL scheme to its fetcher. An s3:// store plugs in here
+// with its own client; none is built in, since that would bring an SDK
+// into a recovery tool that should stay small.
+var fetchers = map[string]fetcher{
+	"http":  httpFetcher{http.DefaultClient},
+	"https": httpFetcher{http.DefaultClient},
+}
+
+// httpFetcher GETs a URL. Credentials in the URL's user info are sent as
+// basic auth.
+type httpFetcher struct{ client *http.Client }
+
+func (h httpFetcher) Fetch(ctx context.Context, u *url.URL) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("server answered %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// fetchError marks a failed download, so it exits as an I/O error.
+type fetchError struct {
+	url string
+	err error
+}
+
+func (e *fetchError) Error() string { return fmt.Sprintf("fetching %s: %v", e.url, e.err) }
+func (e *fetchError) Unwrap() error { return e.err }
+
+// remoteURL reports whether an -in value names a URL with a registered
+// fetcher rather than a local file. A scheme with no fetcher is an error,
+// not a file name.
+func remoteURL(name string) (*url.URL, bool, error) {
+	u, err := url.Parse(name)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, false, nil
+	}
+	if _, ok := fetchers[u.Scheme]; !ok {
+		return nil, false, usageErrorf("no fetcher for %s:// URLs", u.Scheme)
+	}
+	return u, true, nil
+}
+
+// openURL starts a download of at most max bytes. Reading past max fails
+// rather than silently truncating the ciphertext.
+func openURL(ctx context.Context, u *url.URL, max int64) (io.ReadCloser, error) {
+	shown := u.Redacted()
+	body, err := fetchers[u.Scheme].Fetch(ctx, u)
+	if err != nil {
+		return nil, &fetchError{shown, err}
+	}
+	return readCloser{&capReader{r: body, left: max, url: shown}, body}, nil
+}
+
+// capReader passes through at most left bytes and labels read errors as
+// fetch errors.
+type capReader struct {
+	r    io.Reader
+	left int64
+	url  string
+}
+
+func (c *capReader) Read(b []byte) (int, error) {
+	if c.left < 0 {
+		return 0, &fetchError{c.url, fmt.Errorf("body exceeds -max-fetch")}
+	}
+	if int64(len(b)) > c.left+1 {
+		b = b[:c.left+1]
+	}
+	n, err := c.r.Read(b)
+	c.left -= int64(n)
+	if c.left < 0 {
+		n += int(c.left)
+		err = &fetchError{c.url, fmt.Errorf("body exceeds -max-fetch")}
+	} else if err != nil && err != io.EOF {
+		err = &fetchError{c.url, err}
+	}
+	return n, err
+}
@@ -0,0 +1,81 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/fetch_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecryptFromURL(t *testing.T) {
+	plaintext := []byte("fetched over http")
+	blobid, ciphertext := sealClassic(t, plaintext, "pw")
+	encoded := base64.StdEncoding.EncodeToString(ciphertext)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "ops" || pass != "s3cret" {
+			http.Error(w, "no", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/blob" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(encoded))
+	}))
+	defer srv.Close()
+	pwFile := filepath.Join(t.TempDir(), "pw")
+	if err := os.WriteFile(pwFile, []byte("pw"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	authed := strings.Replace(srv.URL, "http://", "http://ops:s3cret@", 1)
+
+	var out, errOut bytes.Buffer
+	if code := run([]string{"-password-file", pwFile, "-in", authed + "/blob", string(blobid)}, nil, &out, &errOut); code != 0 {
+		t.Fatalf("exit %d: %s", code, errOut.String())
+	}
+	if !bytes.Equal(out.Bytes(), plaintext) {
+		t.Errorf("plaintext %q", out.Bytes())
+	}
+
+	for _, tc := range []struct {
+		name, url string
+		extra     []string
+		want      string
+	}{
+		{"not found", authed + "/missing", nil, "404 Not Found"},
+		{"unauthorized", srv.URL + "/blob", nil, "401 Unauthorized"},
+		{"too big", authed + "/blob", []string{"-max-fetch", "10"}, "body exceeds -max-fetch"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var errOut bytes.Buffer
+			args := append(append([]string{"-password-file", pwFile}, tc.extra...), "-in", tc.url, string(blobid))
+			if code := run(args, nil, &bytes.Buffer{}, &errOut); code != exitIO {
+				t.Errorf("exit %d, want %d: %s", code, exitIO, errOut.String())
+			}
+			if !strings.Contains(errOut.String(), tc.want) || strings.Contains(errOut.String(), "s3cret") {
+				t.Errorf("stderr %q", errOut.String())
+			}
+		})
+	}
+}
+
+func TestDecryptURLWithoutFetcher(t *testing.T) {
+	blobid, _ := sealClassic(t, []byte("x"), "pw")
+	var errOut bytes.Buffer
+	code := run([]string{"-password-file", "/dev/null", "-in", "s3://bucket/blob", string(blobid)}, nil, &bytes.Buffer{}, &errOut)
+	if code != exitUsage || !strings.Contains(errOut.String(), "no fetcher for s3://") {
+		t.Errorf("exit %d: %s", code, errOut.String())
+	}
+}
@@ -0,0 +1,134 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/formats.go
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"decrypt/n2s"
+)
+
+// formatsReport is what formats prints: every header version, KDF and
+// cipher this build reads, straight from the n2s package's lists.
+type formatsReport struct {
+	HeaderVersions []int           `json:"header_versions"`
+	KDFs           []formatsKDF    `json:"kdfs"`
+	Ciphers        []formatsCipher `json:"ciphers"`
+	Legacy         formatsLegacy   `json:"legacy"`
+}
+
+type formatsKDF struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type formatsCipher struct {
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	NonceLengths []int  `json:"nonce_lengths"`
+}
+
+// formatsLegacy is what a blobid without a header means.
+type formatsLegacy struct {
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Cipher     string `json:"cipher"`
+	Lengths    []int  `json:"lengths"`
+}
+
+func supportedFormats() formatsReport {
+	r := formatsReport{
+		HeaderVersions: n2s.HeaderVersions(),
+		Legacy: formatsLegacy{
+			KDF:        n2s.LegacyKDF.ID.String(),
+			Iterations: n2s.LegacyKDF.Iterations,
+			Cipher:     n2s.CipherChaCha20Poly1305.String(),
+			Lengths:    n2s.LegacyBlobIDLengths(),
+		},
+	}
+	for _, id := range n2s.KDFs() {
+		r.KDFs = append(r.KDFs, formatsKDF{int(id), id.String()})
+	}
+	for _, id := range n2s.Ciphers() {
+		r.Ciphers = append(r.Ciphers, formatsCipher{int(id), id.String(), id.NonceSizes()})
+	}
+	return r
+}
+
+func writeFormats(w io.Writer, r formatsReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(tw, "header versions:\t%s (0 is legacy, no header)\n", joinInts(r.HeaderVersions))
+	for _, k := range r.KDFs {
+		fmt.Fprintf(tw, "kdf %d:\t%s\n", k.ID, k.Name)
+	}
+	for _, c := range r.Ciphers {
+		fmt.Fprintf(tw, "cipher %d:\t%s, nonce %s bytes\n", c.ID, c.Name, joinInts(c.NonceLengths))
+	}
+	fmt.Fprintf(tw, "legacy blobids:\t%s bytes; %s, %d iterations; %s\n",
+		joinInts(r.Legacy.Lengths), r.Legacy.KDF, r.Legacy.Iterations, r.Legacy.Cipher)
+	return tw.Flush()
+}
+
+func joinInts(ns []int) string {
+	s := make([]string, len(ns))
+	for i, n := range ns {
+		s[i] = fmt.Sprint(n)
+	}
+	return strings.Join(s, ", ")
+}
+
+// withFormatsHint points an error about a header this build cannot read
+// at the formats command.
+func withFormatsHint(err error) error {
+	if errors.Is(err, n2s.ErrUnsupportedHeader) {
+		return fmt.Errorf("%w (run '%s formats' to list what this build reads)", err, os.Args[0])
+	}
+	return err
+}
+
+// runFormats lists the blob formats this build reads, so scripts can
+// tell an unreadable blob before trying it.
+func runFormats(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("formats", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	jsonOut := fs.Bool("json", false, "print a JSON object instead of the table")
+	logf := addLogFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return exitUsage
+	}
+	level, err := logf.level()
+	log := newLogger(stderr, level)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprintf(stderr, "Usage: %s formats [-json]\n", os.Args[0])
+		return exitUsage
+	}
+	report := supportedFormats()
+	if *jsonOut {
+		writeJSON(stdout, report)
+		return 0
+	}
+	if err := writeFormats(stdout, report); err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	return 0
+}
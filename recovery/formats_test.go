@@ -0,0 +1,63 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/formats_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFormatsJSON(t *testing.T) {
+	var out, errOut bytes.Buffer
+	if code := run([]string{"formats", "-json"}, nil, &out, &errOut); code != 0 {
+		t.Fatalf("exit %d: %s", code, errOut.String())
+	}
+	var r formatsReport
+	if err := json.Unmarshal(out.Bytes(), &r); err != nil {
+		t.Fatalf("%v: %s", err, out.String())
+	}
+	if !slices.Contains(r.KDFs, formatsKDF{1, "pbkdf2-sha256"}) {
+		t.Errorf("kdfs %+v lack pbkdf2", r.KDFs)
+	}
+	i := slices.IndexFunc(r.Ciphers, func(c formatsCipher) bool { return c.Name == "chacha20-poly1305" })
+	if i < 0 || r.Ciphers[i].ID != 1 || !slices.Contains(r.Ciphers[i].NonceLengths, 12) {
+		t.Errorf("ciphers %+v lack chacha20-poly1305 with a 12-byte nonce", r.Ciphers)
+	}
+	want := formatsLegacy{"pbkdf2-sha256", 100000, "chacha20-poly1305", []int{28, 32, 40}}
+	if r.Legacy.KDF != want.KDF || r.Legacy.Iterations != want.Iterations || r.Legacy.Cipher != want.Cipher || !slices.Equal(r.Legacy.Lengths, want.Lengths) {
+		t.Errorf("legacy %+v, want %+v", r.Legacy, want)
+	}
+	if !slices.Equal(r.HeaderVersions, []int{0, 1, 2}) {
+		t.Errorf("header versions %v", r.HeaderVersions)
+	}
+}
+
+func TestUnsupportedHeaderNamesFormats(t *testing.T) {
+	// Header byte 0x60 is a version this build does not know.
+	blobid := "60" + hex.EncodeToString(make([]byte, 28))
+	b64 := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	for _, args := range [][]string{{blobid, "pw", b64}, {"info", blobid}} {
+		var out, errOut bytes.Buffer
+		if code := run(args, nil, &out, &errOut); code != exitDecode || !strings.Contains(errOut.String(), "formats' to list") {
+			t.Errorf("%s: exit %d, stderr %q", args[0], code, errOut.String())
+		}
+	}
+
+	// A blobid that is merely the wrong length is not a newer format.
+	var out, errOut bytes.Buffer
+	run([]string{hex.EncodeToString(make([]byte, 30)), "pw", b64}, nil, &out, &errOut)
+	if strings.Contains(errOut.String(), "formats") {
+		t.Errorf("bad length points at formats: %q", errOut.String())
+	}
+}
@@ -4,6 +4,11 @@ go 1.23.0
 
 toolchain go1.23.10
 
-require golang.org/x/crypto v0.39.0
+require (
+	github.com/klauspost/compress v1.18.0
+	golang.org/x/crypto v0.39.0
+	golang.org/x/term v0.32.0
+	golang.org/x/text v0.26.0
+)
 
 require golang.org/x/sys v0.33.0 // indirect
@@ -0,0 +1,183 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/info.go
+
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"decrypt/n2s"
+)
+
+// writeInfo prints what a blob's header and salt say about it and, when
+// known, the ciphertext length and its trailing tag. ciphertextLen is
+// negative when no ciphertext was supplied, and tag is nil unless -tag
+// asked for it.
+func writeInfo(w io.Writer, blob *n2s.Blob, ciphertextLen int64, tag []byte) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+	version := "legacy (no header)"
+	if blob.Version > 0 {
+		version = fmt.Sprintf("%d", blob.Version)
+	}
+	fmt.Fprintf(tw, "blobid:\t%s\n", blob.ID())
+	fmt.Fprintf(tw, "header:\t%s\n", version)
+	fmt.Fprintf(tw, "salt:\t%s\n", hex.EncodeToString(blob.Salt))
+	fmt.Fprintf(tw, "nonce:\t%s\n", hex.EncodeToString(blob.Nonce))
+	fmt.Fprintf(tw, "nonce length:\t%d\n", len(blob.Nonce))
+	fmt.Fprintf(tw, "cipher:\t%s\n", blob.CipherName())
+	if blob.WrappedKey != nil {
+		fmt.Fprintf(tw, "kdf:\tnone (key wrapped under a master key)\n")
+	} else {
+		fmt.Fprintf(tw, "kdf:\t%s\n", blob.KDF.ID)
+	}
+	switch blob.KDF.ID {
+	case n2s.KDFPBKDF2:
+		fmt.Fprintf(tw, "iterations:\t%d\n", blob.KDF.Iterations)
+	case n2s.KDFArgon2id:
+		fmt.Fprintf(tw, "argon2 time:\t%d\n", blob.KDF.Time)
+		fmt.Fprintf(tw, "argon2 memory:\t%d KiB\n", blob.KDF.Memory)
+		fmt.Fprintf(tw, "argon2 threads:\t%d\n", blob.KDF.Threads)
+	}
+	if blob.ChunkSize > 0 {
+		fmt.Fprintf(tw, "chunk size:\t%d\n", blob.ChunkSize)
+	}
+	if blob.PlaintextHash != nil {
+		fmt.Fprintf(tw, "plaintext sha256:\t%s\n", hex.EncodeToString(blob.PlaintextHash))
+	}
+	fmt.Fprintf(tw, "created:\t%s\n", createdString(blob))
+	if blob.Digest != nil {
+		fmt.Fprintf(tw, "producer digest:\t%s\n", hex.EncodeToString(blob.Digest))
+	}
+	if ciphertextLen >= 0 {
+		fmt.Fprintf(tw, "ciphertext bytes:\t%d\n", ciphertextLen)
+	}
+	if tag != nil {
+		label, tags := "tag", int64(1)
+		if blob.ChunkSize > 0 {
+			label = "final chunk tag"
+			tags = (ciphertextLen + int64(blob.ChunkSize+n2s.TagLen) - 1) / int64(blob.ChunkSize+n2s.TagLen)
+		}
+		fmt.Fprintf(tw, "%s:\t%s\n", label, hex.EncodeToString(tag))
+		fmt.Fprintf(tw, "bytes without tags:\t%d\n", ciphertextLen-tags*n2s.TagLen)
+	}
+	return tw.Flush()
+}
+
+// runInfo describes a blob without deriving a key or opening anything, so
+// it needs no password.
+func runInfo(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("info", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	in := fs.String("in", "", "count the base64 ciphertext in `file` ('-' for stdin)")
+	blobFile := fs.String("blobfile", "", "describe a .n2s `file` ('-' for stdin)")
+	layoutDesc := fs.String("layout", "", "read a headerless blobid in this salt and nonce `layout`, as for decrypt")
+	showTag := fs.Bool("tag", false, "also print the ciphertext's trailing 16-byte authentication tag and its length without tags; nothing is decrypted")
+	logf := addLogFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return exitUsage
+	}
+	level, err := logf.level()
+	log := newLogger(stderr, level)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+
+	layout, err := parseLayoutFlag(*layoutDesc)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	if layout != nil && *blobFile != "" {
+		err := usageErrorf("-layout describes a blobid argument; drop -blobfile")
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+
+	var blob *n2s.Blob
+	var body io.ReadCloser
+	var tail tailWriter
+	ciphertextLen := int64(-1)
+	switch {
+	case *blobFile != "" && fs.NArg() == 0 && *in == "":
+		blob, body, err = openBlobFile(*blobFile, stdin, nil)
+	case *blobFile == "" && fs.NArg() == 1:
+		blob, err = n2s.ParseBlobIDLayout([]byte(fs.Arg(0)), layout)
+		if err == nil && *in != "" {
+			body, err = openCiphertext(*in, stdin, "")
+		}
+	case *blobFile == "" && *in == "" && fs.NArg() == 2:
+		var ciphertext []byte
+		if blob, err = n2s.ParseBlobIDLayout([]byte(fs.Arg(0)), layout); err == nil {
+			ciphertext, err = decodeBase64(fs.Arg(1))
+			ciphertextLen = int64(len(ciphertext))
+			tail.Write(ciphertext)
+		}
+	default:
+		fmt.Fprintf(stderr, "Usage: %s info <blobid> [encrypted_b64]\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s info -in <file|-> <blobid>\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s info -blobfile <file.n2s|->\n", os.Args[0])
+		return exitUsage
+	}
+	if err == nil && body != nil {
+		ciphertextLen, err = io.Copy(&tail, body)
+		body.Close()
+	}
+	var tag []byte
+	switch {
+	case err != nil || !*showTag:
+	case ciphertextLen < 0:
+		err = usageErrorf("-tag needs the ciphertext")
+	default:
+		tag, err = tail.tag()
+	}
+	if err != nil {
+		err = withFormatsHint(err)
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+
+	if err := writeInfo(stdout, blob, ciphertextLen, tag); err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	return 0
+}
+
+// tailWriter keeps the last n2s.TagLen bytes written to it.
+type tailWriter struct {
+	buf []byte
+	n   int64
+}
+
+func (t *tailWriter) Write(b []byte) (int, error) {
+	t.n += int64(len(b))
+	t.buf = append(t.buf, b[max(len(b)-n2s.TagLen, 0):]...)
+	if len(t.buf) > n2s.TagLen {
+		t.buf = t.buf[len(t.buf)-n2s.TagLen:]
+	}
+	return len(b), nil
+}
+
+// tag returns the trailing tag, or an error when the ciphertext is too
+// short to hold one.
+func (t *tailWriter) tag() ([]byte, error) {
+	if t.n < n2s.TagLen {
+		return nil, fmt.Errorf("%w: got %d bytes, need at least %d", n2s.ErrTruncated, t.n, n2s.TagLen)
+	}
+	return t.buf, nil
+}
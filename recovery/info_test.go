@@ -0,0 +1,130 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/info_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInfoKnownBlobID(t *testing.T) {
+	var out, errOut bytes.Buffer
+	// 24 bytes of ciphertext: an 8-byte plaintext plus the tag.
+	if code := run([]string{"info", katLegacyBlobID, strings.Repeat("A", 32)}, nil, &out, &errOut); code != 0 {
+		t.Fatalf("exit %d: %s", code, errOut.String())
+	}
+	want := map[string]string{
+		"blobid":           katLegacyBlobID,
+		"header":           "legacy (no header)",
+		"salt":             "000102030405060708090a0b0c0d0e0f",
+		"nonce":            "a0a1a2a3a4a5a6a7a8a9aaab",
+		"nonce length":     "12",
+		"cipher":           "chacha20-poly1305",
+		"kdf":              "pbkdf2-sha256",
+		"iterations":       "100000",
+		"ciphertext bytes": "24",
+		"created":          "unknown",
+	}
+	got := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		k, v, _ := strings.Cut(line, ":")
+		got[k] = strings.TrimSpace(v)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s: %q, want %q", k, got[k], v)
+		}
+	}
+}
+
+func TestInfoArgon2Header(t *testing.T) {
+	var out, errOut bytes.Buffer
+	if code := run([]string{"info", katArgon2BlobID}, nil, &out, &errOut); code != 0 {
+		t.Fatalf("exit %d: %s", code, errOut.String())
+	}
+	for _, want := range []string{"xchacha20-poly1305", "argon2id", "256 KiB"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "ciphertext bytes") {
+		t.Error("ciphertext length printed without a ciphertext")
+	}
+}
+
+func TestInfoTag(t *testing.T) {
+	b64 := base64.StdEncoding.EncodeToString(mustHex(katLegacyCiphertext))
+	var out, errOut bytes.Buffer
+	if code := run([]string{"info", "-tag", katLegacyBlobID, b64}, nil, &out, &errOut); code != 0 {
+		t.Fatalf("exit %d: %s", code, errOut.String())
+	}
+	got := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		k, v, _ := strings.Cut(line, ":")
+		got[k] = strings.TrimSpace(v)
+	}
+	// The fixture's last 16 bytes.
+	if got["tag"] != "278805ef3a8f68d942dd914ff2ce88a8" || got["bytes without tags"] != "31" {
+		t.Errorf("tag %q, %q bytes without tags", got["tag"], got["bytes without tags"])
+	}
+
+	// The same ciphertext streamed from -in gives the same tag.
+	out.Reset()
+	if code := run([]string{"info", "-tag", "-in", "-", katLegacyBlobID}, strings.NewReader(b64), &out, &errOut); code != 0 ||
+		!strings.Contains(out.String(), "278805ef3a8f68d942dd914ff2ce88a8") {
+		t.Errorf("-in: exit %d:\n%s%s", code, out.String(), errOut.String())
+	}
+
+	cases := map[string][]string{
+		"no ciphertext": {"info", "-tag", katLegacyBlobID},
+		"too short":     {"info", "-tag", katLegacyBlobID, "AAAA"},
+	}
+	for name, args := range cases {
+		out.Reset()
+		if code := run(args, nil, &out, &errOut); code == 0 {
+			t.Errorf("%s: exit 0:\n%s", name, out.String())
+		}
+	}
+}
+
+func TestInfoCreated(t *testing.T) {
+	pwFile := writePasswordFile(t, "pw")
+	var out, errOut bytes.Buffer
+	before := time.Now().Unix()
+	if code := run([]string{"encrypt", "-allow-weak-password", "-timestamp", "-password-file", pwFile}, strings.NewReader("dated"), &out, &errOut); code != 0 {
+		t.Fatalf("encrypt: exit %d: %s", code, errOut.String())
+	}
+	blobid, b64, _ := strings.Cut(strings.TrimSpace(out.String()), "\t")
+
+	out.Reset()
+	if code := run([]string{"info", blobid}, nil, &out, &errOut); code != 0 {
+		t.Fatalf("info: exit %d: %s", code, errOut.String())
+	}
+	var created time.Time
+	for _, line := range strings.Split(out.String(), "\n") {
+		if k, v, _ := strings.Cut(line, ":"); k == "created" {
+			created, _ = time.Parse(time.RFC3339, strings.TrimSpace(v))
+		}
+	}
+	if created.Unix() < before || created.Unix() > time.Now().Unix() {
+		t.Errorf("created %v, want about now:\n%s", created, out.String())
+	}
+
+	out.Reset()
+	if code := run([]string{"-json", "-out", filepath.Join(t.TempDir(), "plain"), blobid, "-", b64}, strings.NewReader("pw"), &out, &errOut); code != 0 {
+		t.Fatalf("decrypt: exit %d: %s", code, errOut.String())
+	}
+	if want := `"created":"` + created.Format(time.RFC3339) + `"`; !strings.Contains(out.String(), want) {
+		t.Errorf("-json %s lacks %s", out.String(), want)
+	}
+}
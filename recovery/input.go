@@ -0,0 +1,335 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/input.go
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"decrypt/n2s"
+)
+
+// openInput opens a named file, or stdin for "-".
+func openInput(name string, stdin io.Reader) (io.ReadCloser, error) {
+	if name == "-" {
+		return io.NopCloser(stdin), nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("opening input: %w", err)
+	}
+	return f, nil
+}
+
+// base64Variants are the encodings an unforced decoder tries, in order.
+// Blobs passed through URL-safe channels arrive as base64url, usually
+// without padding.
+var base64Variants = []struct {
+	name string
+	enc  *base64.Encoding
+}{
+	{"std", base64.StdEncoding},
+	{"raw std", base64.RawStdEncoding},
+	{"url", base64.URLEncoding},
+	{"raw url", base64.RawURLEncoding},
+}
+
+// parseBase64Variant checks a -b64 value: "std" (padded standard), "raw"
+// (unpadded standard), "url" (URL-safe, padded or not), or "" to detect
+// the variant.
+func parseBase64Variant(s string) (string, error) {
+	switch s {
+	case "", "std", "raw", "url":
+		return s, nil
+	}
+	return "", usageErrorf("-b64 must be std, url or raw, got %q", s)
+}
+
+// decodeBase64 decodes any of the base64Variants.
+func decodeBase64(s string) ([]byte, error) {
+	return decodeBase64As(s, "")
+}
+
+// decodeBase64As decodes s as the given -b64 variant, returning the first
+// encoding that decodes all of it. ASCII whitespace is dropped first, so
+// base64 wrapped at 64 or 76 columns, or indented, decodes as one piece.
+func decodeBase64As(s, variant string) ([]byte, error) {
+	s = stripSpace(s)
+	var tried []string
+	var firstErr error
+	for _, v := range base64Variants {
+		if !variantMatches(variant, v.name) {
+			continue
+		}
+		data, err := v.enc.DecodeString(s)
+		if err == nil {
+			return data, nil
+		}
+		tried = append(tried, v.name)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if len(tried) == 1 {
+		return nil, fmt.Errorf("%w: %w", n2s.ErrBadBase64, firstErr)
+	}
+	return nil, fmt.Errorf("%w (tried %s): %w", n2s.ErrBadBase64, strings.Join(tried, ", "), firstErr)
+}
+
+// stripSpace drops ASCII whitespace from s, returning s itself when it
+// has none.
+func stripSpace(s string) string {
+	if strings.IndexAny(s, asciiSpace) < 0 {
+		return s
+	}
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(asciiSpace, r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+const asciiSpace = " \t\n\v\f\r"
+
+func variantMatches(variant, name string) bool {
+	switch variant {
+	case "":
+		return true
+	case "raw":
+		return name == "raw std"
+	case "url":
+		return name == "url" || name == "raw url"
+	}
+	return name == variant
+}
+
+// blobFromParts decodes the -salt and -nonce flags into a blob.
+func blobFromParts(saltHex, nonceHex string) (*n2s.Blob, error) {
+	salt, err := hex.DecodeString(saltHex)
+	if err != nil {
+		return nil, fmt.Errorf("decoding -salt: %w: %w", n2s.ErrBadHex, err)
+	}
+	nonce, err := hex.DecodeString(nonceHex)
+	if err != nil {
+		return nil, fmt.Errorf("decoding -nonce: %w: %w", n2s.ErrBadHex, err)
+	}
+	return n2s.BlobFromParts(salt, nonce)
+}
+
+// parseLayoutFlag parses -layout; empty is the n2s layout, returned as
+// nil.
+func parseLayoutFlag(desc string) (*n2s.Layout, error) {
+	if desc == "" {
+		return nil, nil
+	}
+	l, err := n2s.ParseLayout(desc)
+	if err != nil {
+		return nil, usageErrorf("-layout: %v", err)
+	}
+	return l, nil
+}
+
+// checkNoBlobID refuses a blobid argument given alongside -salt and
+// -nonce. What is left is [password|-] [encrypted_b64], the ciphertext
+// absent with -in, so an extra argument is a blobid; so is a would-be
+// password that parses as one, which would otherwise just fail to
+// authenticate.
+func checkNoBlobID(pos []string, fromIn bool) error {
+	max := 2
+	if fromIn {
+		max = 1
+	}
+	if len(pos) > max || (len(pos) == max && isBlobID(pos[0])) {
+		return usageErrorf("-salt and -nonce replace the blobid argument; drop one or the other")
+	}
+	return nil
+}
+
+func isBlobID(s string) bool {
+	_, err := n2s.ParseBlobID([]byte(s))
+	return err == nil
+}
+
+// readCiphertext stream-decodes base64 ciphertext from a file or stdin, so
+// multi-megabyte blobs never exist as a single encoded string.
+func readCiphertext(name string, stdin io.Reader) ([]byte, error) {
+	r, err := openCiphertext(name, stdin, "")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readAllCapped(r, defaultMaxCiphertext)
+}
+
+// defaultMaxCiphertext caps a classic ciphertext, which is opened in one
+// piece and so has to fit in memory. Chunked blobs stream and have no cap.
+const defaultMaxCiphertext = 1 << 30
+
+// readAllCapped is io.ReadAll refusing more than max bytes; it reads at
+// most one byte past max to find out.
+func readAllCapped(r io.Reader, max int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, max+1))
+	if err == nil && int64(len(data)) > max {
+		return nil, &decodeError{fmt.Errorf("ciphertext exceeds %d bytes; seal blobs that large with -chunk-size", max)}
+	}
+	return data, err
+}
+
+// openCiphertext is readCiphertext for callers that consume the decoded
+// ciphertext as a stream, with the -b64 variant to decode.
+func openCiphertext(name string, stdin io.Reader, variant string) (io.ReadCloser, error) {
+	r, err := openInput(name, stdin)
+	if err != nil {
+		return nil, err
+	}
+	return decodeCiphertext(r, variant), nil
+}
+
+// decodeCiphertext stream-decodes base64 ciphertext from r as the given
+// -b64 variant, skipping whitespace as decodeBase64As does; closing the
+// result closes r.
+func decodeCiphertext(r io.ReadCloser, variant string) io.ReadCloser {
+	var dec io.Reader
+	in := &spaceReader{r: r}
+	switch variant {
+	case "std":
+		dec = base64.NewDecoder(base64.StdEncoding, in)
+	case "raw":
+		dec = base64.NewDecoder(base64.RawStdEncoding, in)
+	case "url":
+		dec = base64.NewDecoder(base64.URLEncoding, &padReader{r: in})
+	default:
+		dec = base64.NewDecoder(base64.StdEncoding, &padReader{r: &alphabetReader{r: in}})
+	}
+	return readCloser{&labelReader{r: dec, label: "decoding base64", corrupt: n2s.ErrBadBase64}, r}
+}
+
+// spaceReader drops ASCII whitespace from r. base64.NewDecoder skips
+// only CR and LF; exports that indent or space-separate their lines need
+// the rest gone too.
+type spaceReader struct {
+	r io.Reader
+}
+
+func (s *spaceReader) Read(b []byte) (int, error) {
+	for {
+		n, err := s.r.Read(b)
+		kept := 0
+		for _, c := range b[:n] {
+			if strings.IndexByte(asciiSpace, c) < 0 {
+				b[kept] = c
+				kept++
+			}
+		}
+		if kept > 0 || err != nil || n == 0 {
+			return kept, err
+		}
+	}
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// labelReader prefixes read errors other than io.EOF with label, so a
+// stream's failures say where they came from.
+type labelReader struct {
+	r     io.Reader
+	label string
+	// corrupt, if set, is also wrapped around a base64.CorruptInputError.
+	corrupt error
+}
+
+func (l *labelReader) Read(b []byte) (int, error) {
+	n, err := l.r.Read(b)
+	var bad base64.CorruptInputError
+	switch {
+	case err == nil, err == io.EOF:
+	case l.corrupt != nil && errors.As(err, &bad):
+		err = fmt.Errorf("%s: %w: %w", l.label, l.corrupt, err)
+	default:
+		err = fmt.Errorf("%s: %w", l.label, err)
+	}
+	return n, err
+}
+
+// alphabetReader rewrites the URL-safe alphabet's '-' and '_' to the
+// standard '+' and '/', so one streaming StdEncoding decoder reads both
+// variants. A stream that mixes the two alphabets is corrupt.
+type alphabetReader struct {
+	r        io.Reader
+	off      int64
+	std, url bool
+}
+
+func (a *alphabetReader) Read(b []byte) (int, error) {
+	n, err := a.r.Read(b)
+	for i, c := range b[:n] {
+		switch c {
+		case '+', '/':
+			a.std = true
+		case '-':
+			a.url, b[i] = true, '+'
+		case '_':
+			a.url, b[i] = true, '/'
+		default:
+			continue
+		}
+		if a.std && a.url {
+			return i, base64.CorruptInputError(a.off + int64(i))
+		}
+	}
+	a.off += int64(n)
+	return n, err
+}
+
+// padReader appends the '=' padding RawStdEncoding omits once the
+// underlying reader is exhausted, so one streaming StdEncoding decoder
+// handles both forms. base64.NewDecoder already skips CR and LF, so those
+// are not counted.
+type padReader struct {
+	r   io.Reader
+	n   int
+	pad []byte
+	eof bool
+}
+
+func (p *padReader) Read(b []byte) (int, error) {
+	if !p.eof {
+		n, err := p.r.Read(b)
+		for _, c := range b[:n] {
+			if c != '\r' && c != '\n' {
+				p.n++
+			}
+		}
+		if err != io.EOF {
+			return n, err
+		}
+		p.eof = true
+		if rem := p.n % 4; rem >= 2 {
+			p.pad = []byte("==")[:4-rem]
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+	if len(p.pad) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, p.pad)
+	p.pad = p.pad[n:]
+	return n, nil
+}
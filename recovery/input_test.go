@@ -0,0 +1,220 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/input_test.go
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"decrypt/n2s"
+)
+
+func TestDecryptLargeBlobFromStdin(t *testing.T) {
+	plaintext := make([]byte, 10<<20)
+	if _, err := rand.Read(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	blobid, ciphertext := sealClassic(t, plaintext, "pw")
+	pwFile := filepath.Join(t.TempDir(), "pw")
+	if err := os.WriteFile(pwFile, []byte("pw"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	stdin := strings.NewReader(base64.StdEncoding.EncodeToString(ciphertext) + "\n")
+	var out, errOut bytes.Buffer
+	code := run([]string{"-password-file", pwFile, "-in", "-", string(blobid)}, stdin, &out, &errOut)
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, errOut.String())
+	}
+	if !bytes.Equal(out.Bytes(), plaintext) {
+		t.Error("plaintext mismatch for 10 MB blob")
+	}
+}
+
+func TestDecryptMaxCiphertext(t *testing.T) {
+	blobid, ciphertext := sealClassic(t, make([]byte, 64), "pw")
+	stdin := strings.NewReader(base64.StdEncoding.EncodeToString(ciphertext))
+	var errOut bytes.Buffer
+	code := run([]string{"-max-ciphertext", "32", "-in", "-", string(blobid), "pw"}, stdin, &bytes.Buffer{}, &errOut)
+	if code != exitDecode || !strings.Contains(errOut.String(), "ciphertext exceeds 32 bytes") {
+		t.Errorf("exit %d: %s", code, errOut.String())
+	}
+}
+
+func TestReadCiphertextPaddedAndRaw(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 3, 4, 5, 100} {
+		data := bytes.Repeat([]byte{0xa5}, n)
+		for name, enc := range map[string]*base64.Encoding{"std": base64.StdEncoding, "raw": base64.RawStdEncoding} {
+			got, err := readCiphertext("-", strings.NewReader(enc.EncodeToString(data)))
+			if err != nil {
+				t.Fatalf("%s/%d: %v", name, n, err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("%s/%d: got %x", name, n, got)
+			}
+		}
+	}
+}
+
+func TestDecodeBase64Raw(t *testing.T) {
+	got, err := decodeBase64("aGk")
+	if err != nil || string(got) != "hi" {
+		t.Fatalf("decodeBase64(raw) = %q, %v", got, err)
+	}
+	if _, err := decodeBase64("!!!"); err == nil {
+		t.Fatal("decodeBase64 accepted garbage")
+	}
+}
+
+func TestDecryptStdinConflict(t *testing.T) {
+	var out, errOut bytes.Buffer
+	if code := run([]string{"-in", "-", "00", "-"}, strings.NewReader(""), &out, &errOut); code == 0 {
+		t.Fatal("expected failure when stdin carries both password and ciphertext")
+	}
+}
+
+func TestDecryptBase64URL(t *testing.T) {
+	// Enough bytes that the URL-safe encoding is bound to contain '-' or '_'.
+	plaintext := bytes.Repeat([]byte{0xfb, 0xff, 0xbf}, 64)
+	blobid, ciphertext := sealClassic(t, plaintext, "pw")
+	url := base64.RawURLEncoding.EncodeToString(ciphertext)
+	if !strings.ContainsAny(url, "-_") {
+		t.Fatalf("encoding %q has no URL-safe characters", url)
+	}
+
+	for _, args := range [][]string{
+		{string(blobid), "pw", url},
+		{"-b64", "url", string(blobid), "pw", url},
+	} {
+		var out, errOut bytes.Buffer
+		if code := run(args, nil, &out, &errOut); code != 0 {
+			t.Fatalf("%v: exit %d: %s", args[:len(args)-1], code, errOut.String())
+		}
+		if !bytes.Equal(out.Bytes(), plaintext) {
+			t.Errorf("%v: plaintext mismatch", args[:len(args)-1])
+		}
+	}
+
+	pwFile := filepath.Join(t.TempDir(), "pw")
+	os.WriteFile(pwFile, []byte("pw"), 0o600)
+	var out, errOut bytes.Buffer
+	code := run([]string{"-password-file", pwFile, "-in", "-", string(blobid)},
+		strings.NewReader(url+"\n"), &out, &errOut)
+	if code != 0 || !bytes.Equal(out.Bytes(), plaintext) {
+		t.Errorf("streamed base64url: exit %d: %s", code, errOut.String())
+	}
+
+	errOut.Reset()
+	if code := run([]string{"-b64", "std", string(blobid), "pw", url}, nil, &out, &errOut); code != exitDecode {
+		t.Errorf("-b64 std on base64url: exit %d, want %d", code, exitDecode)
+	}
+}
+
+func TestDecodeBase64NamesVariantsTried(t *testing.T) {
+	_, err := decodeBase64("ab+-")
+	if err == nil {
+		t.Fatal("decodeBase64 accepted mixed alphabets")
+	}
+	if !strings.Contains(err.Error(), "tried std, raw std, url, raw url") {
+		t.Errorf("error %q does not list the variants tried", err)
+	}
+	if _, err := readCiphertext("-", strings.NewReader("ab+-")); err == nil {
+		t.Error("readCiphertext accepted mixed alphabets")
+	}
+}
+
+func TestBadBase64Sentinel(t *testing.T) {
+	if _, err := decodeBase64("!!!"); !errors.Is(err, n2s.ErrBadBase64) {
+		t.Errorf("decodeBase64: %v, want n2s.ErrBadBase64", err)
+	}
+	for _, variant := range []string{"", "std", "url"} {
+		_, err := io.ReadAll(decodeCiphertext(io.NopCloser(strings.NewReader("ab+-cd==")), variant))
+		if !errors.Is(err, n2s.ErrBadBase64) || failureCode(err) != exitDecode {
+			t.Errorf("-b64 %q stream: %v, want n2s.ErrBadBase64", variant, err)
+		}
+	}
+	if _, err := blobFromParts("zz", "00"); !errors.Is(err, n2s.ErrBadHex) {
+		t.Errorf("-salt zz: %v, want n2s.ErrBadHex", err)
+	}
+}
+
+func TestDecryptRawCiphertext(t *testing.T) {
+	plaintext := bytes.Repeat([]byte("raw and base64 agree\n"), 4096)
+	blobid, ciphertext := sealClassic(t, plaintext, "pw")
+	dir := t.TempDir()
+	pwFile := filepath.Join(dir, "pw")
+	rawFile := filepath.Join(dir, "blob.bin")
+	os.WriteFile(pwFile, []byte("pw"), 0o600)
+	os.WriteFile(rawFile, ciphertext, 0o600)
+
+	var viaB64, viaRaw, viaStdin, errOut bytes.Buffer
+	b64 := strings.NewReader(base64.StdEncoding.EncodeToString(ciphertext))
+	if code := run([]string{"-password-file", pwFile, "-in", "-", string(blobid)}, b64, &viaB64, &errOut); code != 0 {
+		t.Fatalf("base64: exit %d: %s", code, errOut.String())
+	}
+	if code := run([]string{"-password-file", pwFile, "-raw", "-in", rawFile, string(blobid)}, nil, &viaRaw, &errOut); code != 0 {
+		t.Fatalf("raw file: exit %d: %s", code, errOut.String())
+	}
+	code := run([]string{"-password-file", pwFile, "-raw", "-in", "-", string(blobid)}, bytes.NewReader(ciphertext), &viaStdin, &errOut)
+	if code != 0 {
+		t.Fatalf("raw stdin: exit %d: %s", code, errOut.String())
+	}
+	if !bytes.Equal(viaB64.Bytes(), plaintext) || !bytes.Equal(viaRaw.Bytes(), viaB64.Bytes()) || !bytes.Equal(viaStdin.Bytes(), viaB64.Bytes()) {
+		t.Error("raw and base64 paths disagree")
+	}
+
+	for _, args := range [][]string{
+		{"-raw", "-b64", "std", "-in", rawFile, string(blobid), "pw"},
+		{"-raw", string(blobid), "pw", "AAAA"},
+	} {
+		errOut.Reset()
+		if code := run(args, nil, &viaRaw, &errOut); code != exitUsage {
+			t.Errorf("%v: exit %d, want %d", args, code, exitUsage)
+		}
+	}
+}
+
+func TestDecryptWrappedBase64(t *testing.T) {
+	plaintext := make([]byte, 300)
+	rand.Read(plaintext)
+	blobid, ciphertext := sealClassic(t, plaintext, "pw")
+	b64 := base64.StdEncoding.EncodeToString(ciphertext)
+	var sb strings.Builder
+	for len(b64) > 64 {
+		sb.WriteString(b64[:64] + "\r\n")
+		b64 = b64[64:]
+	}
+	sb.WriteString(b64 + "\n")
+	wrapped := sb.String()
+	indented := "  " + strings.ReplaceAll(strings.TrimSpace(wrapped), "\r\n", "\n\t ")
+
+	pwFile := filepath.Join(t.TempDir(), "pw")
+	os.WriteFile(pwFile, []byte("pw"), 0o600)
+	for name, text := range map[string]string{"wrapped": wrapped, "indented": indented} {
+		for _, variant := range []string{"", "std"} {
+			var out, errOut bytes.Buffer
+			if code := run([]string{"-b64", variant, string(blobid), "pw", text}, nil, &out, &errOut); code != 0 || !bytes.Equal(out.Bytes(), plaintext) {
+				t.Errorf("%s argv, -b64 %q: exit %d: %s", name, variant, code, errOut.String())
+			}
+			out.Reset()
+			errOut.Reset()
+			code := run([]string{"-b64", variant, "-password-file", pwFile, "-in", "-", string(blobid)}, strings.NewReader(text), &out, &errOut)
+			if code != 0 || !bytes.Equal(out.Bytes(), plaintext) {
+				t.Errorf("%s -in, -b64 %q: exit %d: %s", name, variant, code, errOut.String())
+			}
+		}
+	}
+}
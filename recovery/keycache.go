@@ -0,0 +1,152 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/keycache.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"slices"
+
+	"decrypt/n2s"
+)
+
+// The -key-cache file is itself an n2s blob file, sealed under the cache
+// passphrase, whose plaintext is a diskKeyCache. Anyone who opens it
+// holds the derived keys, and so every blob sharing their salts, without
+// running a KDF; the cache passphrase is the only thing guarding them.
+type diskKeyCache struct {
+	Version int       `json:"version"`
+	Keys    []diskKey `json:"keys"`
+}
+
+type diskKey struct {
+	Salt       string `json:"salt"`
+	KDF        byte   `json:"kdf"`
+	Iterations int    `json:"iterations,omitempty"`
+	Time       uint32 `json:"time,omitempty"`
+	Memory     uint32 `json:"memory,omitempty"`
+	Threads    uint8  `json:"threads,omitempty"`
+	Key        string `json:"key"`
+}
+
+// load fills c from the -key-cache file at path. A missing file
+// is an empty cache; one that does not open under cachePassword is an
+// error, never silently replaced.
+func (c *keyCache) load(path, cachePassword string) error {
+	blob, ciphertext, err := readBlobFile(path, nil)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading key cache: %w", err)
+	}
+	data, err := n2s.DecryptBlob(blob, ciphertext, nil, cachePassword)
+	if err != nil {
+		return fmt.Errorf("opening key cache %s: %w", path, err)
+	}
+	defer n2s.Wipe(data)
+	var dc diskKeyCache
+	if err := json.Unmarshal(data, &dc); err != nil {
+		return &decodeError{fmt.Errorf("key cache %s: %w", path, err)}
+	}
+	if dc.Version != 1 {
+		return &decodeError{fmt.Errorf("key cache %s: unsupported version %d", path, dc.Version)}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, d := range dc.Keys {
+		salt, err := hex.DecodeString(d.Salt)
+		if err != nil {
+			return &decodeError{fmt.Errorf("key cache %s: salt: %w", path, err)}
+		}
+		key, err := hex.DecodeString(d.Key)
+		if err != nil || len(key) != n2s.KeyLen {
+			return &decodeError{fmt.Errorf("key cache %s: malformed key for salt %s", path, d.Salt)}
+		}
+		k := cacheKey{salt: string(salt), kdf: n2s.KDFParams{
+			ID: n2s.KDFID(d.KDF), Iterations: d.Iterations, Time: d.Time, Memory: d.Memory, Threads: d.Threads,
+		}}
+		e := &cachedKey{key: key, fromDisk: true}
+		e.once.Do(func() {})
+		c.keys[k] = e
+	}
+	return nil
+}
+
+// save writes every good key to path, sealed under cachePassword, if
+// any was derived since the cache was loaded.
+func (c *keyCache) save(path, cachePassword string) error {
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	dc := diskKeyCache{Version: 1}
+	for _, k := range slices.SortedFunc(maps.Keys(c.keys), func(a, b cacheKey) int { return bytes.Compare([]byte(a.salt), []byte(b.salt)) }) {
+		e := c.keys[k]
+		key := e.key
+		if e.fresh != nil {
+			key = e.fresh
+		}
+		if key == nil {
+			continue
+		}
+		dc.Keys = append(dc.Keys, diskKey{
+			Salt: hex.EncodeToString([]byte(k.salt)), KDF: byte(k.kdf.ID), Iterations: k.kdf.Iterations,
+			Time: k.kdf.Time, Memory: k.kdf.Memory, Threads: k.kdf.Threads, Key: hex.EncodeToString(key),
+		})
+	}
+	c.mu.Unlock()
+
+	data, err := json.Marshal(dc)
+	if err != nil {
+		return err
+	}
+	defer n2s.Wipe(data)
+	blobid, ciphertext, err := n2s.Seal(data, cachePassword, n2s.EncryptOptions{})
+	if err != nil {
+		return err
+	}
+	if err := writeBlobFile(path, blobid, ciphertext); err != nil {
+		return fmt.Errorf("writing key cache: %w", err)
+	}
+	return nil
+}
+
+// recheck derives blob's key afresh, once, when a -key-cache key failed
+// to open it: the cache may have been written under another batch
+// password. It reports the fresh key if it differs; from then on the
+// cache hands that key out instead.
+func (c *keyCache) recheck(blob *n2s.Blob) ([]byte, bool) {
+	c.mu.Lock()
+	e := c.keys[cacheKey{salt: string(blob.Salt), kdf: blob.KDF}]
+	c.mu.Unlock()
+	if e == nil || !e.fromDisk {
+		return nil, false
+	}
+	e.recheck.Do(func() {
+		fresh, err := deriveKey(c.password, blob.Salt, blob.KDF, blob.Cipher.KeySize())
+		if err != nil || bytes.Equal(fresh, e.key) {
+			n2s.Wipe(fresh)
+			return
+		}
+		c.mu.Lock()
+		e.fresh, c.dirty = fresh, true
+		c.mu.Unlock()
+	})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return e.fresh, e.fresh != nil
+}
@@ -0,0 +1,76 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/keycache_test.go
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"decrypt/n2s"
+)
+
+// countDerivations swaps in a deriveKey that counts its calls.
+func countDerivations(t *testing.T) *atomic.Int32 {
+	var n atomic.Int32
+	saved := deriveKey
+	deriveKey = func(password string, salt []byte, params n2s.KDFParams, keyLen int) ([]byte, error) {
+		n.Add(1)
+		return saved(password, salt, params, keyLen)
+	}
+	t.Cleanup(func() { deriveKey = saved })
+	return &n
+}
+
+func TestBatchKeyCache(t *testing.T) {
+	derived := countDerivations(t)
+	dir := t.TempDir()
+	cacheFile := filepath.Join(dir, "keys.n2s")
+	cachePw := writePasswordFile(t, "cache passphrase")
+	manifest := writeManifest(t, sealSharedSalt(t, "pw", 3))
+
+	batch := func(password string, extra ...string) (int, string) {
+		var stdout, stderr bytes.Buffer
+		args := append([]string{"batch", "-key-cache", cacheFile, "-key-cache-password-file", cachePw, "-out", t.TempDir()}, extra...)
+		code := run(append(args, manifest, "-"), strings.NewReader(password), &stdout, &stderr)
+		return code, stderr.String()
+	}
+
+	if code, stderr := batch("pw"); code != 0 || derived.Load() != 1 {
+		t.Fatalf("first run: exit %d, %d derivations: %s", code, derived.Load(), stderr)
+	}
+	data, err := os.ReadFile(cacheFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(`"key"`)) {
+		t.Error("key cache is not encrypted")
+	}
+
+	derived.Store(0)
+	if code, stderr := batch("pw"); code != 0 || derived.Load() != 0 {
+		t.Errorf("second run: exit %d, %d derivations, want none: %s", code, derived.Load(), stderr)
+	}
+
+	// Blobs under the same salt but another password make the cached key
+	// stale: it is derived afresh once, not once per blob.
+	manifest = writeManifest(t, sealSharedSalt(t, "other", 3))
+	derived.Store(0)
+	if code, stderr := batch("other"); code != 0 || derived.Load() != 1 || !strings.Contains(stderr, "stale") {
+		t.Errorf("stale cache: exit %d, %d derivations: %s", code, derived.Load(), stderr)
+	}
+
+	cachePw = writePasswordFile(t, "wrong passphrase")
+	if code, _ := batch("other"); code != exitAuthFailed {
+		t.Errorf("wrong cache passphrase: exit %d, want %d", code, exitAuthFailed)
+	}
+}
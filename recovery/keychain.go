@@ -0,0 +1,74 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/keychain.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// PasswordSource supplies a password from somewhere other than argv, a
+// file or the terminal.
+type PasswordSource interface {
+	Password() (string, error)
+}
+
+// keychainSource reads a generic password from the OS keyring: the macOS
+// keychain through security(1), elsewhere the Secret Service (GNOME
+// Keyring, KWallet) through secret-tool(1). Shelling out keeps the binary
+// free of cgo and D-Bus dependencies.
+type keychainSource struct {
+	service, account string
+}
+
+func (k keychainSource) Password() (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", k.service, "-a", k.account, "-w")
+	case "windows":
+		return "", errors.New("keychain: not supported on Windows; use -password-file")
+	default:
+		cmd = exec.Command("secret-tool", "lookup", "service", k.service, "account", k.account)
+	}
+
+	out, err := cmd.Output()
+	var execErr *exec.Error
+	if errors.As(err, &execErr) {
+		return "", fmt.Errorf("keychain: %s is not available: %w", cmd.Args[0], execErr.Err)
+	}
+	// Both tools fail, or print nothing, when the entry does not exist.
+	pw := strings.TrimSuffix(string(out), "\n")
+	if err != nil || pw == "" {
+		return "", fmt.Errorf("keychain: no password stored for service %q account %q", k.service, k.account)
+	}
+	return pw, nil
+}
+
+// newKeychainSource is a variable so tests can stand in a fake keyring.
+var newKeychainSource = func(service, account string) PasswordSource {
+	return keychainSource{service: service, account: account}
+}
+
+// keychainPassword resolves -keychain SERVICE:ACCOUNT. It is an error to
+// also give the password another way; a missing entry is an error too,
+// never a silent fallback to the terminal prompt.
+func keychainPassword(spec string, rest []string, passwordFile string) (string, error) {
+	if len(rest) > 0 || passwordFile != "" {
+		return "", usageErrorf("-keychain replaces the password; drop the password argument or -password-file")
+	}
+	i := strings.LastIndex(spec, ":")
+	if i <= 0 || i == len(spec)-1 {
+		return "", usageErrorf("-keychain wants SERVICE:ACCOUNT, got %q", spec)
+	}
+	return newKeychainSource(spec[:i], spec[i+1:]).Password()
+}
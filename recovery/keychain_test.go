@@ -0,0 +1,73 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/keychain_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// fakeKeyring maps "service:account" to passwords.
+type fakeKeyring map[string]string
+
+type fakeSource struct {
+	ring             fakeKeyring
+	service, account string
+}
+
+func (f fakeSource) Password() (string, error) {
+	pw, ok := f.ring[f.service+":"+f.account]
+	if !ok {
+		return "", fmt.Errorf("keychain: no password stored for service %q account %q", f.service, f.account)
+	}
+	return pw, nil
+}
+
+func useFakeKeyring(t *testing.T, ring fakeKeyring) {
+	t.Helper()
+	orig := newKeychainSource
+	newKeychainSource = func(service, account string) PasswordSource {
+		return fakeSource{ring: ring, service: service, account: account}
+	}
+	t.Cleanup(func() { newKeychainSource = orig })
+}
+
+func TestDecryptKeychain(t *testing.T) {
+	useFakeKeyring(t, fakeKeyring{"n2s:recovery:ops": "from keyring"})
+	blobid, ciphertext := sealClassic(t, []byte("keyring secret"), "from keyring")
+	b64 := base64.StdEncoding.EncodeToString(ciphertext)
+
+	var out, errOut bytes.Buffer
+	// The service itself may contain a colon; the account is after the last.
+	if code := run([]string{"-keychain", "n2s:recovery:ops", string(blobid), b64}, nil, &out, &errOut); code != 0 {
+		t.Fatalf("exit %d: %s", code, errOut.String())
+	}
+	if out.String() != "keyring secret" {
+		t.Errorf("plaintext %q", out.String())
+	}
+
+	cases := []struct {
+		name, wantErr string
+		args          []string
+	}{
+		{"missing entry", "no password stored", []string{"-keychain", "n2s:nobody", string(blobid), b64}},
+		{"malformed spec", "SERVICE:ACCOUNT", []string{"-keychain", "n2s", string(blobid), b64}},
+		{"also argv", "-keychain replaces", []string{"-keychain", "n2s:recovery:ops", string(blobid), "pw", b64}},
+	}
+	for _, tc := range cases {
+		out.Reset()
+		errOut.Reset()
+		if code := run(tc.args, nil, &out, &errOut); code == 0 || !strings.Contains(errOut.String(), tc.wantErr) {
+			t.Errorf("%s: exit %d, stderr %q", tc.name, code, errOut.String())
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/ledger.go
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"decrypt/n2s"
+)
+
+// ledger is batch's record of finished entries: one canonical blobid
+// (manifestEntry.key) per line, appended and synced as each plaintext
+// lands, so a run killed midway resumes where it stopped. It is safe for
+// concurrent use.
+type ledger struct {
+	mu   sync.Mutex
+	f    *os.File
+	done map[string]bool
+}
+
+// openLedger reads the blobids already recorded in path, creating it if
+// need be, and opens it for appending.
+func openLedger(path string) (*ledger, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening ledger: %w", err)
+	}
+	l := &ledger{f: f, done: make(map[string]bool)}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// A crash mid-write leaves a partial last line; it matches no
+		// blobid, so that entry simply runs again.
+		// A line in another spelling, as a ledger written before blobids
+		// were canonicalized may hold, is read as its canonical form.
+		id := strings.TrimSpace(sc.Text())
+		if blob, err := n2s.ParseBlobID([]byte(id)); err == nil {
+			id = blob.ID()
+		}
+		if id != "" {
+			l.done[id] = true
+		}
+	}
+	if err := sc.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading ledger: %w", err)
+	}
+	return l, nil
+}
+
+// finished reports whether e is in the ledger and its output is still
+// there; an entry whose file was deleted or left empty runs again.
+func (l *ledger) finished(e manifestEntry, dir string) bool {
+	l.mu.Lock()
+	recorded := l.done[e.key()]
+	l.mu.Unlock()
+	if !recorded {
+		return false
+	}
+	fi, err := os.Stat(e.outPath(dir))
+	return err == nil && fi.Mode().IsRegular() && fi.Size() > 0
+}
+
+// record appends blobid and syncs it to disk before returning.
+func (l *ledger) record(blobid string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := fmt.Fprintln(l.f, blobid); err != nil {
+		return fmt.Errorf("writing ledger: %w", err)
+	}
+	if err := l.f.Sync(); err != nil {
+		return fmt.Errorf("syncing ledger: %w", err)
+	}
+	l.done[blobid] = true
+	return nil
+}
+
+func (l *ledger) Close() error {
+	return l.f.Close()
+}
@@ -0,0 +1,108 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/lines.go
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// parseLines reads batch -line records: one "blobid password ciphertext"
+// triple per line, the classic three-argument form as legacy logs keep
+// it. Each entry gets the key cache for its own password, after form
+// normalization, and entries sharing a password share one, so a common
+// salt is still derived once. A line that does not split into three
+// fields comes back as an entry that fails with the reason, like a
+// malformed manifest line; blank lines are skipped. The caller wipes the
+// returned caches.
+func parseLines(r io.Reader, form string) ([]manifestEntry, map[string]*keyCache, error) {
+	var entries []manifestEntry
+	caches := make(map[string]*keyCache)
+	sc := bufio.NewScanner(r)
+	// Ciphertext lines run to many megabytes.
+	sc.Buffer(nil, 1<<30)
+	for n := 1; sc.Scan(); n++ {
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		e := manifestEntry{line: n}
+		fields, err := splitLine(sc.Text())
+		switch {
+		case err != nil:
+			e.bad = err
+		case len(fields) != 3:
+			e.bad = fmt.Errorf("want 3 fields, blobid password ciphertext; got %d", len(fields))
+		default:
+			password := normalizePassword(fields[1], form)
+			if caches[password] == nil {
+				caches[password] = newKeyCache(password)
+			}
+			e.BlobID, e.Ciphertext, e.cache = fields[0], fields[2], caches[password]
+		}
+		entries = append(entries, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, caches, fmt.Errorf("reading -line input: %w", err)
+	}
+	return entries, caches, nil
+}
+
+// splitLine splits a -line record into fields separated by spaces or
+// tabs, so a password holding them must be quoted: '...' takes everything
+// up to the next single quote literally, and "..." the same except that
+// \" and \\ stand for " and \. Quoted and bare text with no space between
+// make one field, as in a shell. A backslash outside double quotes is an
+// ordinary character.
+func splitLine(line string) ([]string, error) {
+	var fields []string
+	var cur strings.Builder
+	inField := false
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; c {
+		case ' ', '\t', '\r':
+			if inField {
+				fields = append(fields, cur.String())
+				cur.Reset()
+				inField = false
+			}
+		case '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated ' quote")
+			}
+			cur.WriteString(line[i+1 : i+1+end])
+			i += end + 1
+			inField = true
+		case '"':
+			for i++; ; i++ {
+				if i == len(line) {
+					return nil, errors.New(`unterminated " quote`)
+				}
+				if line[i] == '"' {
+					break
+				}
+				if line[i] == '\\' && i+1 < len(line) && (line[i+1] == '"' || line[i+1] == '\\') {
+					i++
+				}
+				cur.WriteByte(line[i])
+			}
+			inField = true
+		default:
+			cur.WriteByte(c)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, cur.String())
+	}
+	return fields, nil
+}
@@ -0,0 +1,86 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/lines_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplitLine(t *testing.T) {
+	cases := []struct {
+		line string
+		want []string
+		err  bool
+	}{
+		{"a b c", []string{"a", "b", "c"}, false},
+		{"  a\tb   c \t \r", []string{"a", "b", "c"}, false},
+		{`a "two words" c`, []string{"a", "two words", "c"}, false},
+		{`a 'it''s' c`, []string{"a", "its", "c"}, false},
+		{`a "say \"hi\" \\ \n" c`, []string{"a", `say "hi" \ \n`, "c"}, false},
+		{`a 'back\slash' c`, []string{"a", `back\slash`, "c"}, false},
+		{`a pre"fix "c`, []string{"a", "prefix c"}, false},
+		{`a "" c`, []string{"a", "", "c"}, false},
+		{`a "open c`, nil, true},
+		{`a 'open c`, nil, true},
+	}
+	for _, c := range cases {
+		got, err := splitLine(c.line)
+		if (err != nil) != c.err || !slices.Equal(got, c.want) {
+			t.Errorf("splitLine(%q) = %q, %v; want %q, error %v", c.line, got, err, c.want, c.err)
+		}
+	}
+}
+
+func TestBatchLine(t *testing.T) {
+	id1, ct1 := sealClassic(t, []byte("plain pw"), "pw")
+	id2, ct2 := sealClassic(t, []byte("spaced pw"), "correct horse battery")
+	id3, ct3 := sealClassic(t, []byte("wrong pw"), "pw")
+	b64 := base64.StdEncoding.EncodeToString
+	input := strings.Join([]string{
+		string(id1) + " pw " + b64(ct1) + "   \t",
+		"",
+		string(id2) + "\t'correct horse battery'\t" + b64(ct2),
+		string(id3) + " nope " + b64(ct3),
+		string(id1) + ` "unterminated ` + b64(ct1),
+	}, "\n") + "\n"
+
+	dir := t.TempDir()
+	var out, errOut bytes.Buffer
+	code := run([]string{"batch", "-out", dir, "-line", "-"}, strings.NewReader(input), &out, &errOut)
+	if code != exitFailure {
+		t.Fatalf("exit %d, want %d: %s", code, exitFailure, errOut.String())
+	}
+	if !strings.Contains(out.String(), "2 succeeded, 2 failed (1 auth, 1 other)") {
+		t.Errorf("summary %q", out.String())
+	}
+	for id, want := range map[string]string{string(id1): "plain pw", string(id2): "spaced pw"} {
+		if got, err := os.ReadFile(filepath.Join(dir, id)); err != nil || string(got) != want {
+			t.Errorf("%s: %q, %v; want %q", id, got, err, want)
+		}
+	}
+	for _, want := range []string{"FAIL line 4: authentication failed", "FAIL line 5: unterminated \" quote"} {
+		if !strings.Contains(errOut.String(), want) {
+			t.Errorf("stderr lacks %q:\n%s", want, errOut.String())
+		}
+	}
+	if strings.Contains(errOut.String(), "nope") {
+		t.Errorf("stderr shows a password:\n%s", errOut.String())
+	}
+
+	pwFile := writePasswordFile(t, "pw")
+	if code := run([]string{"batch", "-out", dir, "-line", "-", "-password-file", pwFile}, strings.NewReader(input), &out, &errOut); code != exitUsage {
+		t.Errorf("-line with -password-file: exit %d, want %d", code, exitUsage)
+	}
+}
@@ -0,0 +1,162 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/lockout.go
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// outcome is how a try the limiter let through ended.
+type outcome int
+
+const (
+	// tryReleased is a try that proved nothing, such as a malformed
+	// request: it is neither a failure nor a success.
+	tryReleased outcome = iota
+	tryFailed
+	trySucceeded
+)
+
+// limiter decides whether the serve client key names may try another
+// guess at the shared secret or a password. serve uses backoffLimiter;
+// tests can substitute their own or drive its clock.
+type limiter interface {
+	// attempt reserves a try at key before any work is done, so
+	// concurrent guesses cannot all get past a check that has yet to hear
+	// of the others. It returns zero and a done func to report the try's
+	// outcome with, or how long to wait and a nil done. Only the first
+	// call to done counts; it returns the lockout the outcome starts, if
+	// any.
+	attempt(key string) (wait time.Duration, done func(outcome) time.Duration)
+}
+
+// maxLockout caps how long one key can be locked out for.
+const maxLockout = time.Hour
+
+// forgetAfter is how long a key's failures are remembered after the last
+// one: well past maxLockout, or a key at the cap would start afresh as
+// each lockout ended.
+const forgetAfter = 24 * time.Hour
+
+// pendingWait is the Retry-After for a try refused while others at its
+// key are still running: their outcome decides whether it may follow.
+const pendingWait = time.Second
+
+// backoffLimiter locks a key out once it has failed threshold times in a
+// row: for base the first time, doubling with each further failure up to
+// maxLockout. Below the threshold, as many tries may run at once as it
+// has failures left; past it, one at a time once each lockout ends. A
+// success clears the key's record, and a record with no try running and
+// no lockout is dropped forgetAfter its last failure. It is safe for
+// concurrent use.
+type backoffLimiter struct {
+	threshold int
+	base      time.Duration
+	now       func() time.Time
+
+	mu    sync.Mutex
+	keys  map[string]*keyRecord
+	swept time.Time
+}
+
+type keyRecord struct {
+	failures int
+	// pending counts the tries let through whose outcome is not yet in.
+	pending int
+	until   time.Time
+	last    time.Time
+}
+
+func newBackoffLimiter(threshold int, base time.Duration) *backoffLimiter {
+	return &backoffLimiter{threshold: threshold, base: base, now: time.Now, keys: make(map[string]*keyRecord)}
+}
+
+func (l *backoffLimiter) attempt(key string) (time.Duration, func(outcome) time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+	c := l.keys[key]
+	if c == nil || forgotten(c, now) {
+		c = &keyRecord{}
+		l.keys[key] = c
+	}
+	if wait := c.until.Sub(now); wait > 0 {
+		return wait, nil
+	}
+	if c.pending >= max(l.threshold-c.failures, 1) {
+		return pendingWait, nil
+	}
+	c.pending++
+	var once sync.Once
+	return 0, func(o outcome) (lockout time.Duration) {
+		once.Do(func() { lockout = l.settle(key, c, o) })
+		return lockout
+	}
+}
+
+func (l *backoffLimiter) settle(key string, c *keyRecord, o outcome) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c.pending--
+	switch o {
+	case trySucceeded:
+		c.failures, c.until = 0, time.Time{}
+		if c.pending == 0 {
+			delete(l.keys, key)
+		}
+	case tryFailed:
+		c.failures++
+		c.last = l.now()
+		if c.failures >= l.threshold {
+			d := l.base
+			for i := l.threshold; i < c.failures && d < maxLockout; i++ {
+				d *= 2
+			}
+			d = min(d, maxLockout)
+			c.until = c.last.Add(d)
+			return d
+		}
+	}
+	return 0
+}
+
+// sweep drops forgotten records, at most once a minute. l.mu is held.
+func (l *backoffLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	l.swept = now
+	for key, c := range l.keys {
+		if forgotten(c, now) {
+			delete(l.keys, key)
+		}
+	}
+}
+
+// forgotten reports whether c no longer holds anything against its key:
+// no try is running, its lockout has passed and its last failure is
+// forgetAfter old.
+func forgotten(c *keyRecord, now time.Time) bool {
+	return c.pending == 0 && !now.Before(c.until) && now.Sub(c.last) >= forgetAfter
+}
+
+// clientOf identifies who sent r for the limiter: its source address.
+// Every holder of the shared secret presents the same secret, so that
+// cannot tell clients apart.
+func clientOf(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
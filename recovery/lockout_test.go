@@ -0,0 +1,196 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/lockout_test.go
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"decrypt/n2s"
+)
+
+// fakeClock is a backoffLimiter clock that moves only when told.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// try makes one try at key and reports o, returning the wait if it was
+// refused or else the lockout it started.
+func try(l limiter, key string, o outcome) (wait, lockout time.Duration) {
+	wait, done := l.attempt(key)
+	if done == nil {
+		return wait, 0
+	}
+	return 0, done(o)
+}
+
+func TestBackoffLimiter(t *testing.T) {
+	clock := &fakeClock{time.Unix(1e9, 0)}
+	l := newBackoffLimiter(3, 10*time.Second)
+	l.now = clock.now
+
+	for i := 1; i <= 2; i++ {
+		if wait, lockout := try(l, "a", tryFailed); wait != 0 || lockout != 0 {
+			t.Fatalf("failure %d: wait %v, lockout %v; want none below the threshold", i, wait, lockout)
+		}
+	}
+	for _, want := range []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second} {
+		if _, lockout := try(l, "a", tryFailed); lockout != want {
+			t.Errorf("lockout %v, want %v", lockout, want)
+		}
+		if wait, _ := try(l, "a", trySucceeded); wait != want {
+			t.Errorf("wait %v, want %v", wait, want)
+		}
+		if wait, _ := try(l, "b", tryReleased); wait != 0 {
+			t.Errorf("another key waits %v", wait)
+		}
+		clock.advance(want)
+	}
+
+	// A try that proves nothing neither counts nor clears.
+	if wait, lockout := try(l, "a", tryReleased); wait != 0 || lockout != 0 {
+		t.Errorf("released try: wait %v, lockout %v", wait, lockout)
+	}
+	if _, lockout := try(l, "a", tryFailed); lockout != 80*time.Second {
+		t.Errorf("lockout %v after a released try, want 80s", lockout)
+	}
+	clock.advance(80 * time.Second)
+	try(l, "a", trySucceeded)
+	if wait, lockout := try(l, "a", tryFailed); wait != 0 || lockout != 0 {
+		t.Errorf("one failure after a success: wait %v, lockout %v; want the count reset", wait, lockout)
+	}
+
+	var lockout time.Duration
+	for i := 0; i < 100; i++ {
+		_, lockout = try(l, "c", tryFailed)
+		clock.advance(lockout)
+	}
+	if lockout != maxLockout {
+		t.Errorf("lockout %v after 100 failures, want the %v cap", lockout, maxLockout)
+	}
+}
+
+func TestBackoffLimiterReservesTries(t *testing.T) {
+	clock := &fakeClock{time.Unix(1e9, 0)}
+	l := newBackoffLimiter(3, 10*time.Second)
+	l.now = clock.now
+	// Concurrent guesses all start before any has failed: only as many
+	// as the threshold allows get through.
+	var dones []func(outcome) time.Duration
+	for i := 0; i < 3; i++ {
+		wait, done := l.attempt("a")
+		if done == nil {
+			t.Fatalf("try %d refused, waiting %v", i+1, wait)
+		}
+		dones = append(dones, done)
+	}
+	if wait, done := l.attempt("a"); done != nil || wait != pendingWait {
+		t.Errorf("fourth concurrent try: wait %v, done %v; want it refused", wait, done != nil)
+	}
+	for _, done := range dones {
+		done(tryFailed)
+	}
+	dones[0](trySucceeded)
+	if wait, _ := l.attempt("a"); wait != 10*time.Second {
+		t.Errorf("after 3 failures and a repeated done: wait %v, want 10s", wait)
+	}
+}
+
+func TestBackoffLimiterForgets(t *testing.T) {
+	clock := &fakeClock{time.Unix(1e9, 0)}
+	l := newBackoffLimiter(3, 10*time.Second)
+	l.now = clock.now
+	for i := 0; i < 2; i++ {
+		try(l, "a", tryFailed)
+	}
+	clock.advance(forgetAfter)
+	try(l, "b", tryReleased)
+	if _, ok := l.keys["a"]; ok {
+		t.Errorf("record for a kept %v after its last failure", forgetAfter)
+	}
+	if _, lockout := try(l, "a", tryFailed); lockout != 0 {
+		t.Errorf("old failures still count: lockout %v", lockout)
+	}
+}
+
+func TestServeLockout(t *testing.T) {
+	clock := &fakeClock{time.Unix(1e9, 0)}
+	limit := newBackoffLimiter(2, 30*time.Second)
+	limit.now = clock.now
+	srv := httptest.NewServer(newServer(testSecret, 4096, 2, limit, newLogger(io.Discard, levelWarn)).handler())
+	defer srv.Close()
+	target, targetCT := sealClassic(t, []byte("target"), "pw")
+	other, otherCT := sealClassic(t, []byte("other"), "mine")
+	request := func(blobid []byte, ciphertext []byte, password string) []byte {
+		b, _ := json.Marshal(serveRequest{string(blobid), password, base64.StdEncoding.EncodeToString(ciphertext)})
+		return b
+	}
+	retryAfter := func(secret string, body []byte) (int, string) {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/decrypt", strings.NewReader(string(body)))
+		req.Header.Set("Authorization", "Bearer "+secret)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode, resp.Header.Get("Retry-After")
+	}
+
+	// The digest form's unused bytes and the case of its hex digits are
+	// not part of the blob: every spelling of it is the same client's try.
+	respelled := []byte(strings.ToUpper(string(target)))
+	copy(respelled[2*n2s.SaltLen:], "0BADF00D")
+	for i, blobid := range [][]byte{target, respelled} {
+		if code, _ := postDecrypt(t, srv, testSecret, request(blobid, targetCT, "guess")); code != http.StatusUnprocessableEntity {
+			t.Fatalf("wrong password %d: %d", i+1, code)
+		}
+	}
+	if code, after := retryAfter(testSecret, request(target, targetCT, "pw")); code != http.StatusTooManyRequests || after != "30" {
+		t.Errorf("locked out: %d, Retry-After %q; want 429 and 30", code, after)
+	}
+	// The count is the client's, not the blob's.
+	if code, _ := postDecrypt(t, srv, testSecret, request(other, otherCT, "mine")); code != http.StatusTooManyRequests {
+		t.Errorf("other blob during the lockout: %d, want 429", code)
+	}
+
+	clock.advance(30 * time.Second)
+	if code, body := postDecrypt(t, srv, testSecret, request(target, targetCT, "pw")); code != http.StatusOK || string(body) != "target" {
+		t.Errorf("after the lockout: %d %q", code, body)
+	}
+	// A success on any blob clears the client's count.
+	if code, _ := postDecrypt(t, srv, testSecret, request(target, targetCT, "guess")); code != http.StatusUnprocessableEntity {
+		t.Errorf("one failure after a success: %d, want %d", code, http.StatusUnprocessableEntity)
+	}
+	if code, _ := postDecrypt(t, srv, testSecret, request(other, otherCT, "mine")); code != http.StatusOK {
+		t.Errorf("other blob: %d", code)
+	}
+	if code, _ := postDecrypt(t, srv, testSecret, request(respelled, targetCT, "guess")); code != http.StatusUnprocessableEntity {
+		t.Errorf("one failure after another blob's success: %d, want %d", code, http.StatusUnprocessableEntity)
+	}
+	if code, _ := postDecrypt(t, srv, testSecret, request(target, targetCT, "pw")); code != http.StatusOK {
+		t.Errorf("other blob's success reset nothing: %d", code)
+	}
+
+	// Wrong secrets lock the client out of everything.
+	for i := 0; i < 2; i++ {
+		if code, _ := postDecrypt(t, srv, "guess", request(other, otherCT, "mine")); code != http.StatusUnauthorized {
+			t.Fatalf("wrong secret %d: %d", i+1, code)
+		}
+	}
+	if code, after := retryAfter(testSecret, request(other, otherCT, "mine")); code != http.StatusTooManyRequests || after != "30" {
+		t.Errorf("after wrong secrets: %d, Retry-After %q; want 429 and 30", code, after)
+	}
+}
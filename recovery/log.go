@@ -0,0 +1,83 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/log.go
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sync"
+)
+
+type logLevel int
+
+const (
+	levelError logLevel = iota // -q
+	levelWarn                  // default
+	levelInfo                  // -v
+)
+
+// logger writes leveled messages to stderr. Commands build one from their
+// -v and -q flags and hand it down, so tests capture output by passing
+// their own writer. A nil *logger discards everything. It is safe for
+// concurrent use.
+type logger struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level logLevel
+}
+
+func newLogger(w io.Writer, level logLevel) *logger {
+	return &logger{w: w, level: level}
+}
+
+// logFlags registers -v and -q on fs; call level after parsing.
+type logFlags struct {
+	verbose, quiet *bool
+}
+
+func addLogFlags(fs *flag.FlagSet) logFlags {
+	return logFlags{
+		verbose: fs.Bool("v", false, "log per-blob key derivation and decryption times"),
+		quiet:   fs.Bool("q", false, "log errors only"),
+	}
+}
+
+func (f logFlags) level() (logLevel, error) {
+	switch {
+	case *f.verbose && *f.quiet:
+		return 0, usageErrorf("-v and -q are mutually exclusive")
+	case *f.verbose:
+		return levelInfo, nil
+	case *f.quiet:
+		return levelError, nil
+	}
+	return levelWarn, nil
+}
+
+func (l *logger) logf(level logLevel, format string, args ...any) {
+	if l == nil || level > l.level {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.w, format+"\n", args...)
+}
+
+func (l *logger) errorf(format string, args ...any) {
+	l.logf(levelError, "Error: "+format, args...)
+}
+
+func (l *logger) warnf(format string, args ...any) {
+	l.logf(levelWarn, "Warning: "+format, args...)
+}
+
+func (l *logger) infof(format string, args ...any) {
+	l.logf(levelInfo, format, args...)
+}
@@ -0,0 +1,79 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/log_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestLogLevels(t *testing.T) {
+	blobid, ciphertext := sealClassic(t, []byte("logged"), "pw")
+	b64 := base64.StdEncoding.EncodeToString(ciphertext)
+
+	cases := []struct {
+		name     string
+		args     []string
+		wantCode int
+		want     []string
+		notWant  []string
+	}{
+		{"verbose", []string{"-v", "-verify", string(blobid), "pw", b64}, 0,
+			[]string{"derived key", "opened 6 bytes", "Verified"}, nil},
+		{"default", []string{"-verify", string(blobid), "pw", b64}, 0,
+			[]string{"Verified"}, []string{"derived key", "opened"}},
+		{"quiet", []string{"-q", "-verify", string(blobid), "pw", b64}, 0,
+			nil, []string{"derived key", "opened", "Verified"}},
+		{"quiet failure", []string{"-q", string(blobid), "wrong", b64}, exitAuthFailed,
+			[]string{"Error: authentication failed"}, []string{"derived key", "Warning"}},
+		{"both", []string{"-q", "-v", string(blobid), "pw", b64}, exitUsage,
+			[]string{"mutually exclusive"}, nil},
+	}
+	for _, tc := range cases {
+		var out, errOut bytes.Buffer
+		if code := run(tc.args, nil, &out, &errOut); code != tc.wantCode {
+			t.Errorf("%s: exit %d, want %d (%s)", tc.name, code, tc.wantCode, errOut.String())
+		}
+		for _, s := range tc.want {
+			if !strings.Contains(errOut.String(), s) {
+				t.Errorf("%s: stderr %q lacks %q", tc.name, errOut.String(), s)
+			}
+		}
+		for _, s := range tc.notWant {
+			if strings.Contains(errOut.String(), s) {
+				t.Errorf("%s: stderr %q has %q", tc.name, errOut.String(), s)
+			}
+		}
+	}
+}
+
+func TestSubcommandLogFlags(t *testing.T) {
+	for _, cmd := range []string{"encrypt", "rekey", "info", "bench", "calibrate", "formats", "selftest"} {
+		var out, errOut bytes.Buffer
+		if code := run([]string{cmd, "-q", "-v"}, strings.NewReader(""), &out, &errOut); code != exitUsage || !strings.Contains(errOut.String(), "mutually exclusive") {
+			t.Errorf("%s -q -v: exit %d, stderr %q", cmd, code, errOut.String())
+		}
+	}
+	var out, errOut bytes.Buffer
+	if code := run([]string{"encrypt", "-q", "-kdf", "scrypt", "pw"}, strings.NewReader("x"), &out, &errOut); code != exitUsage || errOut.String() != "Error: unknown -kdf \"scrypt\"\n" {
+		t.Errorf("encrypt -q with a bad -kdf: exit %d, stderr %q", code, errOut.String())
+	}
+}
+
+func TestNilLoggerDiscards(t *testing.T) {
+	var l *logger
+	l.errorf("dropped")
+	var buf bytes.Buffer
+	newLogger(&buf, levelError).infof("dropped")
+	if buf.Len() != 0 {
+		t.Errorf("info logged at error level: %q", buf.String())
+	}
+}
@@ -0,0 +1,68 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/migrate_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"decrypt/n2s"
+)
+
+func TestMigrate(t *testing.T) {
+	blobid, ciphertext := sealClassic(t, []byte("legacy contents"), "pw")
+	b64 := base64.StdEncoding.EncodeToString(ciphertext)
+
+	var out, errOut bytes.Buffer
+	if code := run([]string{"migrate", string(blobid), "-", b64}, strings.NewReader("pw"), &out, &errOut); code != 0 {
+		t.Fatalf("legacy: exit %d: %s", code, errOut.String())
+	}
+	oldID, newID, _ := strings.Cut(strings.TrimSpace(out.String()), "\t")
+	if oldID != string(blobid) {
+		t.Errorf("mapping starts with %s, want %s", oldID, blobid)
+	}
+	blob, err := n2s.ParseBlobID([]byte(newID))
+	if err != nil {
+		t.Fatalf("migrated blobid: %v", err)
+	}
+	if blob.Version != 2 || blob.KDF != n2s.LegacyKDF {
+		t.Errorf("migrated blob version %d kdf %+v, want 2 %+v", blob.Version, blob.KDF, n2s.LegacyKDF)
+	}
+	got, err := n2s.Decrypt([]byte(newID), ciphertext, nil, "pw")
+	if err != nil || string(got) != "legacy contents" {
+		t.Errorf("migrated blob with unchanged ciphertext: %q, %v", got, err)
+	}
+	out.Reset()
+	if code := run([]string{"info", newID}, nil, &out, &errOut); code != 0 || !strings.Contains(out.String(), string(blobid)) {
+		t.Errorf("info on migrated blob: exit %d, no producer digest %s in:\n%s", code, blobid, out.String())
+	}
+
+	// Already headered: a no-op that needs no password.
+	out.Reset()
+	errOut.Reset()
+	if code := run([]string{"migrate", newID, b64}, nil, &out, &errOut); code != 0 {
+		t.Fatalf("headered: exit %d: %s", code, errOut.String())
+	}
+	if want := newID + "\t" + newID + "\n"; out.String() != want {
+		t.Errorf("headered mapping %q, want %q", out.String(), want)
+	}
+	if !strings.Contains(errOut.String(), "nothing to migrate") {
+		t.Errorf("headered stderr %q", errOut.String())
+	}
+
+	out.Reset()
+	if code := run([]string{"migrate", string(blobid), "-", b64}, strings.NewReader("wrong"), &out, &errOut); code != exitAuthFailed {
+		t.Errorf("wrong password: exit %d, want %d", code, exitAuthFailed)
+	}
+	if out.Len() != 0 {
+		t.Errorf("wrong password printed a mapping: %q", out.String())
+	}
+}
@@ -0,0 +1,555 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/n2s/blobid.go
+
+package n2s
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"slices"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	SaltLen   = 16
+	NonceLen  = chacha20poly1305.NonceSize
+	XNonceLen = chacha20poly1305.NonceSizeX
+
+	// n2s producer blobids are 32-byte BLAKE3 digests; the 4 bytes between
+	// salt and nonce are unused.
+	digestBlobIDLen = 32
+)
+
+// Blobid headers. Headerless (legacy) blobids are always 28, 32 or 40
+// bytes; a headered blobid always has odd length, so the two never
+// collide and the first byte of an odd-length blobid is the header.
+//
+// Version 1 is a single byte 001xxxxx whose low five bits are log2 of the
+// PBKDF2 iteration count.
+//
+// Version 2 is 0x40, a length byte L, then L bytes of fields. Each field
+// is tag(1) len(1) value(len), except tag 0x00 which is a lone padding
+// byte used to keep L odd. Fields:
+//
+//	0x01 KDF     id(1) then, for PBKDF2 (id 1): iterations uint32
+//	                        for Argon2id (id 2): time uint32, memory KiB
+//	                        uint32, threads uint8
+//	0x02 CHUNKED chunk size uint32; the ciphertext is a sequence of
+//	             independently sealed chunks (see stream.go)
+//	0x03 CIPHER  id(1): 1 ChaCha20-Poly1305 (the default when absent),
+//	             2 AES-256-GCM (12-byte nonce only)
+//	0x04 SHA256  the SHA-256 of the plaintext (32 bytes), checked by
+//	             CheckPlaintextHash
+//	0x05 WRAPPED nonce(24) then the blob's data key sealed under a
+//	             master key (48 bytes); see envelope.go
+//	0x06 CREATED creation time, Unix seconds uint64; not secret, but
+//	             prefixed to the AEAD's associated data so it cannot be
+//	             altered without failing authentication
+//	0x07 DIGEST  the 32-byte producer digest a migrated blobid started
+//	             as (see migrate.go); its first 16 and last 12 bytes
+//	             must be the salt and nonce
+//
+// All integers are big-endian. Exactly one of KDF and WRAPPED is
+// required: it says whether the key comes from a password or a master key.
+const (
+	headerV1      = 0x20
+	headerVerMask = 0xe0
+	headerArgMask = 0x1f
+
+	headerV2 = 0x40
+
+	fieldPad     = 0x00
+	fieldKDF     = 0x01
+	fieldChunked = 0x02
+	fieldCipher  = 0x03
+	fieldSHA256  = 0x04
+	fieldWrapped = 0x05
+	fieldCreated = 0x06
+	fieldDigest  = 0x07
+
+	minIterLog2 = 10
+	maxIterLog2 = 24
+)
+
+// Blob is a parsed blobid, whether it arrived as hex or inside a .n2s file.
+type Blob struct {
+	// Version is the header version, or 0 for a legacy blobid.
+	Version int
+	KDF     KDFParams
+	Cipher  CipherID
+	Salt    []byte
+	Nonce   []byte
+	// ChunkSize is the plaintext bytes per chunk of a chunked blob, or 0
+	// for a classic single-shot one.
+	ChunkSize int
+	// PlaintextHash is the SHA-256 of the plaintext recorded at
+	// encryption time, or nil.
+	PlaintextHash []byte
+	// WrappedKey is the sealed data key of an envelope blob, or nil for
+	// one whose key is derived from a password. KDF is zero when it is
+	// set.
+	WrappedKey []byte
+	// Created is when the blob was sealed, to the second, or the zero
+	// time if its header does not say.
+	Created time.Time
+	// Digest is the producer digest blobid a migrated blob started as,
+	// or nil. Its salt and nonce are the blob's own.
+	Digest []byte
+
+	raw []byte
+}
+
+// additionalData is what the AEAD authenticates for a blob sealed or
+// opened with the caller's additionalData: that, behind the 8-byte
+// CREATED value when the header has one.
+func (b *Blob) additionalData(additionalData []byte) []byte {
+	if b.Created.IsZero() {
+		return additionalData
+	}
+	return append(binary.BigEndian.AppendUint64(nil, uint64(b.Created.Unix())), additionalData...)
+}
+
+// ID returns the blobid as hex.
+func (b *Blob) ID() string {
+	return hex.EncodeToString(b.raw)
+}
+
+// Bytes returns the raw blobid: header, salt and nonce. The caller must
+// not modify it.
+func (b *Blob) Bytes() []byte {
+	return b.raw
+}
+
+func (b *Blob) CipherName() string {
+	if b.Cipher == CipherChaCha20Poly1305 && len(b.Nonce) == XNonceLen {
+		return "xchacha20-poly1305"
+	}
+	return b.Cipher.String()
+}
+
+// MaxBlobIDLen caps the hex blobid ParseBlobID will decode, so a corrupt
+// or hostile one of many megabytes is refused before anything is
+// allocated for it. The largest valid blobid, a full version 2 header with
+// an XChaCha20 nonce, is under 600 characters.
+var MaxBlobIDLen = 4096
+
+// ParseBlobID hex-decodes a blobid into its header, salt (first 16 bytes
+// after any header) and trailing nonce. The nonce size follows from the
+// remaining length: 28 or 32 bytes carry a 12-byte ChaCha20 nonce, 40
+// bytes a 24-byte XChaCha20 nonce. Legacy blobids without a header use
+// PBKDF2 with the original 100000 iterations.
+//
+// Spaces, tabs and line breaks anywhere in blobid are ignored and hex
+// digits may be either case, so a blobid pasted from an email across two
+// lines still parses.
+func ParseBlobID(blobid []byte) (*Blob, error) {
+	return ParseBlobIDLayout(blobid, nil)
+}
+
+// ParseBlobIDStrict is ParseBlobID refusing a legacy blobid, whose KDF
+// and cipher are implied rather than recorded in a header, for archives
+// that must hold only headered blobs. Migrate gives a legacy blob a
+// header without re-encrypting it.
+func ParseBlobIDStrict(blobid []byte) (*Blob, error) {
+	b, err := ParseBlobID(blobid)
+	if err != nil {
+		return nil, err
+	}
+	if err := RequireHeader(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// RequireHeader is the check ParseBlobIDStrict adds, for a blob parsed
+// some other way: a *FormatError wrapping ErrLegacyRejected unless b has
+// a header.
+func RequireHeader(b *Blob) error {
+	if b.Version == 0 {
+		return &FormatError{ErrLegacyRejected}
+	}
+	return nil
+}
+
+// decodeBlobIDHex is the hex half of ParseBlobID, shared by every layout.
+func decodeBlobIDHex(blobid []byte) ([]byte, error) {
+	digits, err := countHexDigits(blobid)
+	if err != nil {
+		return nil, &FormatError{err}
+	}
+	if digits > MaxBlobIDLen {
+		return nil, &FormatError{fmt.Errorf("%w: %d hex characters, more than the %d allowed", ErrUnsupportedFormat, digits, MaxBlobIDLen)}
+	}
+	clean := make([]byte, 0, digits)
+	for _, c := range blobid {
+		if !isBlobIDSpace(c) {
+			clean = append(clean, c)
+		}
+	}
+	blobBytes := make([]byte, hex.DecodedLen(len(clean)))
+	if _, err := hex.Decode(blobBytes, clean); err != nil {
+		return nil, &FormatError{fmt.Errorf("decoding blobid: %w: %w", ErrBadHex, err)}
+	}
+	return blobBytes, nil
+}
+
+func isBlobIDSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// countHexDigits checks that blobid holds only hex digits and whitespace
+// and counts the digits, without allocating, so an oversized blobid costs
+// nothing before it is refused. An invalid character is reported with the
+// text around it.
+func countHexDigits(blobid []byte) (int, error) {
+	const context = 8
+	n := 0
+	for i, c := range blobid {
+		switch {
+		case isBlobIDSpace(c):
+			continue
+		case '0' <= c && c <= '9', 'a' <= c && c <= 'f', 'A' <= c && c <= 'F':
+			n++
+			continue
+		}
+		from, to := max(i-context, 0), min(i+context+1, len(blobid))
+		return 0, fmt.Errorf("decoding blobid: %w: invalid character %q at offset %d, in %q", ErrBadHex, c, i, blobid[from:to])
+	}
+	return n, nil
+}
+
+// ParseBlob is ParseBlobID for blobid bytes that are already decoded, as
+// in a .n2s file; every input form ends up here.
+func ParseBlob(blobBytes []byte) (*Blob, error) {
+	b, err := decodeBlob(blobBytes)
+	if err != nil {
+		return nil, &FormatError{err}
+	}
+	return b, nil
+}
+
+// BlobFromParts builds the headerless blob whose blobid would be salt
+// followed by nonce, for callers that store the two apart. The salt must
+// be SaltLen bytes and the nonce NonceLen (ChaCha20-Poly1305) or XNonceLen
+// (XChaCha20-Poly1305); the key comes from the legacy KDF, as for any
+// headerless blobid.
+func BlobFromParts(salt, nonce []byte) (*Blob, error) {
+	if len(salt) != SaltLen {
+		return nil, &FormatError{fmt.Errorf("%w: salt must be %d bytes, got %d", ErrUnsupportedFormat, SaltLen, len(salt))}
+	}
+	if len(nonce) != NonceLen && len(nonce) != XNonceLen {
+		return nil, &FormatError{fmt.Errorf("%w: nonce must be %d or %d bytes, got %d", ErrUnsupportedFormat, NonceLen, XNonceLen, len(nonce))}
+	}
+	raw := append(append(make([]byte, 0, len(salt)+len(nonce)), salt...), nonce...)
+	return &Blob{KDF: LegacyKDF, Cipher: CipherChaCha20Poly1305, Salt: raw[:SaltLen], Nonce: raw[SaltLen:], raw: raw}, nil
+}
+
+// FormatError reports a blobid or header that does not parse, as opposed
+// to a well-formed blob that fails to open. Err wraps one of the sentinels
+// below naming what is wrong, for errors.Is.
+type FormatError struct{ Err error }
+
+// What a *FormatError can wrap.
+var (
+	ErrBadHex            = errors.New("invalid hex")
+	ErrBlobTooShort      = errors.New("blobid too short")
+	ErrMalformedHeader   = errors.New("malformed blobid header")
+	ErrUnsupportedFormat = errors.New("unsupported blobid format")
+
+	// ErrUnsupportedHeader is the ErrUnsupportedFormat of a header naming
+	// a version, KDF, cipher or field this build does not know, as from a
+	// newer producer; errors.Is matches either.
+	ErrUnsupportedHeader = fmt.Errorf("%w", ErrUnsupportedFormat)
+
+	// ErrLegacyRejected is a well-formed blobid refused by
+	// ParseBlobIDStrict for having no header.
+	ErrLegacyRejected = errors.New("legacy headerless blob rejected in strict mode")
+)
+
+// ErrBadBase64 is for callers that take the ciphertext as base64, as the
+// decrypt command does; the package itself only ever sees raw bytes.
+var ErrBadBase64 = errors.New("invalid base64")
+
+func (e *FormatError) Error() string { return e.Err.Error() }
+func (e *FormatError) Unwrap() error { return e.Err }
+
+func decodeBlob(blobBytes []byte) (*Blob, error) {
+	// Check lengths before slicing: a short blobid would panic or, worse,
+	// hand back overlapping salt and nonce that only fail later as a bad
+	// password. A truncated legacy blobid can have odd length, so this
+	// comes before header parsing.
+	minLen := SaltLen + NonceLen
+	if len(blobBytes) < minLen {
+		return nil, fmt.Errorf("%w: need >=%d bytes (%d-byte salt + %d-byte nonce), got %d", ErrBlobTooShort, minLen, SaltLen, NonceLen, len(blobBytes))
+	}
+
+	b := &Blob{KDF: LegacyKDF, Cipher: CipherChaCha20Poly1305, raw: blobBytes}
+	var headerLen int
+	if len(blobBytes)%2 == 1 {
+		n, err := b.parseHeader(blobBytes)
+		if err != nil {
+			return nil, err
+		}
+		headerLen = n
+	}
+	body := blobBytes[headerLen:]
+
+	if len(body) < minLen {
+		return nil, fmt.Errorf("%w: need >=%d bytes (%d-byte header + %d-byte salt + %d-byte nonce), got %d",
+			ErrBlobTooShort, headerLen+minLen, headerLen, SaltLen, NonceLen, len(blobBytes))
+	}
+
+	var nonceSize int
+	switch {
+	case len(body) == SaltLen+NonceLen,
+		b.Version == 0 && len(body) == digestBlobIDLen:
+		nonceSize = NonceLen
+	case len(body) == SaltLen+XNonceLen:
+		nonceSize = XNonceLen
+	default:
+		return nil, fmt.Errorf("%w: length %d bytes, want %d or %d (ChaCha20-Poly1305) or %d (XChaCha20-Poly1305), plus an optional header",
+			ErrUnsupportedFormat, len(body), SaltLen+NonceLen, digestBlobIDLen, SaltLen+XNonceLen)
+	}
+	if b.Cipher == CipherAES256GCM && nonceSize != NonceLen {
+		return nil, fmt.Errorf("%w: %s needs a %d-byte nonce, blobid has %d", ErrUnsupportedFormat, b.Cipher, NonceLen, nonceSize)
+	}
+	if SaltLen+nonceSize > len(body) {
+		return nil, fmt.Errorf("%w: salt and nonce overlap: %d+%d bytes in %d", ErrBlobTooShort, SaltLen, nonceSize, len(body))
+	}
+	b.Salt = body[:SaltLen]
+	b.Nonce = body[len(body)-nonceSize:]
+	if b.Digest != nil && (!bytes.Equal(b.Digest[:SaltLen], b.Salt) || !bytes.Equal(b.Digest[len(b.Digest)-NonceLen:], b.Nonce)) {
+		return nil, fmt.Errorf("%w: digest field does not hold the blobid's salt and nonce", ErrMalformedHeader)
+	}
+	return b, nil
+}
+
+// parseHeader fills in b from the header at the front of data and returns
+// the header length.
+func (b *Blob) parseHeader(data []byte) (int, error) {
+	switch h := data[0]; {
+	case h&headerVerMask == headerV1:
+		n := int(h & headerArgMask)
+		if n < minIterLog2 || n > maxIterLog2 {
+			return 0, fmt.Errorf("%w: header iteration exponent %d out of range [%d, %d]", ErrUnsupportedHeader, n, minIterLog2, maxIterLog2)
+		}
+		b.Version = 1
+		b.KDF = KDFParams{ID: KDFPBKDF2, Iterations: 1 << n}
+		return 1, nil
+	case h == headerV2:
+		if len(data) < 2 || len(data) < 2+int(data[1]) {
+			return 0, fmt.Errorf("%w: truncated", ErrMalformedHeader)
+		}
+		b.Version = 2
+		n := 2 + int(data[1])
+		return n, b.parseFields(data[2:n])
+	default:
+		return 0, fmt.Errorf("%w: header version 0x%02x", ErrUnsupportedHeader, h)
+	}
+}
+
+func (b *Blob) parseFields(fields []byte) error {
+	var haveKDF bool
+	for len(fields) > 0 {
+		tag := fields[0]
+		if tag == fieldPad {
+			fields = fields[1:]
+			continue
+		}
+		if len(fields) < 2 || len(fields) < 2+int(fields[1]) {
+			return fmt.Errorf("%w: field 0x%02x truncated", ErrMalformedHeader, tag)
+		}
+		value := fields[2 : 2+int(fields[1])]
+		fields = fields[2+len(value):]
+
+		switch tag {
+		case fieldKDF:
+			kdf, err := parseKDFField(value)
+			if err != nil {
+				return err
+			}
+			b.KDF = kdf
+			haveKDF = true
+		case fieldChunked:
+			if len(value) != 4 {
+				return fmt.Errorf("%w: chunk size field has %d bytes, want 4", ErrMalformedHeader, len(value))
+			}
+			n := binary.BigEndian.Uint32(value)
+			if n < 1 || n > MaxChunkSize {
+				return fmt.Errorf("%w: chunk size %d out of range [1, %d]", ErrMalformedHeader, n, MaxChunkSize)
+			}
+			b.ChunkSize = int(n)
+		case fieldCipher:
+			if len(value) != 1 {
+				return fmt.Errorf("%w: cipher field has %d bytes, want 1", ErrMalformedHeader, len(value))
+			}
+			id := CipherID(value[0])
+			if !slices.Contains(Ciphers(), id) {
+				return fmt.Errorf("%w: cipher id %d", ErrUnsupportedHeader, value[0])
+			}
+			b.Cipher = id
+		case fieldSHA256:
+			if len(value) != sha256.Size {
+				return fmt.Errorf("%w: SHA-256 field has %d bytes, want %d", ErrMalformedHeader, len(value), sha256.Size)
+			}
+			b.PlaintextHash = value
+		case fieldWrapped:
+			if len(value) != wrappedKeyLen {
+				return fmt.Errorf("%w: wrapped key field has %d bytes, want %d", ErrMalformedHeader, len(value), wrappedKeyLen)
+			}
+			b.WrappedKey = value
+		case fieldCreated:
+			if len(value) != 8 {
+				return fmt.Errorf("%w: created field has %d bytes, want 8", ErrMalformedHeader, len(value))
+			}
+			secs := binary.BigEndian.Uint64(value)
+			if secs == 0 || secs > math.MaxInt64 {
+				return fmt.Errorf("%w: created time %d out of range", ErrMalformedHeader, secs)
+			}
+			b.Created = time.Unix(int64(secs), 0).UTC()
+		case fieldDigest:
+			if len(value) != digestBlobIDLen {
+				return fmt.Errorf("%w: digest field has %d bytes, want %d", ErrMalformedHeader, len(value), digestBlobIDLen)
+			}
+			b.Digest = value
+		default:
+			return fmt.Errorf("%w: header field 0x%02x", ErrUnsupportedHeader, tag)
+		}
+	}
+	switch {
+	case haveKDF && b.WrappedKey != nil:
+		return fmt.Errorf("%w: both a KDF and a wrapped key", ErrMalformedHeader)
+	case b.WrappedKey != nil:
+		b.KDF = KDFParams{}
+	case !haveKDF:
+		return fmt.Errorf("%w: no KDF field", ErrMalformedHeader)
+	}
+	return nil
+}
+
+func parseKDFField(v []byte) (KDFParams, error) {
+	if len(v) == 0 {
+		return KDFParams{}, fmt.Errorf("%w: KDF field empty", ErrMalformedHeader)
+	}
+	switch id := KDFID(v[0]); {
+	case id == KDFPBKDF2 && len(v) == 5:
+		return KDFParams{ID: id, Iterations: int(binary.BigEndian.Uint32(v[1:]))}, nil
+	case id == KDFArgon2id && len(v) == 10:
+		return KDFParams{
+			ID:      id,
+			Time:    binary.BigEndian.Uint32(v[1:5]),
+			Memory:  binary.BigEndian.Uint32(v[5:9]),
+			Threads: v[9],
+		}, nil
+	default:
+		return KDFParams{}, fmt.Errorf("%w: KDF id %d with %d parameter bytes", ErrUnsupportedHeader, v[0], len(v)-1)
+	}
+}
+
+// encodeHeader returns the shortest header that records b's KDF or
+// wrapped key, cipher, chunk size, plaintext hash, creation time and
+// producer digest: none for the legacy PBKDF2 count,
+// version 1 for other powers of two, otherwise version 2. Salt and nonce
+// are not part of the header.
+func encodeHeader(b *Blob) ([]byte, error) {
+	params := b.KDF
+	defaultCipher := b.Cipher == 0 || b.Cipher == CipherChaCha20Poly1305
+	if params.ID == KDFPBKDF2 && b.ChunkSize == 0 && defaultCipher && b.PlaintextHash == nil && b.WrappedKey == nil && b.Created.IsZero() && b.Digest == nil {
+		if params.Iterations == LegacyIterations {
+			return nil, nil
+		}
+		log2 := bits.Len(uint(params.Iterations)) - 1
+		if params.Iterations > 0 && params.Iterations == 1<<log2 && log2 >= minIterLog2 && log2 <= maxIterLog2 {
+			return []byte{headerV1 | byte(log2)}, nil
+		}
+	}
+	return encodeHeaderV2(b)
+}
+
+// encodeHeaderV2 returns a version 2 header for b even where a shorter
+// form exists, which Migrate uses to make the legacy defaults explicit.
+func encodeHeaderV2(b *Blob) ([]byte, error) {
+	params := b.KDF
+	defaultCipher := b.Cipher == 0 || b.Cipher == CipherChaCha20Poly1305
+	var kdf []byte
+	switch params.ID {
+	case 0:
+		if b.WrappedKey == nil {
+			return nil, fmt.Errorf("blob has neither a KDF nor a wrapped key")
+		}
+	case KDFPBKDF2:
+		if params.Iterations < 1 || uint64(params.Iterations) > 1<<32-1 {
+			return nil, fmt.Errorf("pbkdf2: iteration count %d out of range", params.Iterations)
+		}
+		kdf = binary.BigEndian.AppendUint32([]byte{byte(KDFPBKDF2)}, uint32(params.Iterations))
+	case KDFArgon2id:
+		kdf = binary.BigEndian.AppendUint32([]byte{byte(KDFArgon2id)}, params.Time)
+		kdf = binary.BigEndian.AppendUint32(kdf, params.Memory)
+		kdf = append(kdf, params.Threads)
+	default:
+		return nil, fmt.Errorf("unsupported KDF id %d", byte(params.ID))
+	}
+
+	var fields []byte
+	switch {
+	case kdf != nil && b.WrappedKey != nil:
+		return nil, fmt.Errorf("blob has both a KDF and a wrapped key")
+	case kdf != nil:
+		fields = append(fields, fieldKDF, byte(len(kdf)))
+		fields = append(fields, kdf...)
+	default:
+		if len(b.WrappedKey) != wrappedKeyLen {
+			return nil, fmt.Errorf("wrapped key has %d bytes, want %d", len(b.WrappedKey), wrappedKeyLen)
+		}
+		fields = append(fields, fieldWrapped, wrappedKeyLen)
+		fields = append(fields, b.WrappedKey...)
+	}
+	if b.ChunkSize != 0 {
+		if b.ChunkSize < 1 || b.ChunkSize > MaxChunkSize {
+			return nil, fmt.Errorf("chunk size %d out of range [1, %d]", b.ChunkSize, MaxChunkSize)
+		}
+		fields = append(fields, fieldChunked, 4)
+		fields = binary.BigEndian.AppendUint32(fields, uint32(b.ChunkSize))
+	}
+	if !defaultCipher {
+		fields = append(fields, fieldCipher, 1, byte(b.Cipher))
+	}
+	if b.PlaintextHash != nil {
+		if len(b.PlaintextHash) != sha256.Size {
+			return nil, fmt.Errorf("plaintext hash has %d bytes, want %d", len(b.PlaintextHash), sha256.Size)
+		}
+		fields = append(fields, fieldSHA256, sha256.Size)
+		fields = append(fields, b.PlaintextHash...)
+	}
+	if !b.Created.IsZero() {
+		if b.Created.Unix() < 1 {
+			return nil, fmt.Errorf("creation time %v is before 1970", b.Created)
+		}
+		fields = append(fields, fieldCreated, 8)
+		fields = binary.BigEndian.AppendUint64(fields, uint64(b.Created.Unix()))
+	}
+	if b.Digest != nil {
+		if len(b.Digest) != digestBlobIDLen {
+			return nil, fmt.Errorf("digest has %d bytes, want %d", len(b.Digest), digestBlobIDLen)
+		}
+		fields = append(fields, fieldDigest, digestBlobIDLen)
+		fields = append(fields, b.Digest...)
+	}
+	if len(fields)%2 == 0 {
+		fields = append(fields, fieldPad)
+	}
+	return append([]byte{headerV2, byte(len(fields))}, fields...), nil
+}
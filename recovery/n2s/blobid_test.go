@@ -0,0 +1,412 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/n2s/blobid_test.go
+
+package n2s
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func TestParseBlobID(t *testing.T) {
+	blobid := []byte("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	blob, err := ParseBlobID(blobid)
+	if err != nil {
+		t.Fatalf("ParseBlobID: %v", err)
+	}
+	if got := hex.EncodeToString(blob.Salt); got != "000102030405060708090a0b0c0d0e0f" {
+		t.Errorf("salt = %s", got)
+	}
+	if got := hex.EncodeToString(blob.Nonce); got != "1415161718191a1b1c1d1e1f" {
+		t.Errorf("nonce = %s", got)
+	}
+	if blob.KDF != LegacyKDF || blob.Version != 0 {
+		t.Errorf("legacy blobid: kdf %+v version %d", blob.KDF, blob.Version)
+	}
+}
+
+func TestParseBlobIDBadHex(t *testing.T) {
+	if _, err := ParseBlobID([]byte("not-hex")); err == nil {
+		t.Fatal("ParseBlobID accepted invalid hex")
+	}
+}
+
+func TestParseBlobIDPasted(t *testing.T) {
+	const id = "000102030405060708090a0b0c0d0e0f" + "101112131415161718191a1b"
+	cases := map[string]string{
+		"spaced":    "00010203 04050607 08090a0b 0c0d0e0f 10111213 14151617 18191a1b",
+		"newlined":  "  000102030405060708090a0b0c0d\r\n0e0f101112131415161718191a1b\n",
+		"uppercase": strings.ToUpper(id),
+		"tabbed":    "\t" + id[:20] + "\t" + id[20:],
+	}
+	for name, pasted := range cases {
+		blob, err := ParseBlobID([]byte(pasted))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if blob.ID() != id {
+			t.Errorf("%s: ID %s, want %s", name, blob.ID(), id)
+		}
+	}
+}
+
+func TestParseBlobIDInvalidCharacter(t *testing.T) {
+	_, err := ParseBlobID([]byte("000102030405060708090a0b0c0d0e0f10111213-14151617 18191a1b"))
+	var format *FormatError
+	if !errors.As(err, &format) {
+		t.Fatalf("got %v, want a FormatError", err)
+	}
+	for _, want := range []string{"'-'", "offset 40", `"10111213-14151617"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestParseBlobIDNonceSizes(t *testing.T) {
+	cases := []struct {
+		length   int
+		NonceLen int
+	}{
+		{28, 12},
+		{32, 12},
+		{40, 24},
+	}
+	for _, tc := range cases {
+		blobid := []byte(strings.Repeat("ab", tc.length))
+		blob, err := ParseBlobID(blobid)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", tc.length, err)
+		}
+		if len(blob.Nonce) != tc.NonceLen {
+			t.Errorf("%d bytes: nonce length %d, want %d", tc.length, len(blob.Nonce), tc.NonceLen)
+		}
+	}
+}
+
+func TestParseBlobIDUnsupportedLength(t *testing.T) {
+	_, err := ParseBlobID([]byte(strings.Repeat("ab", 36)))
+	if err == nil {
+		t.Fatal("ParseBlobID accepted a 36-byte blobid")
+	}
+	for _, want := range []string{"28", "32", "40"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not name expected size %s", err, want)
+		}
+	}
+}
+
+func TestDecryptXChaCha(t *testing.T) {
+	raw := make([]byte, SaltLen+XNonceLen)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatal(err)
+	}
+	key := pbkdf2.Key([]byte("pw"), raw[:SaltLen], LegacyIterations, KeyLen, sha256.New)
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := aead.Seal(nil, raw[SaltLen:], []byte("extended nonce"), nil)
+
+	got, err := Decrypt([]byte(hex.EncodeToString(raw)), ciphertext, nil, "pw")
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if !bytes.Equal(got, []byte("extended nonce")) {
+		t.Errorf("plaintext = %q", got)
+	}
+}
+
+func TestParseBlobIDHeader(t *testing.T) {
+	blobid := "2c" + strings.Repeat("ab", SaltLen+NonceLen)
+	blob, err := ParseBlobID([]byte(blobid))
+	if err != nil {
+		t.Fatalf("ParseBlobID: %v", err)
+	}
+	if blob.Version != 1 || blob.KDF.Iterations != 1<<12 {
+		t.Errorf("version %d iterations %d, want 1 and %d", blob.Version, blob.KDF.Iterations, 1<<12)
+	}
+	if len(blob.Salt) != SaltLen || len(blob.Nonce) != NonceLen {
+		t.Errorf("salt %d bytes, nonce %d bytes", len(blob.Salt), len(blob.Nonce))
+	}
+
+	for _, bad := range []string{"4c", "29", "3f"} {
+		if _, err := ParseBlobID([]byte(bad + strings.Repeat("ab", SaltLen+NonceLen))); err == nil {
+			t.Errorf("header 0x%s accepted", bad)
+		}
+	}
+}
+
+func TestParseBlobIDStrict(t *testing.T) {
+	body := strings.Repeat("ab", SaltLen+NonceLen)
+	if _, err := ParseBlobIDStrict([]byte("2c" + body)); err != nil {
+		t.Errorf("headered blobid: %v", err)
+	}
+	_, err := ParseBlobIDStrict([]byte(body))
+	var fe *FormatError
+	if !errors.Is(err, ErrLegacyRejected) || !errors.As(err, &fe) || err.Error() != "legacy headerless blob rejected in strict mode" {
+		t.Errorf("legacy blobid: %v, want a FormatError wrapping ErrLegacyRejected", err)
+	}
+	if _, err := ParseBlobID([]byte(body)); err != nil {
+		t.Errorf("lenient ParseBlobID refused a legacy blobid: %v", err)
+	}
+	if _, err := ParseBlobIDStrict([]byte("zz")); errors.Is(err, ErrLegacyRejected) {
+		t.Errorf("bad hex reported as %v", err)
+	}
+}
+
+func TestParseBlobIDHeaderV2(t *testing.T) {
+	cases := []KDFParams{
+		{ID: KDFPBKDF2, Iterations: 150000},
+		{ID: KDFArgon2id, Time: 2, Memory: 256, Threads: 1},
+	}
+	for _, kdf := range cases {
+		header, err := encodeHeader(&Blob{KDF: kdf})
+		if err != nil {
+			t.Fatalf("encodeHeader(%+v): %v", kdf, err)
+		}
+		for _, n := range []int{NonceLen, XNonceLen} {
+			raw := append(header, bytes.Repeat([]byte{0xcd}, SaltLen+n)...)
+			if len(raw)%2 != 1 {
+				t.Fatalf("headered blobid has even length %d", len(raw))
+			}
+			blob, err := ParseBlobID([]byte(hex.EncodeToString(raw)))
+			if err != nil {
+				t.Fatalf("ParseBlobID(%+v): %v", kdf, err)
+			}
+			if blob.Version != 2 || blob.KDF != kdf || len(blob.Nonce) != n {
+				t.Errorf("parsed version %d kdf %+v nonce %d, want 2 %+v %d", blob.Version, blob.KDF, len(blob.Nonce), kdf, n)
+			}
+		}
+	}
+}
+
+func TestParseBlobIDHeaderV2Malformed(t *testing.T) {
+	body := strings.Repeat("cd", SaltLen+NonceLen)
+	cases := map[string]string{
+		"no kdf field":   "4001" + "00",
+		"unknown field":  "4003" + "7f0100",
+		"unknown kdf id": "4007" + "01050900000001",
+		"truncated":      "40ff" + "010501",
+		"unknown cipher": "400b" + "010501000186a0" + "030109" + "00",
+	}
+	for name, header := range cases {
+		if _, err := ParseBlobID([]byte(header + body)); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestEncryptIterationsMatrix(t *testing.T) {
+	cases := []struct {
+		iter    int
+		version int
+	}{
+		{LegacyIterations, 0},
+		{1 << 10, 1},
+		{1 << 13, 1},
+		{150000, 2},
+	}
+	for _, tc := range cases {
+		kdf := KDFParams{ID: KDFPBKDF2, Iterations: tc.iter}
+		blobid, ciphertextB64, err := EncryptWith([]byte("matrix"), "pw", EncryptOptions{KDF: kdf})
+		if err != nil {
+			t.Fatalf("iterations %d: %v", tc.iter, err)
+		}
+		blob, err := ParseBlobID([]byte(blobid))
+		if err != nil {
+			t.Fatalf("iterations %d: %v", tc.iter, err)
+		}
+		if blob.KDF != kdf || blob.Version != tc.version {
+			t.Errorf("iterations %d: parsed %+v version %d", tc.iter, blob.KDF, blob.Version)
+		}
+
+		ciphertext, _ := base64.StdEncoding.DecodeString(ciphertextB64)
+		got, err := Decrypt([]byte(blobid), ciphertext, nil, "pw")
+		if err != nil || string(got) != "matrix" {
+			t.Errorf("iterations %d: Decrypt = %q, %v", tc.iter, got, err)
+		}
+	}
+}
+
+func TestParseBlobIDLengths(t *testing.T) {
+	cases := []struct {
+		name    string
+		hexLen  int // decoded bytes
+		header  string
+		wantErr string
+	}{
+		{"empty", 0, "", "need >=28 bytes (16-byte salt + 12-byte nonce), got 0"},
+		{"too short", 27, "", "need >=28 bytes (16-byte salt + 12-byte nonce), got 27"},
+		{"salt and nonce would overlap", 20, "", "need >=28 bytes (16-byte salt + 12-byte nonce), got 20"},
+		{"one byte", 1, "", "need >=28 bytes (16-byte salt + 12-byte nonce), got 1"},
+		{"headered too short", 20, "4007010501000249f0", "need >=37 bytes (9-byte header + 16-byte salt + 12-byte nonce), got 29"},
+		{"exactly minimum", 28, "", ""},
+		{"headered minimum", 28, "2c", ""},
+		{"producer digest", 32, "", ""},
+		{"between sizes", 34, "", "unsupported blobid format: length 34 bytes"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			blobid := tc.header + strings.Repeat("ab", tc.hexLen)
+			if tc.name == "one byte" {
+				blobid = "ab"
+			}
+			blob, err := ParseBlobID([]byte(blobid))
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ParseBlobID: %v", err)
+				}
+				if len(blob.Salt) != SaltLen || len(blob.Nonce) != NonceLen {
+					t.Errorf("salt %d nonce %d", len(blob.Salt), len(blob.Nonce))
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("error %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// A 20-byte blobid once sliced into a salt and nonce sharing 8 bytes and
+// failed as a wrong password; it must fail as malformed before any key
+// is derived.
+func TestDecryptShortBlobIDIsFormatError(t *testing.T) {
+	blobid := []byte(strings.Repeat("ab", 20))
+	_, err := Decrypt(blobid, make([]byte, 32), nil, "pw")
+	var format *FormatError
+	if !errors.As(err, &format) || errors.Is(err, ErrAuthFailed) {
+		t.Errorf("Decrypt with a 20-byte blobid: %v, want a *FormatError", err)
+	}
+}
+
+func TestParseBlobIDOversized(t *testing.T) {
+	blobid := bytes.Repeat([]byte("ab"), 8<<20)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := ParseBlobID(blobid)
+	runtime.ReadMemStats(&after)
+	var format *FormatError
+	if !errors.As(err, &format) || !strings.Contains(err.Error(), "more than the 4096 allowed") {
+		t.Fatalf("ParseBlobID of 16 MiB blobid: %v", err)
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 64<<10 {
+		t.Errorf("rejecting an oversized blobid allocated %d bytes", n)
+	}
+}
+
+func TestBlobFromParts(t *testing.T) {
+	raw := make([]byte, SaltLen+XNonceLen)
+	rand.Read(raw)
+	for _, n := range []int{NonceLen, XNonceLen} {
+		want, err := ParseBlob(raw[:SaltLen+n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := BlobFromParts(raw[:SaltLen], raw[SaltLen:SaltLen+n])
+		if err != nil {
+			t.Fatalf("%d-byte nonce: %v", n, err)
+		}
+		if got.ID() != want.ID() || got.CipherName() != want.CipherName() || got.KDF != want.KDF {
+			t.Errorf("%d-byte nonce: got %s %s %+v, want %s %s %+v", n, got.ID(), got.CipherName(), got.KDF, want.ID(), want.CipherName(), want.KDF)
+		}
+	}
+	var fe *FormatError
+	if _, err := BlobFromParts(raw[:SaltLen-1], raw[SaltLen:SaltLen+NonceLen]); !errors.As(err, &fe) {
+		t.Errorf("short salt: %v", err)
+	}
+	if _, err := BlobFromParts(raw[:SaltLen], raw[SaltLen:SaltLen+16]); !errors.As(err, &fe) {
+		t.Errorf("16-byte nonce: %v", err)
+	}
+}
+
+func TestParseBlobIDSentinels(t *testing.T) {
+	body := strings.Repeat("cd", SaltLen+NonceLen)
+	cases := []struct {
+		name, blobid string
+		want         error
+	}{
+		{"too short", strings.Repeat("ab", 20), ErrBlobTooShort},
+		{"headered too short", "2c" + strings.Repeat("ab", 20), ErrBlobTooShort},
+		{"odd length", body + "a", ErrBadHex},
+		{"invalid character", "zz" + body, ErrBadHex},
+		{"between sizes", strings.Repeat("ab", 34), ErrUnsupportedFormat},
+		{"header version", "ff" + body, ErrUnsupportedFormat},
+		{"unknown field", "4003" + "7f0100" + body, ErrUnsupportedFormat},
+		{"unknown cipher", "400b" + "010501000186a0" + "030109" + "00" + body, ErrUnsupportedFormat},
+		{"no kdf field", "4001" + "00" + body, ErrMalformedHeader},
+		{"truncated field", "40ff" + "010501" + body, ErrMalformedHeader},
+	}
+	for _, tc := range cases {
+		_, err := ParseBlobID([]byte(tc.blobid))
+		var format *FormatError
+		if !errors.As(err, &format) || !errors.Is(err, tc.want) {
+			t.Errorf("%s: %v, want a *FormatError wrapping %q", tc.name, err, tc.want)
+		}
+		// Only what a newer producer could write is an unsupported header.
+		newer := tc.name == "header version" || tc.name == "unknown field" || tc.name == "unknown cipher"
+		if errors.Is(err, ErrUnsupportedHeader) != newer {
+			t.Errorf("%s: errors.Is(ErrUnsupportedHeader) = %v", tc.name, !newer)
+		}
+	}
+}
+
+func TestCreatedRoundTrip(t *testing.T) {
+	created := time.Date(2025, 5, 13, 9, 30, 15, 999, time.UTC)
+	kdf := KDFParams{ID: KDFPBKDF2, Iterations: 1024}
+	for _, chunkSize := range []int{0, 16} {
+		raw, ciphertext, err := Seal([]byte("when was this sealed?"), "pw", EncryptOptions{KDF: kdf, ChunkSize: chunkSize, Created: created})
+		if err != nil {
+			t.Fatal(err)
+		}
+		blob, err := ParseBlob(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := created.Truncate(time.Second); !blob.Created.Equal(want) {
+			t.Errorf("chunk size %d: Created %v, want %v", chunkSize, blob.Created, want)
+		}
+		if _, err := DecryptBlob(blob, ciphertext, nil, "pw"); err != nil {
+			t.Fatalf("chunk size %d: %v", chunkSize, err)
+		}
+
+		// The same header with the time moved back a day: it still
+		// parses, but the ciphertext no longer authenticates.
+		forged := *blob
+		forged.Created = blob.Created.Add(-24 * time.Hour)
+		header, err := encodeHeader(&forged)
+		if err != nil {
+			t.Fatal(err)
+		}
+		forgedBlob, err := ParseBlob(append(append(header, blob.Salt...), blob.Nonce...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := DecryptBlob(forgedBlob, ciphertext, nil, "pw"); !errors.Is(err, ErrAuthFailed) {
+			t.Errorf("chunk size %d: altered created time: %v, want ErrAuthFailed", chunkSize, err)
+		}
+	}
+
+	legacy, err := ParseBlobID([]byte(strings.Repeat("ab", SaltLen+NonceLen)))
+	if err != nil || !legacy.Created.IsZero() {
+		t.Errorf("legacy blob: Created %v, %v", legacy.Created, err)
+	}
+}
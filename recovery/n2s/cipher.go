@@ -0,0 +1,85 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/n2s/cipher.go
+
+package n2s
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// CipherID selects the AEAD; the value is the id byte stored in a version
+// 2 blobid header. Blobids without a cipher field use ChaCha20-Poly1305.
+type CipherID byte
+
+const (
+	// CipherChaCha20Poly1305 is ChaCha20-Poly1305 with a 12-byte nonce,
+	// or XChaCha20-Poly1305 with a 24-byte one.
+	CipherChaCha20Poly1305 CipherID = 1
+	// CipherAES256GCM is AES-256-GCM with a 12-byte nonce, for archives
+	// that must use FIPS-approved algorithms.
+	CipherAES256GCM CipherID = 2
+)
+
+// TagLen is the length of the authentication tag every supported AEAD
+// appends to its output: Poly1305 for ChaCha20, GHASH for AES-GCM.
+const TagLen = 16
+
+func (id CipherID) String() string {
+	switch id {
+	case CipherChaCha20Poly1305:
+		return "chacha20-poly1305"
+	case CipherAES256GCM:
+		return "aes-256-gcm"
+	}
+	return fmt.Sprintf("cipher(%d)", byte(id))
+}
+
+// aes256KeySize is the largest of the key sizes crypto/aes accepts, the
+// one that selects AES-256.
+const aes256KeySize = 256 / 8
+
+// KeySize is the key length id needs, or 0 for an unknown cipher; it is
+// the keyLen to pass DeriveKey. Both supported ciphers take KeyLen bytes.
+func (id CipherID) KeySize() int {
+	switch id {
+	case 0, CipherChaCha20Poly1305:
+		return chacha20poly1305.KeySize
+	case CipherAES256GCM:
+		return aes256KeySize
+	}
+	return 0
+}
+
+// NewAEAD builds the cipher for id. For ChaCha20 the nonce length picks
+// the variant: 24-byte nonces mean XChaCha20-Poly1305. A key of the wrong
+// length for id, such as a raw -key or an unwrapped key that was never
+// derived, is refused here by name rather than left to fail in the cipher
+// package.
+func NewAEAD(key []byte, id CipherID, nonceSize int) (cipher.AEAD, error) {
+	if want := id.KeySize(); want != 0 && len(key) != want {
+		return nil, fmt.Errorf("key length %d != %s requires %d", len(key), id, want)
+	}
+	switch id {
+	case 0, CipherChaCha20Poly1305:
+		if nonceSize == chacha20poly1305.NonceSizeX {
+			return chacha20poly1305.NewX(key)
+		}
+		return chacha20poly1305.New(key)
+	case CipherAES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	}
+	return nil, fmt.Errorf("unsupported cipher id %d", byte(id))
+}
@@ -0,0 +1,64 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/n2s/cipher_test.go
+
+package n2s
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestAESGCMRoundTrip(t *testing.T) {
+	for _, chunk := range []int{0, 16} {
+		opts := EncryptOptions{Cipher: CipherAES256GCM, ChunkSize: chunk}
+		plaintext := []byte(strings.Repeat("fips archive ", 10))
+		raw, ciphertext, err := Seal(plaintext, "pw", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blob, err := ParseBlob(raw)
+		if err != nil || blob.Cipher != CipherAES256GCM {
+			t.Fatalf("parse: %+v, %v", blob, err)
+		}
+		got, err := DecryptBlob(blob, ciphertext, nil, "pw")
+		if err != nil || !bytes.Equal(got, plaintext) {
+			t.Errorf("chunk %d: %q, %v", chunk, got, err)
+		}
+		if _, err := DecryptBlob(blob, ciphertext, nil, "wrong"); !errors.Is(err, ErrAuthFailed) {
+			t.Errorf("chunk %d wrong password: %v", chunk, err)
+		}
+	}
+}
+
+func TestAESGCMNeedsTwelveByteNonce(t *testing.T) {
+	header, err := encodeHeader(&Blob{KDF: LegacyKDF, Cipher: CipherAES256GCM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := append(header, bytes.Repeat([]byte{0xcd}, SaltLen+XNonceLen)...)
+	if _, err := ParseBlob(raw); err == nil || !strings.Contains(err.Error(), "12-byte nonce") {
+		t.Errorf("GCM with a 24-byte nonce: %v", err)
+	}
+}
+
+func TestNewAEADKeyLength(t *testing.T) {
+	for _, id := range []CipherID{CipherChaCha20Poly1305, CipherAES256GCM} {
+		for _, n := range []int{16, 24, 64} {
+			want := fmt.Sprintf("key length %d != %s requires 32", n, id)
+			if _, err := NewAEAD(make([]byte, n), id, NonceLen); err == nil || err.Error() != want {
+				t.Errorf("%s with a %d-byte key: %v, want %q", id, n, err, want)
+			}
+		}
+		if _, err := NewAEAD(make([]byte, KeyLen), id, NonceLen); err != nil {
+			t.Errorf("%s with a %d-byte key: %v", id, KeyLen, err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/n2s/decrypt.go
+
+package n2s
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+)
+
+// Decrypt opens ciphertext sealed under the key derived from password and
+// the salt embedded in blobid (hex). additionalData must match what the
+// blob was sealed with; nil for blobs sealed without associated data.
+func Decrypt(blobid, ciphertext, additionalData []byte, password string) ([]byte, error) {
+	return DecryptContext(context.Background(), blobid, ciphertext, additionalData, password)
+}
+
+// DecryptContext is Decrypt giving up with an error wrapping ctx's error
+// once ctx is done: during the key derivation (see DeriveKeyCtx for
+// the abandoned goroutine this leaves behind) and between the chunks of a
+// chunked blob. A deadline on ctx thus bounds how long one blob can hold
+// a caller, however costly its KDF parameters.
+func DecryptContext(ctx context.Context, blobid, ciphertext, additionalData []byte, password string) ([]byte, error) {
+	blob, err := ParseBlobID(blobid)
+	if err != nil {
+		return nil, err
+	}
+	return DecryptBlobContext(ctx, blob, ciphertext, additionalData, password)
+}
+
+// DecryptBlob is Decrypt for an already-parsed blob.
+func DecryptBlob(blob *Blob, ciphertext, additionalData []byte, password string) ([]byte, error) {
+	return DecryptBlobContext(context.Background(), blob, ciphertext, additionalData, password)
+}
+
+// DecryptBlobContext is DecryptContext for an already-parsed blob.
+func DecryptBlobContext(ctx context.Context, blob *Blob, ciphertext, additionalData []byte, password string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if blob.WrappedKey != nil {
+		return nil, ErrWrappedKey
+	}
+	key, err := DeriveKeyCtx(ctx, password, blob.Salt, blob.KDF, blob.Cipher.KeySize(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(key)
+	return openContext(ctx, blob, key, ciphertext, additionalData)
+}
+
+// DecryptBlobWithKey is DecryptBlob for a key derived out of band, e.g.
+// by an HSM; the KDF recorded in the blobid is not used. key must be the
+// length the blob's cipher requires, blob.Cipher.KeySize(), as DeriveKey
+// would have checked.
+func DecryptBlobWithKey(blob *Blob, ciphertext, additionalData, key []byte) ([]byte, error) {
+	if want := blob.Cipher.KeySize(); len(key) != want {
+		return nil, fmt.Errorf("key length %d != %s requires %d", len(key), blob.CipherName(), want)
+	}
+	return Open(blob, key, ciphertext, additionalData)
+}
+
+// Failures a caller may want to act on differently: a truncated download
+// is worth re-fetching, an authentication failure worth another password.
+// A hash mismatch means the blob authenticated but its plaintext is not
+// what was archived, e.g. after a faulty format migration.
+var (
+	ErrTruncated    = errors.New("ciphertext too short to contain an auth tag")
+	ErrAuthFailed   = errors.New("authentication failed (wrong password or corrupted data)")
+	ErrHashMismatch = errors.New("plaintext does not match the SHA-256 stored in the blobid")
+)
+
+// CheckPlaintextHash compares sum with the blob's stored plaintext hash in
+// constant time.
+func CheckPlaintextHash(blob *Blob, sum []byte) error {
+	if subtle.ConstantTimeCompare(sum, blob.PlaintextHash) != 1 {
+		return ErrHashMismatch
+	}
+	return nil
+}
+
+// Open authenticates and decrypts ciphertext with an already-derived key.
+func Open(blob *Blob, key, ciphertext, additionalData []byte) ([]byte, error) {
+	return openContext(context.Background(), blob, key, ciphertext, additionalData)
+}
+
+func openContext(ctx context.Context, blob *Blob, key, ciphertext, additionalData []byte) ([]byte, error) {
+	aead, err := NewAEAD(key, blob.Cipher, len(blob.Nonce))
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+	if blob.ChunkSize > 0 {
+		var buf bytes.Buffer
+		if err := openChunked(ctx, aead, blob.Nonce, blob.ChunkSize, bytes.NewReader(ciphertext), &buf, blob.additionalData(additionalData)); err != nil {
+			Wipe(buf.Bytes())
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	if len(ciphertext) < aead.Overhead() {
+		return nil, fmt.Errorf("%w: got %d bytes, need at least %d", ErrTruncated, len(ciphertext), aead.Overhead())
+	}
+
+	plaintext, err := aead.Open(nil, blob.Nonce, ciphertext, blob.additionalData(additionalData))
+	if err == nil {
+		return plaintext, nil
+	}
+	if len(additionalData) == 0 {
+		return nil, ErrAuthFailed
+	}
+
+	// The tag covers key and associated data together, so wrong data is
+	// indistinguishable from a wrong password. The one case we can name
+	// is a blob that was sealed without any associated data.
+	if _, plainErr := aead.Open(nil, blob.Nonce, ciphertext, blob.additionalData(nil)); plainErr == nil {
+		return nil, fmt.Errorf("%w: blob was sealed without associated data", ErrAuthFailed)
+	}
+	return nil, fmt.Errorf("%w: associated data does not match what the blob was sealed with (or the password is wrong)", ErrAuthFailed)
+}
@@ -0,0 +1,137 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/n2s/decrypt_test.go
+
+package n2s
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// sealClassic builds a blob the way the n2s producer does: a 32-byte
+// blobid whose first 16 bytes are the salt and last 12 the nonce.
+func sealClassic(t *testing.T, plaintext []byte, password string) (blobid, ciphertext []byte) {
+	t.Helper()
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatal(err)
+	}
+	key := pbkdf2.Key([]byte(password), raw[:SaltLen], LegacyIterations, KeyLen, sha256.New)
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext = aead.Seal(nil, raw[len(raw)-NonceLen:], plaintext, nil)
+	return []byte(hex.EncodeToString(raw)), ciphertext
+}
+
+func TestDecryptRoundTrip(t *testing.T) {
+	plaintext := []byte("recovered note contents")
+	blobid, ciphertext := sealClassic(t, plaintext, "correct horse")
+
+	got, err := Decrypt(blobid, ciphertext, nil, "correct horse")
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("plaintext = %q, want %q", got, plaintext)
+	}
+}
+
+func TestDecryptWrongPassword(t *testing.T) {
+	blobid, ciphertext := sealClassic(t, []byte("secret"), "correct horse")
+
+	if _, err := Decrypt(blobid, ciphertext, nil, "battery staple"); err == nil {
+		t.Fatal("Decrypt with wrong password succeeded")
+	}
+}
+
+func TestDecryptAssociatedData(t *testing.T) {
+	aad := []byte("notes/2024/interview.txt")
+	blobid, ctB64, err := EncryptWith([]byte("bound"), "pw", EncryptOptions{AdditionalData: aad})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, _ := base64.StdEncoding.DecodeString(ctB64)
+
+	got, err := Decrypt([]byte(blobid), ciphertext, aad, "pw")
+	if err != nil || string(got) != "bound" {
+		t.Fatalf("matching AAD: %q, %v", got, err)
+	}
+
+	_, err = Decrypt([]byte(blobid), ciphertext, []byte("notes/2024/other.txt"), "pw")
+	if err == nil || !strings.Contains(err.Error(), "associated data does not match") {
+		t.Errorf("mismatched AAD: %v", err)
+	}
+	if _, err := Decrypt([]byte(blobid), ciphertext, nil, "pw"); err == nil {
+		t.Error("missing AAD: decrypted")
+	}
+}
+
+func TestDecryptAssociatedDataOnUnboundBlob(t *testing.T) {
+	blobid, ciphertext := sealClassic(t, []byte("unbound"), "pw")
+	_, err := Decrypt(blobid, ciphertext, []byte("name.txt"), "pw")
+	if err == nil || !strings.Contains(err.Error(), "sealed without associated data") {
+		t.Errorf("AAD on unbound blob: %v", err)
+	}
+}
+
+func TestDecryptContextCanceled(t *testing.T) {
+	blobid, ciphertext := sealClassic(t, []byte("x"), "pw")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := DecryptContext(ctx, blobid, ciphertext, nil, "pw"); !errors.Is(err, context.Canceled) {
+		t.Errorf("DecryptContext after cancel: %v", err)
+	}
+
+	raw, ciphertext := sealChunkedBlob(t, make([]byte, 3*testChunk))
+	blob, _ := ParseBlob(raw)
+	key, err := DeriveKey("pw", blob.Salt, blob.KDF, blob.Cipher.KeySize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	w := writerFunc(func(p []byte) (int, error) { cancel(); return len(p), nil })
+	if err := OpenStreamContext(ctx, blob, key, bytes.NewReader(ciphertext), w, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("OpenStreamContext canceled after chunk 0: %v", err)
+	}
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+func TestDecryptBlobWithKey(t *testing.T) {
+	blobid, ciphertext := sealClassic(t, []byte("hsm"), "pw")
+	blob, err := ParseBlobID(blobid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := DeriveKey("pw", blob.Salt, blob.KDF, blob.Cipher.KeySize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := DecryptBlobWithKey(blob, ciphertext, nil, key); err != nil || string(got) != "hsm" {
+		t.Errorf("DecryptBlobWithKey = %q, %v", got, err)
+	}
+	want := fmt.Sprintf("key length 16 != chacha20-poly1305 requires %d", blob.Cipher.KeySize())
+	if _, err := DecryptBlobWithKey(blob, ciphertext, nil, key[:16]); err == nil || err.Error() != want {
+		t.Errorf("short key: %v, want %q", err, want)
+	}
+}
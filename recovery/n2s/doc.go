@@ -0,0 +1,26 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/n2s/doc.go
+
+// Package n2s reads and writes n2s blobs: a blobid carrying the salt,
+// nonce and, when headered, the KDF, cipher and chunking parameters,
+// plus the AEAD ciphertext it unlocks.
+//
+// ParseBlobID and ParseBlob decode a blobid, ParseBlobIDLayout one laid
+// out by another producer, and ParseBlobIDStrict only a headered one
+// (RequireHeader checks a blob already parsed); DecryptBlob, Open and
+// OpenStream decrypt; EncryptWith, Seal and NewSealer encrypt;
+// NewDecryptingReader and NewEncryptingWriter do either through io;
+// Rekey and Migrate rewrite a blob's id. Malformed input comes back as a
+// *FormatError wrapping ErrBlobTooShort, ErrBadHex, ErrUnsupportedFormat
+// (ErrUnsupportedHeader when a newer producer wrote it; HeaderVersions,
+// KDFs and Ciphers list what this build reads), ErrMalformedHeader or,
+// in strict mode, ErrLegacyRejected; a wrong password or tampering as
+// ErrAuthFailed.
+// The package never touches stdout, flags or exit codes; that is the
+// decrypt command's job.
+package n2s
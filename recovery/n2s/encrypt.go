@@ -0,0 +1,209 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/n2s/encrypt.go
+
+package n2s
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// EncryptOptions tunes Encrypt. The zero value produces a legacy
+// headerless blobid that any recovery binary can read.
+type EncryptOptions struct {
+	// KDF selects the key derivation; anything other than PBKDF2 with
+	// the legacy iteration count is recorded in a blobid header.
+	KDF KDFParams
+	// AdditionalData is authenticated but not encrypted; Decrypt must be
+	// given the same bytes.
+	AdditionalData []byte
+	// Cipher selects the AEAD; zero means ChaCha20-Poly1305, the only
+	// one older recovery binaries can read.
+	Cipher CipherID
+	// NonceLen is NonceLen (the default when zero) or XNonceLen, which
+	// selects XChaCha20-Poly1305 and so needs the ChaCha20 cipher.
+	NonceLen int
+	// ChunkSize, if non-zero, seals the plaintext as a chunked stream of
+	// pieces this large, which decrypt can process in constant memory.
+	ChunkSize int
+	// StoreHash records the plaintext's SHA-256 in the blobid header, for
+	// CheckPlaintextHash. The hash is visible without the password, so
+	// it reveals which blobs share a plaintext. It needs the whole
+	// plaintext before the blobid exists and so excludes ChunkSize.
+	StoreHash bool
+	// MasterKey, if set, makes an envelope blob: its key is random and
+	// sealed under MasterKey in the blobid header, and the password is
+	// not used. It excludes KDF.
+	MasterKey []byte
+	// Created, if non-zero, is recorded in the blobid header to the
+	// second and bound to the ciphertext as associated data; it is
+	// readable without the password. Usually time.Now().
+	Created time.Time
+	// Rand supplies the salt, nonce and any envelope data key; nil means
+	// crypto/rand.Reader. Setting it is for tests wanting byte-exact
+	// blobids: a predictable source makes a predictable, and so
+	// worthless, salt and nonce.
+	Rand io.Reader
+}
+
+func (o *EncryptOptions) random() io.Reader {
+	if o.Rand == nil {
+		return rand.Reader
+	}
+	return o.Rand
+}
+
+// Encrypt seals plaintext under a fresh random salt and nonce. The blobid
+// is hex(salt || nonce), which ParseBlobID splits back apart, so the result
+// decrypts with the same path used for producer blobs.
+func Encrypt(plaintext []byte, password string) (blobid string, ciphertextB64 string, err error) {
+	return EncryptWith(plaintext, password, EncryptOptions{})
+}
+
+// EncryptWith is Encrypt with explicit options.
+func EncryptWith(plaintext []byte, password string, opts EncryptOptions) (blobid string, ciphertextB64 string, err error) {
+	raw, ciphertext, err := Seal(plaintext, password, opts)
+	if err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(raw), base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Seal is EncryptWith returning the raw blobid bytes and ciphertext.
+func Seal(plaintext []byte, password string, opts EncryptOptions) (blobid, ciphertext []byte, err error) {
+	var hash []byte
+	if opts.StoreHash {
+		sum := sha256.Sum256(plaintext)
+		hash = sum[:]
+	}
+	s, err := newSealer(password, opts, hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer s.Close()
+	if s.chunkSize == 0 {
+		return s.blobid, s.aead.Seal(nil, s.nonce, plaintext, s.aad), nil
+	}
+	var buf bytes.Buffer
+	if err := s.SealTo(&buf, bytes.NewReader(plaintext)); err != nil {
+		return nil, nil, err
+	}
+	return s.blobid, buf.Bytes(), nil
+}
+
+// Sealer holds a fresh blob's key between generating the blobid and
+// sealing the plaintext, so a chunked stream can emit the blobid first.
+type Sealer struct {
+	blobid    []byte
+	nonce     []byte
+	chunkSize int
+	aad       []byte
+	key       []byte
+	aead      cipher.AEAD
+}
+
+// NewSealer draws a random salt and nonce for a chunked blob and derives
+// their key, or draws and wraps one with opts.MasterKey; Close wipes it.
+// opts.StoreHash is not supported, since the hash would have to precede
+// the plaintext.
+func NewSealer(password string, opts EncryptOptions) (*Sealer, error) {
+	return newSealer(password, opts, nil)
+}
+
+// newSealer is NewSealer recording plaintextHash in the header when
+// opts.StoreHash is set.
+func newSealer(password string, opts EncryptOptions, plaintextHash []byte) (*Sealer, error) {
+	kdf := opts.KDF
+	if kdf.ID == 0 && opts.MasterKey == nil {
+		kdf = LegacyKDF
+	}
+	if opts.StoreHash && opts.ChunkSize != 0 {
+		return nil, errors.New("a stored plaintext hash needs the whole plaintext before the blobid; it cannot be combined with chunking")
+	}
+	if opts.MasterKey != nil && kdf.ID != 0 {
+		return nil, errors.New("an envelope blob's key comes from its master key; it cannot also have a KDF")
+	}
+
+	nonceLen := opts.NonceLen
+	switch {
+	case nonceLen == 0:
+		nonceLen = NonceLen
+	case nonceLen != NonceLen && nonceLen != XNonceLen:
+		return nil, fmt.Errorf("nonce length %d: want %d or %d", nonceLen, NonceLen, XNonceLen)
+	case nonceLen == XNonceLen && opts.Cipher == CipherAES256GCM:
+		return nil, fmt.Errorf("%s needs a %d-byte nonce", CipherAES256GCM, NonceLen)
+	}
+
+	raw := make([]byte, SaltLen+nonceLen)
+	if _, err := io.ReadFull(opts.random(), raw); err != nil {
+		return nil, fmt.Errorf("generating salt and nonce: %w", err)
+	}
+	salt := raw[:SaltLen]
+	nonce := raw[SaltLen:]
+
+	var key, wrapped []byte
+	var err error
+	if opts.MasterKey != nil {
+		key = make([]byte, opts.Cipher.KeySize())
+		if _, err := io.ReadFull(opts.random(), key); err != nil {
+			return nil, fmt.Errorf("generating data key: %w", err)
+		}
+		wrapped, err = wrapKey(opts.random(), opts.MasterKey, key, salt)
+	} else {
+		key, err = DeriveKey(password, salt, kdf, opts.Cipher.KeySize())
+	}
+	if err != nil {
+		Wipe(key)
+		return nil, err
+	}
+	blob := &Blob{KDF: kdf, Cipher: opts.Cipher, ChunkSize: opts.ChunkSize, PlaintextHash: plaintextHash, WrappedKey: wrapped}
+	if !opts.Created.IsZero() {
+		blob.Created = time.Unix(opts.Created.Unix(), 0)
+	}
+	header, err := encodeHeader(blob)
+	if err != nil {
+		Wipe(key)
+		return nil, err
+	}
+	aead, err := NewAEAD(key, opts.Cipher, nonceLen)
+	if err != nil {
+		Wipe(key)
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+	return &Sealer{
+		blobid:    append(header, raw...),
+		nonce:     nonce,
+		chunkSize: opts.ChunkSize,
+		aad:       blob.additionalData(opts.AdditionalData),
+		key:       key,
+		aead:      aead,
+	}, nil
+}
+
+// BlobID returns the raw blobid bytes.
+func (s *Sealer) BlobID() []byte {
+	return s.blobid
+}
+
+// SealTo streams the chunked ciphertext of r to w.
+func (s *Sealer) SealTo(w io.Writer, r io.Reader) error {
+	return sealChunked(s.aead, s.nonce, s.chunkSize, r, w, s.aad)
+}
+
+// Close wipes the key.
+func (s *Sealer) Close() {
+	Wipe(s.key)
+}
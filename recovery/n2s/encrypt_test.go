@@ -0,0 +1,82 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/n2s/encrypt_test.go
+
+package n2s
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	plaintext := []byte("notes to recover later")
+	blobid, ciphertextB64, err := Encrypt(plaintext, "pw")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if len(blobid) != 2*(SaltLen+NonceLen) {
+		t.Errorf("blobid length = %d, want %d", len(blobid), 2*(SaltLen+NonceLen))
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(ciphertextB64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decrypt([]byte(blobid), ciphertext, nil, "pw")
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("plaintext = %q, want %q", got, plaintext)
+	}
+}
+
+// countingReader yields 0, 1, 2, ... forever.
+type countingReader struct{ next byte }
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = c.next
+		c.next++
+	}
+	return len(p), nil
+}
+
+func TestEncryptFixedRandVectors(t *testing.T) {
+	cases := []struct {
+		name       string
+		opts       EncryptOptions
+		blobid, ct string
+	}{
+		{"legacy", EncryptOptions{},
+			"000102030405060708090a0b0c0d0e0f101112131415161718191a1b",
+			"K5mdHVwV6+UnDrXozHHlMlafi5XDtkBJa4mAwrE="},
+		{"chunked xchacha", EncryptOptions{ChunkSize: 8, NonceLen: XNonceLen},
+			"400d010501000186a0020400000008000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f2021222324252627",
+			"iRSNjwHl9bYcCjmKaGUhB8mG9OBIyn2JBRV1Dl6wF2UN83yuaV6vj5S4nO+n"},
+	}
+	for _, tc := range cases {
+		tc.opts.Rand = &countingReader{}
+		blobid, ct, err := EncryptWith([]byte("golden vector"), "correct horse", tc.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if blobid != tc.blobid || ct != tc.ct {
+			t.Errorf("%s: got %s %s, want %s %s", tc.name, blobid, ct, tc.blobid, tc.ct)
+		}
+		raw, _ := base64.StdEncoding.DecodeString(tc.ct)
+		if got, err := Decrypt([]byte(tc.blobid), raw, nil, "correct horse"); err != nil || string(got) != "golden vector" {
+			t.Errorf("%s: vector does not decrypt: %q, %v", tc.name, got, err)
+		}
+	}
+
+	if _, _, err := EncryptWith(nil, "pw", EncryptOptions{Rand: bytes.NewReader(make([]byte, SaltLen))}); err == nil {
+		t.Error("a Rand too short for salt and nonce was accepted")
+	}
+}
@@ -0,0 +1,87 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/n2s/envelope.go
+
+package n2s
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// An envelope blob has a random data key of its own, sealed under a
+// master key and carried in the blobid header's WRAPPED field in place of
+// a KDF. Rotating the master key means rewrapping that field, not
+// re-encrypting the blob.
+//
+// The wrap is XChaCha20-Poly1305 under the master key with a random
+// 24-byte nonce, and the blob's salt as associated data, so a wrapped key
+// copied onto another blob does not unwrap.
+const wrappedKeyLen = XNonceLen + KeyLen + chacha20poly1305.Overhead
+
+// ErrWrappedKey reports a password given for an envelope blob, whose key
+// only the master key recovers.
+var ErrWrappedKey = errors.New("blob key is wrapped under a master key, not derived from a password")
+
+// WrapKey seals dataKey under masterKey for the blob with the given salt,
+// returning the WRAPPED field value.
+func WrapKey(masterKey, dataKey, salt []byte) ([]byte, error) {
+	return wrapKey(rand.Reader, masterKey, dataKey, salt)
+}
+
+func wrapKey(random io.Reader, masterKey, dataKey, salt []byte) ([]byte, error) {
+	if len(masterKey) != KeyLen || len(dataKey) != KeyLen {
+		return nil, fmt.Errorf("master and data keys must be %d bytes, got %d and %d", KeyLen, len(masterKey), len(dataKey))
+	}
+	aead, err := chacha20poly1305.NewX(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, XNonceLen, wrappedKeyLen)
+	if _, err := io.ReadFull(random, nonce); err != nil {
+		return nil, fmt.Errorf("generating wrap nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, dataKey, salt), nil
+}
+
+// UnwrapKey recovers an envelope blob's data key. A wrong master key, or
+// a wrapped key moved from another blob, fails as ErrAuthFailed. The
+// caller should Wipe the key when done.
+func UnwrapKey(blob *Blob, masterKey []byte) ([]byte, error) {
+	if blob.WrappedKey == nil {
+		return nil, errors.New("blob key is derived from a password, not wrapped under a master key")
+	}
+	if len(masterKey) != KeyLen {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", KeyLen, len(masterKey))
+	}
+	aead, err := chacha20poly1305.NewX(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce, sealed := blob.WrappedKey[:XNonceLen], blob.WrappedKey[XNonceLen:]
+	key, err := aead.Open(nil, nonce, sealed, blob.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unwrapping the data key (wrong master key?)", ErrAuthFailed)
+	}
+	return key, nil
+}
+
+// DecryptBlobWithMasterKey is DecryptBlob for an envelope blob: it unwraps
+// the data key with masterKey, then opens the ciphertext with it.
+func DecryptBlobWithMasterKey(ctx context.Context, blob *Blob, ciphertext, additionalData, masterKey []byte) ([]byte, error) {
+	key, err := UnwrapKey(blob, masterKey)
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(key)
+	return openContext(ctx, blob, key, ciphertext, additionalData)
+}
@@ -0,0 +1,104 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/n2s/envelope_test.go
+
+package n2s
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	master := make([]byte, KeyLen)
+	rand.Read(master)
+	for _, chunk := range []int{0, 16} {
+		raw, ciphertext, err := Seal([]byte("enveloped plaintext"), "", EncryptOptions{MasterKey: master, ChunkSize: chunk})
+		if err != nil {
+			t.Fatal(err)
+		}
+		blob, err := ParseBlob(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if blob.Version != 2 || len(blob.WrappedKey) != wrappedKeyLen || blob.KDF != (KDFParams{}) {
+			t.Fatalf("chunk %d: version %d, wrapped %d bytes, kdf %+v", chunk, blob.Version, len(blob.WrappedKey), blob.KDF)
+		}
+
+		key, err := UnwrapKey(blob, master)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := OpenStream(blob, key, bytes.NewReader(ciphertext), &out, nil); chunk > 0 && err != nil {
+			t.Fatalf("chunk %d: %v", chunk, err)
+		}
+		if chunk == 0 {
+			got, err := DecryptBlobWithMasterKey(context.Background(), blob, ciphertext, nil, master)
+			if err != nil || string(got) != "enveloped plaintext" {
+				t.Errorf("DecryptBlobWithMasterKey: %q, %v", got, err)
+			}
+		} else if out.String() != "enveloped plaintext" {
+			t.Errorf("chunk %d: plaintext %q", chunk, out.String())
+		}
+	}
+}
+
+func TestEnvelopeWrongMasterKey(t *testing.T) {
+	master, other := make([]byte, KeyLen), make([]byte, KeyLen)
+	rand.Read(master)
+	rand.Read(other)
+	raw, ciphertext, err := Seal([]byte("x"), "", EncryptOptions{MasterKey: master})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, _ := ParseBlob(raw)
+	if _, err := DecryptBlobWithMasterKey(context.Background(), blob, ciphertext, nil, other); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("wrong master key: %v", err)
+	}
+	if _, err := DecryptBlob(blob, ciphertext, nil, "password"); !errors.Is(err, ErrWrappedKey) {
+		t.Errorf("password for an envelope blob: %v", err)
+	}
+
+	// The wrap is bound to the salt: the same wrapped key on another blob
+	// does not unwrap.
+	moved := *blob
+	moved.Salt = make([]byte, SaltLen)
+	if _, err := UnwrapKey(&moved, master); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("wrapped key on another salt: %v", err)
+	}
+}
+
+func TestEnvelopeHeader(t *testing.T) {
+	wrapped := bytes.Repeat([]byte{0xee}, wrappedKeyLen)
+	body := make([]byte, SaltLen+NonceLen)
+	header, err := encodeHeader(&Blob{WrappedKey: wrapped})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := ParseBlob(append(header, body...))
+	if err != nil || !bytes.Equal(blob.WrappedKey, wrapped) {
+		t.Fatalf("parse: %v, wrapped %x", err, blob.WrappedKey)
+	}
+
+	// A header with both a KDF and a wrapped key is ambiguous.
+	kdf := []byte{fieldKDF, 5, byte(KDFPBKDF2), 0, 1, 0x86, 0xa0}
+	fields := append(append(kdf, fieldWrapped, wrappedKeyLen), wrapped...)
+	if len(fields)%2 == 0 {
+		fields = append(fields, fieldPad)
+	}
+	both := append([]byte{headerV2, byte(len(fields))}, fields...)
+	if _, err := ParseBlob(append(both, body...)); err == nil {
+		t.Error("KDF and wrapped key together: no error")
+	}
+	if _, err := encodeHeader(&Blob{KDF: LegacyKDF, WrappedKey: wrapped}); err == nil {
+		t.Error("encoding KDF and wrapped key together: no error")
+	}
+}
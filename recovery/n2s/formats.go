@@ -0,0 +1,43 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/n2s/formats.go
+
+package n2s
+
+// What this build of the package reads, for callers that want to know
+// before trying a blob whether it can be opened at all. Each list is in
+// id order and grows as the format does; nothing is ever dropped, since
+// existing blobs must stay readable.
+
+// HeaderVersions lists the blobid header versions ParseBlobID accepts,
+// 0 being a legacy blobid with no header.
+func HeaderVersions() []int { return []int{0, 1, 2} }
+
+// KDFs lists the key derivation functions a header may name.
+func KDFs() []KDFID { return []KDFID{KDFPBKDF2, KDFArgon2id} }
+
+// Ciphers lists the AEADs a header may name.
+func Ciphers() []CipherID { return []CipherID{CipherChaCha20Poly1305, CipherAES256GCM} }
+
+// NonceSizes lists the nonce lengths id takes, or nil for an unknown
+// cipher.
+func (id CipherID) NonceSizes() []int {
+	switch id {
+	case CipherChaCha20Poly1305:
+		return []int{NonceLen, XNonceLen}
+	case CipherAES256GCM:
+		return []int{NonceLen}
+	}
+	return nil
+}
+
+// LegacyBlobIDLengths lists the byte lengths of a blobid without a
+// header: a salt and a 12-byte nonce, the same with the 4 unused bytes of
+// a producer digest between them, or a salt and a 24-byte nonce.
+func LegacyBlobIDLengths() []int {
+	return []int{SaltLen + NonceLen, digestBlobIDLen, SaltLen + XNonceLen}
+}
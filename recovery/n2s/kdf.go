@@ -0,0 +1,202 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/n2s/kdf.go
+
+package n2s
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// KDF parameters; these must match the producer exactly or existing
+// blobs become unrecoverable. LegacyIterations is the count for blobids
+// without a header.
+const (
+	LegacyIterations = 100000
+	KeyLen           = 32
+)
+
+// KDFID selects the key derivation function; the value is the id byte
+// stored in a version 2 blobid header.
+type KDFID byte
+
+const (
+	KDFPBKDF2   KDFID = 1
+	KDFArgon2id KDFID = 2
+)
+
+func (id KDFID) String() string {
+	switch id {
+	case KDFPBKDF2:
+		return "pbkdf2-sha256"
+	case KDFArgon2id:
+		return "argon2id"
+	}
+	return fmt.Sprintf("kdf(%d)", byte(id))
+}
+
+// Argon2id defaults follow the second recommended option of RFC 9106.
+const (
+	DefaultArgon2Time    = 3
+	DefaultArgon2Memory  = 64 * 1024
+	DefaultArgon2Threads = 4
+)
+
+// KDFParams describes how a blob key is derived from a password.
+type KDFParams struct {
+	ID KDFID
+	// Iterations is the PBKDF2 count.
+	Iterations int
+	// Time (passes), Memory (KiB) and Threads are the Argon2id costs.
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+// LegacyKDF is what every headerless blobid uses.
+var LegacyKDF = KDFParams{ID: KDFPBKDF2, Iterations: LegacyIterations}
+
+// DeriveKey derives the blob key from password and salt. The producer
+// always derives KeyLen bytes; keyLen is what the blob's cipher requires,
+// blob.Cipher.KeySize(), and a key of any other length is refused here,
+// by name, rather than handed to the cipher to fail obscurely.
+func DeriveKey(password string, salt []byte, params KDFParams, keyLen int) ([]byte, error) {
+	var key []byte
+	switch params.ID {
+	case KDFPBKDF2:
+		if params.Iterations < 1 {
+			return nil, fmt.Errorf("pbkdf2: invalid iteration count %d", params.Iterations)
+		}
+		key = pbkdf2.Key([]byte(password), salt, params.Iterations, KeyLen, sha256.New)
+	case KDFArgon2id:
+		if params.Time < 1 || params.Threads < 1 || params.Memory < 8*uint32(params.Threads) {
+			return nil, fmt.Errorf("argon2id: invalid parameters t=%d m=%d p=%d", params.Time, params.Memory, params.Threads)
+		}
+		key = argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, KeyLen)
+	default:
+		return nil, fmt.Errorf("unsupported KDF id %d", byte(params.ID))
+	}
+	return checkKeyLen(key, keyLen)
+}
+
+// checkKeyLen returns key if it is keyLen bytes, and otherwise wipes it.
+func checkKeyLen(key []byte, keyLen int) ([]byte, error) {
+	if len(key) != keyLen {
+		Wipe(key)
+		return nil, fmt.Errorf("derived key length %d != cipher requires %d", len(key), keyLen)
+	}
+	return key, nil
+}
+
+// pbkdf2Segment is how many PBKDF2 iterations run between checks of ctx
+// and calls to progress: well under a millisecond each.
+const pbkdf2Segment = 1024
+
+// DeriveKeyCtx is DeriveKey returning as soon as ctx is done, with an
+// error wrapping ctx's error, and calling progress, if not nil, with the
+// fraction of the work done so far, for a spinner or progress bar.
+//
+// PBKDF2 runs in segments of iterations on the calling goroutine, so
+// cancellation stops the work itself and progress is called as each
+// segment ends; the key is the one pbkdf2.Key derives. Argon2id cannot be
+// split, so progress only hears 0 and 1, and on cancellation the
+// derivation is left running in a goroutine: it still burns its CPU and
+// memory to completion before the key is wiped and dropped. A deadline
+// then caps how long the caller waits, not the work done.
+func DeriveKeyCtx(ctx context.Context, password string, salt []byte, params KDFParams, keyLen int, progress func(done float64)) ([]byte, error) {
+	if progress == nil {
+		progress = func(float64) {}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("key derivation: %w", err)
+	}
+	if params.ID == KDFPBKDF2 && params.Iterations >= 1 {
+		key, err := pbkdf2Segmented(ctx, password, salt, params.Iterations, progress)
+		if err != nil {
+			return nil, err
+		}
+		return checkKeyLen(key, keyLen)
+	}
+	progress(0)
+	key, err := deriveAbandonable(ctx, password, salt, params, keyLen)
+	if err == nil {
+		progress(1)
+	}
+	return key, err
+}
+
+// pbkdf2Segmented is PBKDF2-HMAC-SHA256 for one KeyLen block, written out
+// as RFC 8018 has it, so it can stop between iterations: T = U1 ^ ... ^
+// Uc, with U1 = HMAC(P, S || INT(1)) and Ui = HMAC(P, Ui-1).
+func pbkdf2Segmented(ctx context.Context, password string, salt []byte, iterations int, progress func(float64)) ([]byte, error) {
+	prf := hmac.New(sha256.New, []byte(password))
+	prf.Write(salt)
+	prf.Write([]byte{0, 0, 0, 1})
+	u := prf.Sum(nil)
+	t := append([]byte(nil), u...)
+	done := ctx.Done()
+	progress(0)
+	for i := 1; i < iterations; i++ {
+		if i%pbkdf2Segment == 0 {
+			select {
+			case <-done:
+				Wipe(u)
+				Wipe(t)
+				return nil, fmt.Errorf("key derivation stopped after %d of %d iterations: %w", i, iterations, ctx.Err())
+			default:
+			}
+			progress(float64(i) / float64(iterations))
+		}
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range t {
+			t[j] ^= u[j]
+		}
+	}
+	Wipe(u)
+	progress(1)
+	return t, nil
+}
+
+// deriveAbandonable runs DeriveKey in a goroutine that is abandoned, not
+// stopped, once ctx is done.
+func deriveAbandonable(ctx context.Context, password string, salt []byte, params KDFParams, keyLen int) ([]byte, error) {
+	if ctx.Done() == nil {
+		return DeriveKey(password, salt, params, keyLen)
+	}
+	type result struct {
+		key []byte
+		err error
+	}
+	// done is unbuffered, so the key is only ever handed to a caller that
+	// is still waiting for it; once abandoned is closed the goroutine
+	// wipes it instead.
+	done := make(chan result)
+	abandoned := make(chan struct{})
+	go func() {
+		key, err := DeriveKey(password, salt, params, keyLen)
+		select {
+		case done <- result{key, err}:
+		case <-abandoned:
+			Wipe(key)
+		}
+	}()
+	select {
+	case r := <-done:
+		return r.key, r.err
+	case <-ctx.Done():
+		close(abandoned)
+		return nil, fmt.Errorf("key derivation abandoned: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,179 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/n2s/kdf_test.go
+
+package n2s
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func TestDeriveKeyKnownAnswers(t *testing.T) {
+	cases := []struct {
+		name     string
+		password string
+		salt     string
+		params   KDFParams
+		want     string
+	}{
+		// RFC 7914 section 11, first 32 bytes.
+		{"pbkdf2 c=1", "passwd", "salt", KDFParams{ID: KDFPBKDF2, Iterations: 1},
+			"55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"},
+		{"pbkdf2 c=80000", "Password", "NaCl", KDFParams{ID: KDFPBKDF2, Iterations: 80000},
+			"4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56"},
+		// Argon2 reference implementation test vectors (version 0x13).
+		{"argon2id m=256", "password", "somesalt", KDFParams{ID: KDFArgon2id, Time: 2, Memory: 256, Threads: 1},
+			"9dfeb910e80bad0311fee20f9c0e2b12c17987b4cac90c2ef54d5b3021c68bfe"},
+		{"argon2id m=65536", "password", "somesalt", KDFParams{ID: KDFArgon2id, Time: 2, Memory: 65536, Threads: 1},
+			"09316115d5cf24ed5a15a31a3ba326e5cf32edc24702987c02b6566f61913cf7"},
+	}
+	for _, tc := range cases {
+		key, err := DeriveKey(tc.password, []byte(tc.salt), tc.params, KeyLen)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got := hex.EncodeToString(key); got != tc.want {
+			t.Errorf("%s: key %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDeriveKeyRejectsBadParams(t *testing.T) {
+	for _, params := range []KDFParams{
+		{},
+		{ID: KDFPBKDF2},
+		{ID: KDFArgon2id, Time: 1, Memory: 4, Threads: 1},
+		{ID: 9, Iterations: 1},
+	} {
+		if _, err := DeriveKey("pw", []byte("salt"), params, KeyLen); err == nil {
+			t.Errorf("DeriveKey accepted %+v", params)
+		}
+	}
+}
+
+func TestDeriveKeyLengthMismatch(t *testing.T) {
+	argon := KDFParams{ID: KDFArgon2id, Time: 1, Memory: 64, Threads: 1}
+	for _, params := range []KDFParams{{ID: KDFPBKDF2, Iterations: 2}, argon} {
+		for _, n := range []int{16, 24, 64} {
+			want := fmt.Sprintf("derived key length %d != cipher requires %d", KeyLen, n)
+			if key, err := DeriveKey("pw", []byte("salt"), params, n); err == nil || err.Error() != want {
+				t.Errorf("%s for a %d-byte cipher: %x, %v; want %q", params.ID, n, key, err, want)
+			}
+			if _, err := DeriveKeyCtx(context.Background(), "pw", []byte("salt"), params, n, nil); err == nil || err.Error() != want {
+				t.Errorf("%s for a %d-byte cipher in DeriveKeyCtx: %v", params.ID, n, err)
+			}
+		}
+	}
+}
+
+func TestEncryptArgon2idRoundTrip(t *testing.T) {
+	kdf := KDFParams{ID: KDFArgon2id, Time: 1, Memory: 1024, Threads: 2}
+	blobid, ciphertextB64, err := EncryptWith([]byte("argon"), "pw", EncryptOptions{KDF: kdf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, _ := base64.StdEncoding.DecodeString(ciphertextB64)
+	got, err := Decrypt([]byte(blobid), ciphertext, nil, "pw")
+	if err != nil || string(got) != "argon" {
+		t.Fatalf("Decrypt = %q, %v", got, err)
+	}
+}
+
+func TestDeriveKeyCtxDeadline(t *testing.T) {
+	// 2^24 PBKDF2 iterations take seconds; the deadline must not wait for
+	// them.
+	params := KDFParams{ID: KDFPBKDF2, Iterations: 1 << 24}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	key, err := DeriveKeyCtx(ctx, "pw", make([]byte, SaltLen), params, KeyLen, nil)
+	if !errors.Is(err, context.DeadlineExceeded) || key != nil {
+		t.Fatalf("DeriveKeyCtx = %x, %v; want context.DeadlineExceeded", key, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("returned after %v, long past the deadline", elapsed)
+	}
+
+	key, err = DeriveKeyCtx(context.Background(), "pw", make([]byte, SaltLen), LegacyKDF, KeyLen, nil)
+	want, _ := DeriveKey("pw", make([]byte, SaltLen), LegacyKDF, KeyLen)
+	if err != nil || !bytes.Equal(key, want) {
+		t.Errorf("without a deadline: %x, %v; want %x", key, err, want)
+	}
+}
+
+func TestDeriveKeyCtxCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	key, err := DeriveKeyCtx(ctx, "pw", make([]byte, SaltLen), KDFParams{ID: KDFPBKDF2, Iterations: 1 << 24}, KeyLen, nil)
+	if !errors.Is(err, context.Canceled) || key != nil {
+		t.Fatalf("DeriveKeyCtx = %x, %v; want context.Canceled", key, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("returned after %v", elapsed)
+	}
+	want, _ := DeriveKey("pw", make([]byte, SaltLen), LegacyKDF, KeyLen)
+	if key, err := DeriveKeyCtx(context.Background(), "pw", make([]byte, SaltLen), LegacyKDF, KeyLen, nil); err != nil || !bytes.Equal(key, want) {
+		t.Errorf("uncancelled: %x, %v; want %x", key, err, want)
+	}
+}
+
+func TestDeriveKeyCtxProgressMatchesPBKDF2(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	for _, n := range []int{1, 2, pbkdf2Segment - 1, pbkdf2Segment, pbkdf2Segment + 1, 5000} {
+		params := KDFParams{ID: KDFPBKDF2, Iterations: n}
+		var calls []float64
+		key, err := DeriveKeyCtx(context.Background(), "pw", salt, params, KeyLen, func(f float64) { calls = append(calls, f) })
+		want := pbkdf2.Key([]byte("pw"), salt, n, KeyLen, sha256.New)
+		if err != nil || !bytes.Equal(key, want) {
+			t.Fatalf("%d iterations: %x, %v; want %x", n, key, err, want)
+		}
+		if len(calls) < 2 || calls[0] != 0 || calls[len(calls)-1] != 1 || !slices.IsSorted(calls) {
+			t.Errorf("%d iterations: progress %v", n, calls)
+		}
+	}
+}
+
+func TestDeriveKeyCtxProgressCancel(t *testing.T) {
+	params := KDFParams{ID: KDFPBKDF2, Iterations: 1 << 24}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var last float64
+	start := time.Now()
+	key, err := DeriveKeyCtx(ctx, "pw", make([]byte, SaltLen), params, KeyLen, func(f float64) {
+		last = f
+		if f > 0 {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) || key != nil {
+		t.Fatalf("DeriveKeyCtx = %x, %v; want context.Canceled", key, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("returned after %v", elapsed)
+	}
+	if last <= 0 || last > 0.01 {
+		t.Errorf("last progress %v, want just past the first segment", last)
+	}
+
+	var calls []float64
+	argon := KDFParams{ID: KDFArgon2id, Time: 1, Memory: 1024, Threads: 1}
+	if _, err := DeriveKeyCtx(context.Background(), "pw", make([]byte, SaltLen), argon, KeyLen, func(f float64) { calls = append(calls, f) }); err != nil || !slices.Equal(calls, []float64{0, 1}) {
+		t.Errorf("argon2id: %v, progress %v; want 0 then 1", err, calls)
+	}
+}
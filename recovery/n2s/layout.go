@@ -0,0 +1,115 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/n2s/layout.go
+
+package n2s
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Layout says where a headerless blobid from another producer keeps its
+// salt and nonce. The n2s layout, salt first and nonce last, needs none;
+// a Layout describes the others, e.g. a nonce stored before the salt.
+type Layout struct {
+	desc string
+	// saltOff, nonceOff and size are byte offsets into the blobid.
+	saltOff, saltLen   int
+	nonceOff, nonceLen int
+	size               int
+}
+
+// Salt lengths a Layout accepts: shorter is too weak to trust, longer is
+// not a salt anyone has used.
+const (
+	minLayoutSalt = 8
+	maxLayoutSalt = 64
+)
+
+// ParseLayout parses a layout descriptor: components from the start of
+// the blobid, each a letter and a byte count. s is the salt and n the
+// nonce, each exactly once; x is unused bytes, e.g. "s16x4n12" for the
+// n2s digest blobids. The nonce must be NonceLen or XNonceLen bytes,
+// which picks ChaCha20-Poly1305 or XChaCha20-Poly1305.
+func ParseLayout(desc string) (*Layout, error) {
+	l := &Layout{desc: desc, saltOff: -1, nonceOff: -1}
+	for i := 0; i < len(desc); {
+		c := desc[i]
+		if c != 's' && c != 'n' && c != 'x' {
+			return nil, fmt.Errorf("layout %q: unknown component %q (want s, n or x)", desc, c)
+		}
+		j := i + 1
+		for j < len(desc) && '0' <= desc[j] && desc[j] <= '9' {
+			j++
+		}
+		n, err := strconv.Atoi(desc[i+1 : j])
+		if err != nil || n <= 0 || n > MaxBlobIDLen {
+			return nil, fmt.Errorf("layout %q: %q needs a byte count", desc, c)
+		}
+		switch c {
+		case 's':
+			if l.saltOff >= 0 {
+				return nil, fmt.Errorf("layout %q: more than one salt", desc)
+			}
+			l.saltOff, l.saltLen = l.size, n
+		case 'n':
+			if l.nonceOff >= 0 {
+				return nil, fmt.Errorf("layout %q: more than one nonce", desc)
+			}
+			l.nonceOff, l.nonceLen = l.size, n
+		}
+		l.size += n
+		i = j
+	}
+	switch {
+	case l.saltOff < 0 || l.nonceOff < 0:
+		return nil, fmt.Errorf("layout %q: needs both a salt (s) and a nonce (n)", desc)
+	case l.saltLen < minLayoutSalt || l.saltLen > maxLayoutSalt:
+		return nil, fmt.Errorf("layout %q: salt must be %d to %d bytes, got %d", desc, minLayoutSalt, maxLayoutSalt, l.saltLen)
+	case l.nonceLen != NonceLen && l.nonceLen != XNonceLen:
+		return nil, fmt.Errorf("layout %q: nonce must be %d or %d bytes, got %d", desc, NonceLen, XNonceLen, l.nonceLen)
+	}
+	return l, nil
+}
+
+// String returns the descriptor l was parsed from.
+func (l *Layout) String() string { return l.desc }
+
+// ParseBlobIDLayout is ParseBlobID for a blobid in layout l, or in the
+// n2s layout when l is nil. A laid-out blobid has no header, so it uses
+// the legacy KDF, and must be exactly as long as l describes.
+func ParseBlobIDLayout(blobid []byte, l *Layout) (*Blob, error) {
+	blobBytes, err := decodeBlobIDHex(blobid)
+	if err != nil {
+		return nil, err
+	}
+	if l == nil {
+		return ParseBlob(blobBytes)
+	}
+	b, err := l.decode(blobBytes)
+	if err != nil {
+		return nil, &FormatError{err}
+	}
+	return b, nil
+}
+
+func (l *Layout) decode(blobBytes []byte) (*Blob, error) {
+	switch {
+	case len(blobBytes) < l.size:
+		return nil, fmt.Errorf("%w: layout %s needs %d bytes, got %d", ErrBlobTooShort, l, l.size, len(blobBytes))
+	case len(blobBytes) > l.size:
+		return nil, fmt.Errorf("%w: layout %s covers %d bytes, blobid has %d", ErrUnsupportedFormat, l, l.size, len(blobBytes))
+	}
+	return &Blob{
+		KDF:    LegacyKDF,
+		Cipher: CipherChaCha20Poly1305,
+		Salt:   blobBytes[l.saltOff : l.saltOff+l.saltLen],
+		Nonce:  blobBytes[l.nonceOff : l.nonceOff+l.nonceLen],
+		raw:    blobBytes,
+	}, nil
+}
@@ -0,0 +1,113 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/n2s/layout_test.go
+
+package n2s
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+func TestParseBlobIDLayoutDefault(t *testing.T) {
+	blobid := []byte("000102030405060708090a0b0c0d0e0f101112131415161718191a1b")
+	want, err := ParseBlobID(blobid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := ParseLayout("s16n12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, layout := range map[string]*Layout{"nil": nil, "s16n12": l} {
+		got, err := ParseBlobIDLayout(blobid, layout)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(got.Salt, want.Salt) || !bytes.Equal(got.Nonce, want.Nonce) || got.KDF != LegacyKDF {
+			t.Errorf("%s: salt %x nonce %x, want %x %x", name, got.Salt, got.Nonce, want.Salt, want.Nonce)
+		}
+	}
+}
+
+func TestParseBlobIDLayoutReordered(t *testing.T) {
+	nonce := make([]byte, XNonceLen)
+	salt := make([]byte, 16)
+	rand.Read(nonce)
+	rand.Read(salt)
+	raw := append(append([]byte{}, nonce...), salt...)
+
+	l, err := ParseLayout("n24s16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := ParseBlobIDLayout([]byte(hex.EncodeToString(raw)), l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(blob.Salt, salt) || !bytes.Equal(blob.Nonce, nonce) {
+		t.Fatalf("salt %x nonce %x, want %x %x", blob.Salt, blob.Nonce, salt, nonce)
+	}
+	if blob.ID() != hex.EncodeToString(raw) || blob.CipherName() != "xchacha20-poly1305" {
+		t.Errorf("id %s cipher %s", blob.ID(), blob.CipherName())
+	}
+
+	// A blob sealed by the sibling's layout opens with the key its salt
+	// derives.
+	key, err := DeriveKey("pw", salt, LegacyKDF, KeyLen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, _ := chacha20poly1305.NewX(key)
+	plaintext, err := Open(blob, key, aead.Seal(nil, nonce, []byte("reordered"), nil), nil)
+	if err != nil || string(plaintext) != "reordered" {
+		t.Fatalf("Open: %q, %v", plaintext, err)
+	}
+
+	// The default layout reads the same bytes as salt first.
+	d, err := ParseBlobID([]byte(hex.EncodeToString(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(d.Salt, salt) {
+		t.Errorf("default layout found the salt at the end")
+	}
+}
+
+func TestParseBlobIDLayoutLength(t *testing.T) {
+	l, err := ParseLayout("n12s24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n, want := range map[int]error{35: ErrBlobTooShort, 37: ErrUnsupportedFormat} {
+		_, err := ParseBlobIDLayout([]byte(hex.EncodeToString(make([]byte, n))), l)
+		var fe *FormatError
+		if !errors.As(err, &fe) || !errors.Is(err, want) {
+			t.Errorf("%d bytes: %v, want %v", n, err, want)
+		}
+	}
+}
+
+func TestParseLayoutInvalid(t *testing.T) {
+	for _, desc := range []string{"", "s16", "n12", "s16n12s16", "s16n12n12", "s16n16", "s4n12", "s16q4n12", "s16n", "16s16n12", "s0n12"} {
+		if _, err := ParseLayout(desc); err == nil {
+			t.Errorf("ParseLayout(%q) accepted", desc)
+		}
+	}
+	l, err := ParseLayout("s16x4n12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.String() != "s16x4n12" || l.size != 32 || l.nonceOff != 20 {
+		t.Errorf("s16x4n12: %+v", l)
+	}
+}
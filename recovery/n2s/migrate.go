@@ -0,0 +1,41 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/n2s/migrate.go
+
+package n2s
+
+// Migrate returns a headered blobid for a legacy blob: a version 2 header
+// spelling out its KDF, then its salt and nonce. A producer digest
+// blobid keeps its whole digest in the header's DIGEST field, so the id
+// it was filed under can still be recovered from the new one. Key, nonce
+// and therefore ciphertext are unchanged, so nothing is re-encrypted; the
+// blob is opened first only to prove password and ciphertext belong to
+// it. An already headered blob comes back as it is, with migrated false.
+func Migrate(blob *Blob, ciphertext, additionalData []byte, password string) (newBlob *Blob, migrated bool, err error) {
+	if blob.Version != 0 {
+		return blob, false, nil
+	}
+	plaintext, err := DecryptBlob(blob, ciphertext, additionalData, password)
+	if err != nil {
+		return nil, false, err
+	}
+	Wipe(plaintext)
+
+	headed := *blob
+	if len(blob.raw) == digestBlobIDLen {
+		headed.Digest = append([]byte(nil), blob.raw...)
+	}
+	header, err := encodeHeaderV2(&headed)
+	if err != nil {
+		return nil, false, err
+	}
+	raw := append(append(header, blob.Salt...), blob.Nonce...)
+	if newBlob, err = ParseBlob(raw); err != nil {
+		return nil, false, err
+	}
+	return newBlob, true, nil
+}
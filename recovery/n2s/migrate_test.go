@@ -0,0 +1,83 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/n2s/migrate_test.go
+
+package n2s
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	plaintext := []byte("legacy")
+	blobid, ciphertext := sealClassic(t, plaintext, "pw")
+	blob, err := ParseBlobID(blobid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Migrate(blob, ciphertext, nil, "wrong"); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("Migrate with wrong password: %v", err)
+	}
+
+	migrated, ok, err := Migrate(blob, ciphertext, nil, "pw")
+	if err != nil || !ok {
+		t.Fatalf("Migrate: %v, migrated %v", err, ok)
+	}
+	if migrated.Version != 2 || migrated.KDF != blob.KDF {
+		t.Errorf("migrated blob: version %d, kdf %+v", migrated.Version, migrated.KDF)
+	}
+	got, err := DecryptBlob(migrated, ciphertext, nil, "pw")
+	if err != nil || !bytes.Equal(got, plaintext) {
+		t.Errorf("decrypt migrated: %q, %v", got, err)
+	}
+
+	again, ok, err := Migrate(migrated, ciphertext, nil, "pw")
+	if err != nil || ok || again.ID() != migrated.ID() {
+		t.Errorf("Migrate of headered blob: %v, migrated %v, id %s", err, ok, again.ID())
+	}
+}
+
+func TestMigrateKeepsProducerDigest(t *testing.T) {
+	plaintext := []byte("digest form")
+	blobid, ciphertext := sealClassic(t, plaintext, "pw")
+	blob, err := ParseBlobID(blobid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blob.Bytes()) != digestBlobIDLen {
+		t.Fatalf("sealClassic blobid has %d bytes, want %d", len(blob.Bytes()), digestBlobIDLen)
+	}
+	migrated, ok, err := Migrate(blob, ciphertext, nil, "pw")
+	if err != nil || !ok {
+		t.Fatalf("Migrate: %v, migrated %v", err, ok)
+	}
+	if !bytes.Equal(migrated.Digest, blob.Bytes()) {
+		t.Errorf("migrated digest %x, want %x", migrated.Digest, blob.Bytes())
+	}
+	reparsed, err := ParseBlobID([]byte(migrated.ID()))
+	if err != nil || !bytes.Equal(reparsed.Digest, blob.Bytes()) {
+		t.Fatalf("reparse migrated: digest %x, %v", reparsed.Digest, err)
+	}
+	if got, err := DecryptBlob(reparsed, ciphertext, nil, "pw"); err != nil || !bytes.Equal(got, plaintext) {
+		t.Errorf("decrypt migrated: %q, %v", got, err)
+	}
+
+	// A digest field whose salt or nonce disagrees with the blob's is not
+	// the digest the blob started as.
+	forged := append([]byte(nil), migrated.Bytes()...)
+	i := bytes.Index(forged, blob.Bytes())
+	forged[i+SaltLen+1] ^= 1 // the unused bytes may be anything
+	if _, err := ParseBlob(forged); err != nil {
+		t.Errorf("digest with other unused bytes: %v", err)
+	}
+	forged[i] ^= 1
+	if _, err := ParseBlob(forged); !errors.Is(err, ErrMalformedHeader) {
+		t.Errorf("digest with another salt: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/n2s/rekey.go
+
+package n2s
+
+import (
+	"fmt"
+	"time"
+)
+
+// Rekey opens a blob with oldPassword and seals its plaintext under
+// newPassword with a fresh salt and nonce, keeping the blob's KDF
+// parameters, cipher, nonce length, chunk size and any stored plaintext
+// hash. A blob that records its creation time gets the time of the rekey
+// instead, since that is when the new ciphertext was sealed. The
+// plaintext only ever exists in memory and is wiped before returning. The
+// new blob is opened once more before it is handed back, so a caller
+// never replaces a blob with one that does not decrypt.
+func Rekey(blob *Blob, ciphertext, additionalData []byte, oldPassword, newPassword string) (blobid, newCiphertext []byte, err error) {
+	return RekeyWith(blob, ciphertext, additionalData, oldPassword, newPassword, RekeyOptions(blob))
+}
+
+// RekeyOptions returns the options Rekey seals the new blob with: those
+// of blob itself, except that a creation time becomes the current time.
+// The old time would claim the new ciphertext is older than it is, and
+// CREATED is authenticated with it.
+func RekeyOptions(blob *Blob) EncryptOptions {
+	opts := EncryptOptions{KDF: blob.KDF, Cipher: blob.Cipher, NonceLen: len(blob.Nonce), ChunkSize: blob.ChunkSize,
+		StoreHash: blob.PlaintextHash != nil}
+	if !blob.Created.IsZero() {
+		opts.Created = time.Now()
+	}
+	return opts
+}
+
+// RekeyWith is Rekey sealing the new blob with opts, e.g. to move it to a
+// stronger KDF at the same time. opts.AdditionalData is ignored: the new
+// blob is bound to additionalData like the old one.
+func RekeyWith(blob *Blob, ciphertext, additionalData []byte, oldPassword, newPassword string, opts EncryptOptions) (blobid, newCiphertext []byte, err error) {
+	plaintext, err := DecryptBlob(blob, ciphertext, additionalData, oldPassword)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer Wipe(plaintext)
+
+	opts.AdditionalData = additionalData
+	blobid, newCiphertext, err = Seal(plaintext, newPassword, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	newBlob, err := ParseBlob(blobid)
+	if err != nil {
+		return nil, nil, err
+	}
+	check, err := DecryptBlob(newBlob, newCiphertext, additionalData, newPassword)
+	if err != nil {
+		return nil, nil, fmt.Errorf("rekeyed blob does not decrypt: %w", err)
+	}
+	Wipe(check)
+	return blobid, newCiphertext, nil
+}
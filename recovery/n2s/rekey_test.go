@@ -0,0 +1,57 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/n2s/rekey_test.go
+
+package n2s
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRekeyWrongOldPassword(t *testing.T) {
+	blobid, ciphertext := sealClassic(t, []byte("x"), "right")
+	blob, _ := ParseBlobID(blobid)
+	if _, _, err := Rekey(blob, ciphertext, nil, "wrong", "new"); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("Rekey with wrong old password: %v", err)
+	}
+}
+
+func TestRekeyStampsCreated(t *testing.T) {
+	old := time.Unix(1e9, 0)
+	blobid, ciphertext, err := Seal([]byte("dated"), "old", EncryptOptions{KDF: KDFParams{ID: KDFPBKDF2, Iterations: 2}, Created: old})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := ParseBlob(blobid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now().Truncate(time.Second)
+	newID, _, err := Rekey(blob, ciphertext, nil, "old", "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rekeyed, err := ParseBlob(newID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rekeyed.Created.Before(before) || rekeyed.Created.After(time.Now()) {
+		t.Errorf("rekeyed Created %v, want the time of the rekey, not %v", rekeyed.Created, old)
+	}
+
+	// A blob with no creation time gets none.
+	blobid, ciphertext = sealClassic(t, []byte("undated"), "old")
+	blob, _ = ParseBlobID(blobid)
+	if newID, _, err = Rekey(blob, ciphertext, nil, "old", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if rekeyed, err = ParseBlob(newID); err != nil || !rekeyed.Created.IsZero() {
+		t.Errorf("rekeyed undated blob: Created %v, %v", rekeyed.Created, err)
+	}
+}
@@ -0,0 +1,360 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/n2s/stream.go
+
+package n2s
+
+import (
+	"bufio"
+	"context"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Chunked blobs split the plaintext into ChunkSize pieces, each sealed on
+// its own so neither side ever holds more than one chunk:
+//
+//	seal(chunk 0) | seal(chunk 1) | ... | seal(final chunk)
+//
+// Every sealed chunk but the last is exactly ChunkSize+16 bytes; the last
+// may be shorter, down to a bare 16-byte tag for an empty plaintext. Chunk
+// i is sealed under the blob nonce XORed with i (uint32, big-endian) in
+// the five bytes before the end and a final flag in the last byte, so a
+// reordered chunk fails with the wrong index and a stream cut at a chunk
+// boundary fails for lack of a final flag.
+const (
+	DefaultChunkSize = 64 << 10
+	// MaxChunkSize bounds the buffer a blobid header can make us allocate.
+	MaxChunkSize = 64 << 20
+)
+
+// chunkNonce returns the nonce for chunk index of a stream.
+func chunkNonce(dst, base []byte, index uint32, final bool) []byte {
+	dst = append(dst[:0], base...)
+	var ctr [5]byte
+	binary.BigEndian.PutUint32(ctr[:4], index)
+	if final {
+		ctr[4] = 1
+	}
+	tail := dst[len(dst)-len(ctr):]
+	for i := range ctr {
+		tail[i] ^= ctr[i]
+	}
+	return dst
+}
+
+// sealChunked reads r to EOF and writes the chunked ciphertext to w.
+func sealChunked(aead cipher.AEAD, baseNonce []byte, chunkSize int, r io.Reader, w io.Writer, additionalData []byte) error {
+	cw := newChunkWriter(aead, baseNonce, chunkSize, w, additionalData)
+	buf := make([]byte, chunkSize)
+	defer Wipe(buf)
+	for {
+		n, err := r.Read(buf)
+		if _, werr := cw.Write(buf[:n]); werr != nil {
+			cw.Close()
+			return werr
+		}
+		if err == io.EOF {
+			return cw.Close()
+		}
+		if err != nil {
+			cw.Close()
+			return fmt.Errorf("reading plaintext: %w", err)
+		}
+	}
+}
+
+// chunkWriter seals what is written to it as a chunked stream. A full
+// chunk is held back until more plaintext arrives, since only Close knows
+// which chunk is final; Close seals that one and wipes the buffer.
+type chunkWriter struct {
+	aead      cipher.AEAD
+	baseNonce []byte
+	nonce     []byte
+	chunkSize int
+	aad       []byte
+	w         io.Writer
+	// buf holds the pending plaintext; its spare capacity takes the tag
+	// when the chunk is sealed in place.
+	buf    []byte
+	index  uint32
+	err    error
+	closed bool
+}
+
+func newChunkWriter(aead cipher.AEAD, baseNonce []byte, chunkSize int, w io.Writer, additionalData []byte) *chunkWriter {
+	return &chunkWriter{
+		aead:      aead,
+		baseNonce: baseNonce,
+		nonce:     make([]byte, 0, len(baseNonce)),
+		chunkSize: chunkSize,
+		aad:       additionalData,
+		w:         w,
+		buf:       make([]byte, 0, chunkSize+aead.Overhead()),
+	}
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	if c.closed {
+		return 0, errors.New("write to closed chunk writer")
+	}
+	written := 0
+	for len(p) > 0 {
+		if c.err != nil {
+			return written, c.err
+		}
+		if len(c.buf) == c.chunkSize {
+			c.flush(false)
+			continue
+		}
+		n := copy(c.buf[len(c.buf):c.chunkSize], p)
+		c.buf = c.buf[:len(c.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, c.err
+}
+
+// flush seals the pending chunk and writes it out, recording any error
+// for every later call.
+func (c *chunkWriter) flush(final bool) {
+	if !final && c.index == math.MaxUint32 {
+		c.err = fmt.Errorf("plaintext exceeds %d chunks", uint64(math.MaxUint32)+1)
+		return
+	}
+	c.nonce = chunkNonce(c.nonce, c.baseNonce, c.index, final)
+	sealed := c.aead.Seal(c.buf[:0], c.nonce, c.buf, c.aad)
+	if _, err := c.w.Write(sealed); err != nil {
+		c.err = fmt.Errorf("writing ciphertext: %w", err)
+		return
+	}
+	c.buf = c.buf[:0]
+	c.index++
+}
+
+// Close seals the final chunk, possibly empty, unless an earlier write
+// failed; either way the buffer is wiped.
+func (c *chunkWriter) Close() error {
+	if c.closed {
+		return c.err
+	}
+	c.closed = true
+	if c.err == nil {
+		c.flush(true)
+	}
+	Wipe(c.buf[:cap(c.buf)])
+	return c.err
+}
+
+// openChunked authenticates and decrypts a chunked ciphertext from r,
+// writing each chunk to w only after its tag checks out. On error, w may
+// already hold the chunks before the failing one.
+func openChunked(ctx context.Context, aead cipher.AEAD, baseNonce []byte, chunkSize int, r io.Reader, w io.Writer, additionalData []byte) error {
+	cr := newChunkReader(aead, baseNonce, chunkSize, r, additionalData)
+	defer cr.wipe()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		plaintext, final, err := cr.next()
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(plaintext); err != nil {
+			return fmt.Errorf("writing plaintext: %w", err)
+		}
+		if final {
+			return nil
+		}
+	}
+}
+
+// chunkReader opens a chunked stream one chunk at a time.
+type chunkReader struct {
+	aead      cipher.AEAD
+	baseNonce []byte
+	nonce     []byte
+	frameSize int
+	aad       []byte
+	br        *bufio.Reader
+	buf       []byte
+	// Open into a separate buffer: a failed in-place Open zeroes the
+	// ciphertext, which the truncation check in next still needs.
+	out   []byte
+	index uint32
+}
+
+func newChunkReader(aead cipher.AEAD, baseNonce []byte, chunkSize int, r io.Reader, additionalData []byte) *chunkReader {
+	frameSize := chunkSize + aead.Overhead()
+	return &chunkReader{
+		aead:      aead,
+		baseNonce: baseNonce,
+		nonce:     make([]byte, 0, len(baseNonce)),
+		frameSize: frameSize,
+		aad:       additionalData,
+		br:        bufio.NewReaderSize(r, frameSize+1),
+		buf:       make([]byte, frameSize),
+		out:       make([]byte, 0, chunkSize),
+	}
+}
+
+// next authenticates the next chunk and returns its plaintext, valid until
+// the following call, and whether it was the final chunk. After the
+// final chunk it returns io.EOF.
+func (c *chunkReader) next() (plaintext []byte, final bool, err error) {
+	n, final, err := c.read()
+	if err != nil {
+		return nil, false, err
+	}
+	return c.open(n, final)
+}
+
+// read reads the next sealed chunk into c.buf without opening it and
+// returns its length and whether it is the last in the stream.
+func (c *chunkReader) read() (n int, final bool, err error) {
+	index := c.index
+	n, err = io.ReadFull(c.br, c.buf)
+	switch {
+	case err == io.EOF && index == 0:
+		return 0, false, fmt.Errorf("%w: chunked ciphertext is empty", ErrTruncated)
+	case err != nil && err != io.ErrUnexpectedEOF:
+		return 0, false, err
+	}
+	final = err != nil
+	if !final {
+		if _, err := c.br.Peek(1); err == io.EOF {
+			final = true
+		} else if err != nil {
+			return 0, false, err
+		}
+	}
+	if n < c.aead.Overhead() {
+		return 0, false, fmt.Errorf("%w: chunk %d has %d bytes, need at least %d", ErrTruncated, index, n, c.aead.Overhead())
+	}
+	if !final && index == math.MaxUint32 {
+		return 0, false, fmt.Errorf("ciphertext exceeds %d chunks", uint64(math.MaxUint32)+1)
+	}
+	return n, final, nil
+}
+
+// open authenticates the n-byte chunk read has left in c.buf.
+func (c *chunkReader) open(n int, final bool) (plaintext []byte, _ bool, err error) {
+	index := c.index
+	c.nonce = chunkNonce(c.nonce, c.baseNonce, index, final)
+	plaintext, err = c.aead.Open(c.out[:0], c.nonce, c.buf[:n], c.aad)
+	if err != nil {
+		// A full chunk that only opens as non-final means the stream
+		// was cut right after it.
+		if final && n == c.frameSize {
+			c.nonce = chunkNonce(c.nonce, c.baseNonce, index, false)
+			if _, err := c.aead.Open(c.out[:0], c.nonce, c.buf[:n], c.aad); err == nil {
+				return nil, false, fmt.Errorf("%w: stream ends after chunk %d without a final chunk", ErrTruncated, index)
+			}
+		}
+		return nil, false, fmt.Errorf("%w: chunk %d", ErrAuthFailed, index)
+	}
+	c.index++
+	return plaintext, final, nil
+}
+
+func (c *chunkReader) wipe() {
+	Wipe(c.out[:cap(c.out)])
+}
+
+// ErrOutOfRange reports an OpenRange request reaching past the end of
+// the plaintext.
+var ErrOutOfRange = errors.New("range is outside the plaintext")
+
+// OpenRange streams plaintext bytes [offset, offset+length) of a chunked
+// blob from r to w, or from offset to the end when length is negative.
+// Chunks before the range are read past without being opened; each chunk
+// it covers is authenticated before any of its bytes are written, and
+// reading stops after the last one, so the rest of the stream is never
+// checked. A range reaching past the end fails with ErrOutOfRange once
+// the final chunk has authenticated; any earlier part of the range has
+// been written by then. An empty range is read up to the chunk holding
+// offset all the same, so an offset past the end fails too.
+func OpenRange(blob *Blob, key []byte, r io.Reader, w io.Writer, additionalData []byte, offset, length int64) error {
+	return OpenRangeContext(context.Background(), blob, key, r, w, additionalData, offset, length)
+}
+
+// OpenRangeContext is OpenRange stopping with ctx's error between chunks.
+func OpenRangeContext(ctx context.Context, blob *Blob, key []byte, r io.Reader, w io.Writer, additionalData []byte, offset, length int64) error {
+	if blob.ChunkSize == 0 {
+		return errors.New("blob is not chunked")
+	}
+	if offset < 0 || length > math.MaxInt64-offset {
+		return fmt.Errorf("%w: offset %d, length %d", ErrOutOfRange, offset, length)
+	}
+	end := offset + length
+	if length < 0 {
+		end = -1
+	}
+	aead, err := NewAEAD(key, blob.Cipher, len(blob.Nonce))
+	if err != nil {
+		return fmt.Errorf("creating cipher: %w", err)
+	}
+	cr := newChunkReader(aead, blob.Nonce, blob.ChunkSize, r, blob.additionalData(additionalData))
+	defer cr.wipe()
+	chunkSize := int64(blob.ChunkSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		start := int64(cr.index) * chunkSize
+		n, final, err := cr.read()
+		if err != nil {
+			return err
+		}
+		if start+chunkSize <= offset && !final {
+			cr.index++
+			continue
+		}
+		plaintext, _, err := cr.open(n, final)
+		if err != nil {
+			return err
+		}
+		stop := start + int64(len(plaintext))
+		if final && (offset > stop || end > stop) {
+			if end < 0 {
+				return fmt.Errorf("%w: offset %d, plaintext has %d bytes", ErrOutOfRange, offset, stop)
+			}
+			return fmt.Errorf("%w: bytes [%d, %d) requested, plaintext has %d", ErrOutOfRange, offset, end, stop)
+		}
+		if end >= 0 {
+			stop = min(stop, end)
+		}
+		if _, err := w.Write(plaintext[max(offset-start, 0) : stop-start]); err != nil {
+			return fmt.Errorf("writing plaintext: %w", err)
+		}
+		if final || stop == end {
+			return nil
+		}
+	}
+}
+
+// OpenStream streams the plaintext of a chunked blob from r to w.
+func OpenStream(blob *Blob, key []byte, r io.Reader, w io.Writer, additionalData []byte) error {
+	return OpenStreamContext(context.Background(), blob, key, r, w, additionalData)
+}
+
+// OpenStreamContext is OpenStream stopping with ctx's error, between
+// chunks, once ctx is done. Chunks already written stay written.
+func OpenStreamContext(ctx context.Context, blob *Blob, key []byte, r io.Reader, w io.Writer, additionalData []byte) error {
+	if blob.ChunkSize == 0 {
+		return errors.New("blob is not chunked")
+	}
+	aead, err := NewAEAD(key, blob.Cipher, len(blob.Nonce))
+	if err != nil {
+		return fmt.Errorf("creating cipher: %w", err)
+	}
+	return openChunked(ctx, aead, blob.Nonce, blob.ChunkSize, r, w, blob.additionalData(additionalData))
+}
@@ -0,0 +1,128 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/n2s/stream_test.go
+
+package n2s
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+const testChunk = 16
+
+func sealChunkedBlob(t *testing.T, plaintext []byte) (blobid, ciphertext []byte) {
+	t.Helper()
+	raw, ct, err := Seal(plaintext, "pw", EncryptOptions{ChunkSize: testChunk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw, ct
+}
+
+func TestChunkedRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 1, testChunk - 1, testChunk, 3 * testChunk, 5*testChunk + 7} {
+		plaintext := bytes.Repeat([]byte{'x'}, n)
+		raw, ct := sealChunkedBlob(t, plaintext)
+		blob, err := ParseBlob(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if blob.ChunkSize != testChunk {
+			t.Fatalf("chunk size %d, want %d", blob.ChunkSize, testChunk)
+		}
+		chunks := max(1, (n+testChunk-1)/testChunk)
+		if want := n + chunks*16; len(ct) != want {
+			t.Errorf("%d bytes: ciphertext %d bytes, want %d", n, len(ct), want)
+		}
+		got, err := DecryptBlob(blob, ct, nil, "pw")
+		if err != nil || !bytes.Equal(got, plaintext) {
+			t.Errorf("%d bytes: got %d bytes, %v", n, len(got), err)
+		}
+	}
+}
+
+func TestChunkedTampering(t *testing.T) {
+	raw, ct := sealChunkedBlob(t, []byte(strings.Repeat("0123456789abcdef", 4)))
+	blob, _ := ParseBlob(raw)
+	const frame = testChunk + 16
+
+	reordered := bytes.Clone(ct)
+	copy(reordered[:frame], ct[frame:2*frame])
+	copy(reordered[frame:2*frame], ct[:frame])
+
+	cases := []struct {
+		name       string
+		ciphertext []byte
+		want       error
+	}{
+		{"reordered", reordered, ErrAuthFailed},
+		{"dropped last chunk", ct[:3*frame], ErrTruncated},
+		{"cut mid chunk", ct[:3*frame+5], ErrTruncated},
+		{"empty", nil, ErrTruncated},
+	}
+	for _, tc := range cases {
+		if _, err := DecryptBlob(blob, tc.ciphertext, nil, "pw"); !errors.Is(err, tc.want) {
+			t.Errorf("%s: %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestOpenRange(t *testing.T) {
+	plaintext := make([]byte, 5*testChunk+7)
+	for i := range plaintext {
+		plaintext[i] = byte(i)
+	}
+	raw, ct := sealChunkedBlob(t, plaintext)
+	blob, _ := ParseBlob(raw)
+	key, err := DeriveKey("pw", blob.Salt, blob.KDF, blob.Cipher.KeySize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := Open(blob, key, ct, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range [][2]int64{{20, 30}, {16, 16}, {3, 2}, {0, 87}, {81, 6}, {40, -1}, {87, -1}, {40, 0}, {87, 0}} {
+		var out bytes.Buffer
+		if err := OpenRange(blob, key, bytes.NewReader(ct), &out, nil, r[0], r[1]); err != nil {
+			t.Errorf("range %v: %v", r, err)
+			continue
+		}
+		end := r[0] + r[1]
+		if r[1] < 0 {
+			end = int64(len(full))
+		}
+		if want := full[r[0]:end]; !bytes.Equal(out.Bytes(), want) {
+			t.Errorf("range %v: %x, want %x", r, out.Bytes(), want)
+		}
+	}
+
+	// Only the chunks a range covers are opened: damage elsewhere goes
+	// unnoticed, damage inside fails before anything of that chunk is
+	// written.
+	const frame = testChunk + 16
+	damaged := bytes.Clone(ct)
+	damaged[frame] ^= 1
+	var out bytes.Buffer
+	if err := OpenRange(blob, key, bytes.NewReader(damaged), &out, nil, 40, 10); err != nil || !bytes.Equal(out.Bytes(), full[40:50]) {
+		t.Errorf("range after a damaged chunk: %x, %v", out.Bytes(), err)
+	}
+	out.Reset()
+	if err := OpenRange(blob, key, bytes.NewReader(damaged), &out, nil, 10, 10); !errors.Is(err, ErrAuthFailed) || !bytes.Equal(out.Bytes(), full[10:16]) {
+		t.Errorf("range over a damaged chunk: wrote %x, %v", out.Bytes(), err)
+	}
+
+	for _, r := range [][2]int64{{80, 8}, {88, -1}, {200, 1}, {-1, 4}, {88, 0}, {200, 0}} {
+		if err := OpenRange(blob, key, bytes.NewReader(ct), io.Discard, nil, r[0], r[1]); !errors.Is(err, ErrOutOfRange) {
+			t.Errorf("range %v: %v, want ErrOutOfRange", r, err)
+		}
+	}
+}
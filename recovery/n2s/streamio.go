@@ -0,0 +1,181 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/n2s/streamio.go
+
+package n2s
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// NewDecryptingReader returns a reader of the plaintext of ciphertext,
+// the blob sealed under blobid (hex) and password without associated
+// data. The key is derived before it returns.
+//
+// A chunked blob streams: each Read opens at most one more chunk, so a
+// chunk's plaintext is only returned once its tag has checked out, and a
+// tampered or truncated chunk fails the Read that reaches it with
+// ErrAuthFailed or ErrTruncated, after every chunk before it has been
+// read. A single-shot blob has one tag over everything, so its
+// ciphertext is read in full on the first Read and must fit in memory.
+// Either way, plaintext buffers are wiped once the reader reaches EOF or
+// fails.
+func NewDecryptingReader(ciphertext io.Reader, blobid []byte, password string) (io.Reader, error) {
+	blob, err := ParseBlobID(blobid)
+	if err != nil {
+		return nil, err
+	}
+	if blob.WrappedKey != nil {
+		return nil, ErrWrappedKey
+	}
+	key, err := DeriveKey(password, blob.Salt, blob.KDF, blob.Cipher.KeySize())
+	if err != nil {
+		return nil, err
+	}
+	if blob.ChunkSize == 0 {
+		return &singleShotReader{blob: blob, key: key, r: ciphertext}, nil
+	}
+	// The AEAD keeps its own copy of the key.
+	defer Wipe(key)
+	aead, err := NewAEAD(key, blob.Cipher, len(blob.Nonce))
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+	return &decryptingReader{cr: newChunkReader(aead, blob.Nonce, blob.ChunkSize, ciphertext, blob.additionalData(nil))}, nil
+}
+
+type decryptingReader struct {
+	cr      *chunkReader
+	pending []byte
+	final   bool
+	err     error
+}
+
+func (d *decryptingReader) Read(b []byte) (int, error) {
+	for len(d.pending) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.final {
+			d.fail(io.EOF)
+			continue
+		}
+		plaintext, final, err := d.cr.next()
+		if err != nil {
+			d.fail(err)
+			continue
+		}
+		d.pending, d.final = plaintext, final
+	}
+	n := copy(b, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
+
+// fail makes err sticky and wipes the chunk buffer.
+func (d *decryptingReader) fail(err error) {
+	d.err = err
+	d.cr.wipe()
+}
+
+// singleShotReader opens a classic blob on first Read.
+type singleShotReader struct {
+	blob      *Blob
+	key       []byte
+	r         io.Reader
+	plaintext []byte
+	off       int
+	err       error
+}
+
+func (s *singleShotReader) Read(b []byte) (int, error) {
+	if s.key != nil {
+		ciphertext, err := io.ReadAll(s.r)
+		if err == nil {
+			s.plaintext, err = Open(s.blob, s.key, ciphertext, nil)
+		}
+		Wipe(s.key)
+		s.key, s.err = nil, err
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+	n := copy(b, s.plaintext[s.off:])
+	s.off += n
+	if s.off == len(s.plaintext) {
+		Wipe(s.plaintext)
+		s.err = io.EOF
+	}
+	return n, nil
+}
+
+// NewEncryptingWriter starts a blob sealed to ciphertext under password
+// and returns its raw blobid with a writer for the plaintext. Close must
+// be called to finish the blob; it wipes the key.
+//
+// With opts.ChunkSize set, each chunk is sealed and written as soon as
+// the next one begins, so memory stays at one chunk. Without it the blob
+// is single-shot: the plaintext is buffered and sealed by Close.
+// opts.StoreHash is not supported, since the blobid is returned before
+// the plaintext exists.
+func NewEncryptingWriter(ciphertext io.Writer, password string, opts EncryptOptions) (blobid []byte, w io.WriteCloser, err error) {
+	if opts.StoreHash {
+		return nil, nil, errors.New("a stored plaintext hash needs the whole plaintext before the blobid; use Seal")
+	}
+	s, err := NewSealer(password, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.chunkSize == 0 {
+		return s.blobid, &singleShotWriter{s: s, w: ciphertext}, nil
+	}
+	return s.blobid, &encryptingWriter{s: s, cw: newChunkWriter(s.aead, s.nonce, s.chunkSize, ciphertext, s.aad)}, nil
+}
+
+type encryptingWriter struct {
+	s  *Sealer
+	cw *chunkWriter
+}
+
+func (e *encryptingWriter) Write(p []byte) (int, error) { return e.cw.Write(p) }
+
+func (e *encryptingWriter) Close() error {
+	defer e.s.Close()
+	return e.cw.Close()
+}
+
+type singleShotWriter struct {
+	s      *Sealer
+	w      io.Writer
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (s *singleShotWriter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errors.New("write to closed encrypting writer")
+	}
+	return s.buf.Write(p)
+}
+
+func (s *singleShotWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	defer s.s.Close()
+	plaintext := s.buf.Bytes()
+	sealed := s.s.aead.Seal(nil, s.s.nonce, plaintext, s.s.aad)
+	Wipe(plaintext[:cap(plaintext)])
+	if _, err := s.w.Write(sealed); err != nil {
+		return fmt.Errorf("writing ciphertext: %w", err)
+	}
+	return nil
+}
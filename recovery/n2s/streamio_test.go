@@ -0,0 +1,100 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/n2s/streamio_test.go
+
+package n2s
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// writeInPieces writes p to w in writes of every size from 1 up, so
+// chunk boundaries fall inside, at the start and at the end of a write.
+func writeInPieces(t *testing.T, w io.Writer, p []byte) {
+	t.Helper()
+	for size := 1; len(p) > 0; size++ {
+		n := min(size, len(p))
+		if _, err := w.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+}
+
+func TestEncryptingWriterDecryptingReader(t *testing.T) {
+	for _, chunkSize := range []int{0, testChunk} {
+		for _, n := range []int{0, 1, testChunk, 5*testChunk + 7} {
+			plaintext := bytes.Repeat([]byte("abcdefg"), n)[:n]
+			var ct bytes.Buffer
+			raw, w, err := NewEncryptingWriter(&ct, "pw", EncryptOptions{ChunkSize: chunkSize})
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeInPieces(t, w, plaintext)
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			blob, _ := ParseBlob(raw)
+			if got, err := DecryptBlob(blob, ct.Bytes(), nil, "pw"); err != nil || !bytes.Equal(got, plaintext) {
+				t.Errorf("chunk %d, %d bytes: DecryptBlob %q, %v", chunkSize, n, got, err)
+			}
+
+			blobid := []byte(hex.EncodeToString(raw))
+			r, err := NewDecryptingReader(iotest.OneByteReader(bytes.NewReader(ct.Bytes())), blobid, "pw")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := iotest.TestReader(r, plaintext); err != nil {
+				t.Errorf("chunk %d, %d bytes: %v", chunkSize, n, err)
+			}
+		}
+	}
+}
+
+func TestDecryptingReaderStopsAtBadChunk(t *testing.T) {
+	plaintext := bytes.Repeat([]byte("0123456789abcdef"), 4)
+	raw, ct := sealChunkedBlob(t, plaintext)
+	ct[2*(testChunk+16)] ^= 0x01 // first byte of chunk 2
+
+	r, err := NewDecryptingReader(iotest.HalfReader(bytes.NewReader(ct)), []byte(hex.EncodeToString(raw)), "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []byte
+	buf := make([]byte, 5)
+	for {
+		n, err := r.Read(buf)
+		got = append(got, buf[:n]...)
+		if err != nil {
+			if !errors.Is(err, ErrAuthFailed) {
+				t.Errorf("Read error %v, want ErrAuthFailed", err)
+			}
+			break
+		}
+	}
+	if !bytes.Equal(got, plaintext[:2*testChunk]) {
+		t.Errorf("read %q before failing, want chunks 0 and 1", got)
+	}
+	if _, err := r.Read(buf); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("Read after failure: %v", err)
+	}
+
+	if _, err := NewDecryptingReader(bytes.NewReader(ct), []byte(hex.EncodeToString(raw)), "wrong"); err != nil {
+		t.Fatalf("a wrong password is only found by reading: %v", err)
+	}
+}
+
+func TestNewEncryptingWriterRejectsStoreHash(t *testing.T) {
+	if _, _, err := NewEncryptingWriter(io.Discard, "pw", EncryptOptions{StoreHash: true}); err == nil {
+		t.Error("StoreHash accepted")
+	}
+}
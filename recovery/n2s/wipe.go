@@ -0,0 +1,24 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/n2s/wipe.go
+
+package n2s
+
+import "runtime"
+
+// Wipe zeroes b. The KeepAlive keeps the compiler from treating the stores
+// as dead when b is not read again.
+//
+// This is best effort: the garbage collector may already have copied the
+// bytes elsewhere, and ciphers keep their own copy of the key, so wiping
+// only shortens how long the secret sits in memory we control.
+func Wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+	runtime.KeepAlive(b)
+}
@@ -0,0 +1,27 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/n2s/wipe_test.go
+
+package n2s
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWipe(t *testing.T) {
+	secret := []byte("derived key material")
+	alias := secret[4:9]
+	Wipe(secret)
+	if !bytes.Equal(secret, make([]byte, len(secret))) {
+		t.Errorf("wipe left %x", secret)
+	}
+	if !bytes.Equal(alias, make([]byte, len(alias))) {
+		t.Errorf("aliased slice still holds %x", alias)
+	}
+	Wipe(nil)
+}
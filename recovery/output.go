@@ -0,0 +1,239 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/output.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// atomicFile is written under a temporary name in the destination
+// directory and renamed into place by Commit, so readers never see a
+// partially written plaintext. os.CreateTemp opens it 0600, which the
+// umask can only tighten.
+type atomicFile struct {
+	*os.File
+	path string
+	done bool
+}
+
+func createAtomic(path string) (*atomicFile, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating output: %w", err)
+	}
+	return &atomicFile{File: f, path: path}, nil
+}
+
+// Commit flushes the temp file and renames it over path.
+func (a *atomicFile) Commit() error {
+	a.done = true
+	if err := a.Sync(); err != nil {
+		a.Close()
+		os.Remove(a.Name())
+		return fmt.Errorf("writing output: %w", err)
+	}
+	if err := a.Close(); err != nil {
+		os.Remove(a.Name())
+		return fmt.Errorf("writing output: %w", err)
+	}
+	if err := os.Rename(a.Name(), a.path); err != nil {
+		os.Remove(a.Name())
+		return fmt.Errorf("writing output: %w", err)
+	}
+	return nil
+}
+
+// Abort discards the temp file; it is a no-op after Commit, so callers can
+// defer it unconditionally.
+func (a *atomicFile) Abort() {
+	if a.done {
+		return
+	}
+	a.done = true
+	a.Close()
+	os.Remove(a.Name())
+}
+
+// writeAtomic writes data to path through an atomicFile.
+func writeAtomic(path string, data []byte) error {
+	a, err := createAtomic(path)
+	if err != nil {
+		return err
+	}
+	defer a.Abort()
+	if _, err := a.Write(data); err != nil {
+		return fmt.Errorf("writing output: %w", err)
+	}
+	return a.Commit()
+}
+
+// teeFile is the -tee copy of the plaintext, an atomicFile written after
+// stdout through an io.MultiWriter. Stdout cannot be taken back, so its
+// errors say how many bytes stdout had already received.
+type teeFile struct {
+	*atomicFile
+	stdout *countingWriter
+}
+
+// createTee returns a writer sending everything to stdout and then to
+// path, and the file to Commit or Abort.
+func createTee(path string, stdout io.Writer) (io.Writer, *teeFile, error) {
+	f, err := createAtomic(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("-tee %s: %w", path, err)
+	}
+	t := &teeFile{atomicFile: f, stdout: &countingWriter{w: stdout}}
+	return io.MultiWriter(t.stdout, t), t, nil
+}
+
+func (t *teeFile) Write(p []byte) (int, error) {
+	n, err := t.atomicFile.Write(p)
+	if err != nil {
+		return n, t.failed(fmt.Errorf("writing output: %w", err))
+	}
+	return n, nil
+}
+
+func (t *teeFile) Commit() error {
+	if err := t.atomicFile.Commit(); err != nil {
+		return t.failed(err)
+	}
+	return nil
+}
+
+func (t *teeFile) failed(err error) error {
+	return fmt.Errorf("-tee %s: %w; stdout already received %d bytes, which cannot be rolled back", t.path, err, t.stdout.n)
+}
+
+// The -out-encoding choices. Hex and base64 are text, so they skip the
+// binary check on a terminal.
+const (
+	encodingRaw    = "raw"
+	encodingHex    = "hex"
+	encodingBase64 = "base64"
+)
+
+func parseOutEncoding(s string) (string, error) {
+	switch s {
+	case encodingRaw, encodingHex, encodingBase64:
+		return s, nil
+	}
+	return "", usageErrorf("-out-encoding %q: want raw, hex or base64", s)
+}
+
+// encodedWriter writes hex or standard base64 of what it is given to w.
+// Close flushes the last base64 group and adds the trailing newline, if
+// any; it does not close w.
+type encodedWriter struct {
+	enc     io.Writer
+	w       io.Writer
+	newline bool
+}
+
+// newEncodedWriter returns w itself for raw output.
+func newEncodedWriter(w io.Writer, encoding string, newline bool) io.Writer {
+	switch encoding {
+	case encodingHex:
+		return &encodedWriter{hex.NewEncoder(w), w, newline}
+	case encodingBase64:
+		return &encodedWriter{base64.NewEncoder(base64.StdEncoding, w), w, newline}
+	}
+	return w
+}
+
+func (e *encodedWriter) Write(p []byte) (int, error) { return e.enc.Write(p) }
+
+func (e *encodedWriter) Close() error {
+	if c, ok := e.enc.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			return err
+		}
+	}
+	if e.newline {
+		_, err := io.WriteString(e.w, "\n")
+		return err
+	}
+	return nil
+}
+
+// encodeOutput is encodedWriter for a plaintext already in memory.
+func encodeOutput(data []byte, encoding string, newline bool) []byte {
+	var out []byte
+	switch encoding {
+	case encodingHex:
+		out = hex.AppendEncode(make([]byte, 0, hex.EncodedLen(len(data))+1), data)
+	case encodingBase64:
+		out = base64.StdEncoding.AppendEncode(make([]byte, 0, base64.StdEncoding.EncodedLen(len(data))+1), data)
+	default:
+		return data
+	}
+	if newline {
+		out = append(out, '\n')
+	}
+	return out
+}
+
+// isTerminal reports whether w is an interactive terminal. Writers other
+// than *os.File may answer for themselves, which is how tests fake a TTY.
+func isTerminal(w io.Writer) bool {
+	switch w := w.(type) {
+	case interface{ IsTerminal() bool }:
+		return w.IsTerminal()
+	case *os.File:
+		return term.IsTerminal(int(w.Fd()))
+	}
+	return false
+}
+
+// errBinaryToTerminal is returned instead of dumping binary plaintext,
+// whose control sequences can wedge a terminal, onto a TTY.
+var errBinaryToTerminal = usageErrorf("plaintext looks binary and stdout is a terminal; write it with -out, pipe it, or pass -force-binary")
+
+// binaryGuard refuses a first write that is not text: invalid UTF-8 or
+// containing NUL bytes. Later writes of a stream pass through, so a
+// chunked plaintext is judged by its first chunk.
+type binaryGuard struct {
+	w       io.Writer
+	checked bool
+}
+
+func (g *binaryGuard) Write(p []byte) (int, error) {
+	if !g.checked {
+		g.checked = true
+		if looksBinary(p) {
+			return 0, errBinaryToTerminal
+		}
+	}
+	return g.w.Write(p)
+}
+
+func looksBinary(p []byte) bool {
+	if bytes.IndexByte(p, 0) >= 0 {
+		return true
+	}
+	// A chunk may end partway through a multi-byte character.
+	for i := len(p) - 1; i >= 0 && i > len(p)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			if !utf8.FullRune(p[i:]) {
+				p = p[:i]
+			}
+			break
+		}
+	}
+	return !utf8.Valid(p)
+}
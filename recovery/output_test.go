@@ -0,0 +1,249 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/output_test.go
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecryptOutWritesFile(t *testing.T) {
+	blobid, ciphertext := sealClassic(t, []byte("to disk"), "pw")
+	dir := t.TempDir()
+	target := filepath.Join(dir, "note.txt")
+
+	var out, errOut bytes.Buffer
+	code := run([]string{"-out", target, string(blobid), "-", base64.StdEncoding.EncodeToString(ciphertext)},
+		strings.NewReader("pw"), &out, &errOut)
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, errOut.String())
+	}
+	if out.Len() != 0 {
+		t.Errorf("stdout %q, want empty with -out", out.String())
+	}
+	got, err := os.ReadFile(target)
+	if err != nil || string(got) != "to disk" {
+		t.Fatalf("target: %q, %v", got, err)
+	}
+	info, _ := os.Stat(target)
+	if perm := info.Mode().Perm(); perm&0o077 != 0 {
+		t.Errorf("target mode %o, want no group/other access", perm)
+	}
+	assertOnlyFiles(t, dir, "note.txt")
+}
+
+func TestDecryptOutNoFileOnFailure(t *testing.T) {
+	blobid, ciphertext := sealClassic(t, []byte("secret"), "pw")
+	dir := t.TempDir()
+	target := filepath.Join(dir, "note.txt")
+
+	var out, errOut bytes.Buffer
+	code := run([]string{"-out", target, string(blobid), "-", base64.StdEncoding.EncodeToString(ciphertext)},
+		strings.NewReader("wrong"), &out, &errOut)
+	if code == 0 {
+		t.Fatal("wrong password exited 0")
+	}
+	assertOnlyFiles(t, dir)
+}
+
+func TestAtomicFileAbortRemovesTemp(t *testing.T) {
+	dir := t.TempDir()
+	a, err := createAtomic(filepath.Join(dir, "partial"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Write([]byte("half a note"))
+	a.Abort()
+	assertOnlyFiles(t, dir)
+}
+
+func TestDecryptTee(t *testing.T) {
+	dir := t.TempDir()
+	pwFile := writePasswordFile(t, "pw")
+	blobid, ciphertext := sealClassic(t, []byte("both ways"), "pw")
+	chunked := make([]byte, 10000)
+	rand.Read(chunked)
+	blobFile := filepath.Join(dir, "big.n2s")
+	var out, errOut bytes.Buffer
+	if code := run([]string{"encrypt", "-allow-weak-password", "-chunk-size", "4096", "-blobfile", blobFile, "-password-file", pwFile}, bytes.NewReader(chunked), &out, &errOut); code != 0 {
+		t.Fatalf("encrypt: exit %d: %s", code, errOut.String())
+	}
+
+	for name, args := range map[string][]string{
+		"classic": {string(blobid), "pw", base64.StdEncoding.EncodeToString(ciphertext)},
+		"chunked": {"-force-binary", "-blobfile", blobFile, "-password-file", pwFile},
+	} {
+		target := filepath.Join(dir, name+".out")
+		out.Reset()
+		errOut.Reset()
+		if code := run(append([]string{"-tee", target}, args...), nil, &out, &errOut); code != 0 {
+			t.Fatalf("%s: exit %d: %s", name, code, errOut.String())
+		}
+		got, err := os.ReadFile(target)
+		if err != nil || !bytes.Equal(got, out.Bytes()) || out.Len() == 0 {
+			t.Errorf("%s: file %d bytes (%v), stdout %d bytes; want identical", name, len(got), err, out.Len())
+		}
+	}
+
+	if code := run([]string{"-tee", filepath.Join(dir, "x"), "-out", filepath.Join(dir, "y"), string(blobid), "pw", base64.StdEncoding.EncodeToString(ciphertext)}, nil, &out, &errOut); code != exitUsage {
+		t.Errorf("-tee with -out: exit %d, want %d", code, exitUsage)
+	}
+}
+
+func TestDecryptOutEncoding(t *testing.T) {
+	dir := t.TempDir()
+	plaintext := []byte("hi\x00\xff")
+	blobid, ciphertext := sealClassic(t, plaintext, "pw")
+	classic := []string{string(blobid), "pw", base64.StdEncoding.EncodeToString(ciphertext)}
+
+	pwFile := writePasswordFile(t, "pw")
+	long := make([]byte, 10000)
+	rand.Read(long)
+	blobFile := filepath.Join(dir, "big.n2s")
+	var out, errOut bytes.Buffer
+	if code := run([]string{"encrypt", "-allow-weak-password", "-chunk-size", "4096", "-blobfile", blobFile, "-password-file", pwFile}, bytes.NewReader(long), &out, &errOut); code != 0 {
+		t.Fatalf("encrypt: exit %d: %s", code, errOut.String())
+	}
+	chunked := []string{"-blobfile", blobFile, "-password-file", pwFile}
+
+	target := filepath.Join(dir, "out")
+	b64Long := base64.StdEncoding.EncodeToString(long)
+	cases := []struct {
+		name  string
+		flags []string
+		args  []string
+		// want is stdout and file the -out or -tee file, if any.
+		want, file string
+	}{
+		{"hex", []string{"-out-encoding", "hex"}, classic, "686900ff\n", ""},
+		{"base64", []string{"-out-encoding", "base64"}, classic, "aGkA/w==\n", ""},
+		{"no newline", []string{"-out-encoding", "base64", "-no-newline"}, classic, "aGkA/w==", ""},
+		{"raw", nil, classic, string(plaintext), ""},
+		{"chunked hex", []string{"-out-encoding", "hex"}, chunked, hex.EncodeToString(long) + "\n", ""},
+		{"chunked base64", []string{"-out-encoding", "base64", "-no-newline"}, chunked, b64Long, ""},
+		{"out", []string{"-out-encoding", "hex", "-out", target}, classic, "", "686900ff\n"},
+		{"tee", []string{"-out-encoding", "base64", "-tee", target}, chunked, b64Long + "\n", b64Long + "\n"},
+	}
+	for _, c := range cases {
+		errOut.Reset()
+		os.Remove(target)
+		// A terminal stdout still takes hex and base64: they are text.
+		tty := &ttyBuffer{tty: c.name != "raw"}
+		if code := run(append(c.flags, c.args...), nil, tty, &errOut); code != 0 {
+			t.Fatalf("%s: exit %d: %s", c.name, code, errOut.String())
+		}
+		if tty.String() != c.want {
+			t.Errorf("%s: stdout %.40q, want %.40q", c.name, tty.String(), c.want)
+		}
+		if c.file != "" {
+			if got, err := os.ReadFile(target); err != nil || string(got) != c.file {
+				t.Errorf("%s: file %.40q, %v; want %.40q", c.name, got, err, c.file)
+			}
+		}
+	}
+
+	for _, flags := range [][]string{{"-out-encoding", "b32"}, {"-no-newline"}, {"-out-encoding", "hex", "-verify"}} {
+		if code := run(append(flags, classic...), nil, &out, &errOut); code != exitUsage {
+			t.Errorf("%q: exit %d, want %d", flags, code, exitUsage)
+		}
+	}
+}
+
+func TestTeeFailureNamesStdoutBytes(t *testing.T) {
+	var stdout bytes.Buffer
+	w, f, err := createTee(filepath.Join(t.TempDir(), "copy"), &stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Abort()
+	w.Write([]byte("first "))
+	f.Close()
+	_, err = w.Write([]byte("second"))
+	if err == nil || !strings.Contains(err.Error(), "stdout already received 12 bytes") {
+		t.Errorf("error %v, want the stdout byte count", err)
+	}
+}
+
+// assertOnlyFiles fails unless dir holds exactly the named entries.
+func assertOnlyFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	if strings.Join(got, ",") != strings.Join(names, ",") {
+		t.Errorf("%s contains %v, want %v", dir, got, names)
+	}
+}
+
+// ttyBuffer is a stdout that claims to be a terminal.
+type ttyBuffer struct {
+	bytes.Buffer
+	tty bool
+}
+
+func (b *ttyBuffer) IsTerminal() bool { return b.tty }
+
+func TestBinaryGuard(t *testing.T) {
+	binary := []byte{0x04, 0x22, 0x4d, 0x18, 0x00, 0xff}
+	cases := []struct {
+		name      string
+		plaintext []byte
+		tty       bool
+		force     bool
+		wantCode  int
+	}{
+		{"binary to terminal", binary, true, false, exitUsage},
+		{"binary to terminal forced", binary, true, true, 0},
+		{"binary piped", binary, false, false, 0},
+		{"text to terminal", []byte("plain text, café\n"), true, false, 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			blobid, ciphertext := sealClassic(t, tc.plaintext, "pw")
+			args := []string{string(blobid), "-", base64.StdEncoding.EncodeToString(ciphertext)}
+			if tc.force {
+				args = append([]string{"-force-binary"}, args...)
+			}
+			out := &ttyBuffer{tty: tc.tty}
+			var errOut bytes.Buffer
+			code := run(args, strings.NewReader("pw"), out, &errOut)
+			if code != tc.wantCode {
+				t.Fatalf("exit %d, want %d (%s)", code, tc.wantCode, errOut.String())
+			}
+			if want := tc.plaintext; code != 0 {
+				if out.Len() != 0 {
+					t.Errorf("refused, but wrote %q", out.Bytes())
+				}
+			} else if !bytes.Equal(out.Bytes(), want) {
+				t.Errorf("stdout %q, want %q", out.Bytes(), want)
+			}
+		})
+	}
+}
+
+func TestLooksBinaryToleratesSplitRune(t *testing.T) {
+	text := []byte("naïve")
+	if looksBinary(text[:3]) {
+		t.Error("text cut inside a two-byte character judged binary")
+	}
+	if !looksBinary([]byte("ok\x00")) || !looksBinary([]byte{0xff, 'a'}) {
+		t.Error("NUL or invalid UTF-8 not judged binary")
+	}
+}
@@ -0,0 +1,324 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/password.go
+
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+	"golang.org/x/text/unicode/norm"
+
+	"decrypt/n2s"
+)
+
+// readPassword reads a password from r. Only a single trailing newline,
+// or CRLF from a file saved on Windows, is trimmed: passwords may
+// legitimately contain or end in spaces.
+func readPassword(r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("reading password: %w", err)
+	}
+	return trimLineEnding(string(data)), nil
+}
+
+func trimLineEnding(s string) string {
+	if t, ok := strings.CutSuffix(s, "\r\n"); ok {
+		return t
+	}
+	return strings.TrimSuffix(s, "\n")
+}
+
+// parseNormalize checks a -normalize value: nfc, nfd or none.
+func parseNormalize(s string) (string, error) {
+	switch s {
+	case "none", "nfc", "nfd":
+		return s, nil
+	}
+	return "", usageErrorf("-normalize must be nfc, nfd or none, got %q", s)
+}
+
+// normalizePassword applies a -normalize form. The same characters typed
+// on macOS (often NFD) and elsewhere (usually NFC) are different bytes
+// and so derive different keys; normalizing makes them agree, but a blob
+// sealed under the unnormalized bytes then needs -normalize none.
+func normalizePassword(pw, form string) string {
+	switch form {
+	case "nfc":
+		return norm.NFC.String(pw)
+	case "nfd":
+		return norm.NFD.String(pw)
+	}
+	return pw
+}
+
+func readPasswordFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("opening password file: %w", err)
+	}
+	defer f.Close()
+	return readPassword(f)
+}
+
+// terminalFile returns r as an *os.File when it is an interactive terminal.
+func terminalFile(r io.Reader) (*os.File, bool) {
+	f, ok := r.(*os.File)
+	if !ok || !term.IsTerminal(int(f.Fd())) {
+		return nil, false
+	}
+	return f, true
+}
+
+// promptPassword reads a password from the terminal with echo disabled.
+func promptPassword(tty *os.File, stderr io.Writer, prompt string) (string, error) {
+	fmt.Fprint(stderr, prompt)
+	pw, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(stderr)
+	if err != nil {
+		return "", fmt.Errorf("reading password: %w", err)
+	}
+	return string(pw), nil
+}
+
+// warnArgvPassword nags about passwords on the command line, which end up
+// in the process table and shell history.
+func warnArgvPassword(log *logger) {
+	log.warnf("passing the password as an argument is deprecated; use -password-file or '-' to read it from stdin")
+}
+
+// decryptPassword resolves the decrypt password from, in order, -password-file,
+// the positional argument ('-' meaning stdin), or a terminal prompt. rest holds
+// the positional arguments left after the blobid and ciphertext. The prompt
+// goes to stderr whatever the log level.
+func decryptPassword(rest []string, passwordFile string, stdinBusy bool, stdin io.Reader, stderr io.Writer, log *logger) (string, error) {
+	if len(rest) > 1 {
+		return "", usageErrorf("unexpected arguments: %q", rest[1:])
+	}
+	switch {
+	case passwordFile != "" && len(rest) == 1:
+		return "", usageErrorf("password given both as an argument and with -password-file")
+	case passwordFile != "":
+		return readPasswordFile(passwordFile)
+	case len(rest) == 1 && rest[0] == "-":
+		if stdinBusy {
+			return "", usageErrorf("stdin cannot carry both the password and the ciphertext")
+		}
+		return readPassword(stdin)
+	case len(rest) == 1:
+		warnArgvPassword(log)
+		return rest[0], nil
+	}
+
+	tty, ok := terminalFile(stdin)
+	if !ok {
+		return "", usageErrorf("no password given and stdin is not a terminal")
+	}
+	return promptPassword(tty, stderr, "Password: ")
+}
+
+// envPassword resolves -password-env NAME. Like -keychain it replaces
+// the other password sources, and an unset or empty variable is an error,
+// never a silent fallback to the terminal prompt.
+func envPassword(name string, rest []string, passwordFile string) (string, error) {
+	if len(rest) > 0 || passwordFile != "" {
+		return "", usageErrorf("-password-env replaces the password; drop the password argument or -password-file")
+	}
+	pw, ok := os.LookupEnv(name)
+	switch {
+	case !ok:
+		return "", usageErrorf("-password-env: $%s is not set", name)
+	case pw == "":
+		return "", usageErrorf("-password-env: $%s is empty", name)
+	}
+	return pw, nil
+}
+
+// confirmAttempts bounds how often confirmPassword lets the two entries
+// disagree before giving up.
+const confirmAttempts = 3
+
+// confirmPassword asks for a new password twice and returns it once both
+// entries match. A typo in an encryption password makes the blob
+// unrecoverable, so a single entry is never trusted.
+func confirmPassword(ask func(prompt string) (string, error), stderr io.Writer) (string, error) {
+	for i := 0; i < confirmAttempts; i++ {
+		pw, err := ask("New password: ")
+		if err != nil {
+			return "", err
+		}
+		again, err := ask("Confirm password: ")
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case pw == "":
+			fmt.Fprintln(stderr, "Empty password; try again.")
+		case pw != again:
+			fmt.Fprintln(stderr, "Passwords do not match; try again.")
+		default:
+			return pw, nil
+		}
+	}
+	return "", fmt.Errorf("passwords did not match after %d attempts", confirmAttempts)
+}
+
+// encryptPassword resolves the encrypt password from -password-file, the
+// positional argument, or a confirmed terminal prompt. Without a terminal
+// the first line of stdin is the password and the rest is the plaintext,
+// which is why the returned reader must be used in place of stdin.
+func encryptPassword(rest []string, passwordFile string, stdin io.Reader, stderr io.Writer) (string, io.Reader, error) {
+	switch {
+	case len(rest) > 1:
+		return "", nil, usageErrorf("unexpected arguments: %q", rest[1:])
+	case passwordFile != "" && len(rest) == 1:
+		return "", nil, usageErrorf("password given both as an argument and with -password-file")
+	case passwordFile != "":
+		pw, err := readPasswordFile(passwordFile)
+		return pw, stdin, err
+	case len(rest) == 1:
+		warnArgvPassword(newLogger(stderr, levelWarn))
+		return rest[0], stdin, nil
+	}
+
+	if tty, ok := terminalFile(stdin); ok {
+		pw, err := confirmPassword(func(prompt string) (string, error) {
+			return promptPassword(tty, stderr, prompt)
+		}, stderr)
+		return pw, stdin, err
+	}
+
+	br := bufio.NewReader(stdin)
+	line, err := br.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", nil, fmt.Errorf("reading password: %w", err)
+	}
+	if err == io.EOF && line == "" {
+		return "", nil, usageErrorf("no password given and stdin is empty")
+	}
+	return trimLineEnding(line), br, nil
+}
+
+// parseKeyHex decodes a raw key given in place of a password.
+func parseKeyHex(s string) ([]byte, error) {
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("decoding key: %w", err)
+	}
+	if len(key) != n2s.KeyLen {
+		return nil, fmt.Errorf("key must be %d hex characters (%d bytes), got %d bytes", 2*n2s.KeyLen, n2s.KeyLen, len(key))
+	}
+	return key, nil
+}
+
+// readMasterKeyFile reads a -master-key-file: the key as 64 hex
+// characters, optionally followed by a newline.
+func readMasterKeyFile(path string) ([]byte, error) {
+	return readKeyFile(path, "master key")
+}
+
+// readKeyFile reads a 32-byte key stored as hex in path; what names the
+// key in errors. The file's bytes are wiped once decoded.
+func readKeyFile(path, what string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s file: %w", what, err)
+	}
+	defer n2s.Wipe(data)
+	key, err := parseKeyHex(trimLineEnding(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%s file %s: %w", what, path, err)
+	}
+	return key, nil
+}
+
+// strengthFlags registers encrypt's and rekey's weak-password check.
+// Decryption never applies it: existing weak blobs must stay recoverable.
+type strengthFlags struct {
+	minLen    *int
+	minBits   *float64
+	allowWeak *bool
+}
+
+func addStrengthFlags(fs *flag.FlagSet) strengthFlags {
+	return strengthFlags{
+		minLen:    fs.Int("min-password-len", 12, "refuse to seal under a password shorter than this many `characters`"),
+		minBits:   fs.Float64("min-password-bits", 50, "refuse to seal under a password whose estimated strength is below this many `bits`"),
+		allowWeak: fs.Bool("allow-weak-password", false, "seal even under a password that fails -min-password-len or -min-password-bits"),
+	}
+}
+
+// check refuses a weak new password unless -allow-weak-password is set.
+func (f strengthFlags) check(pw string) error {
+	if *f.allowWeak {
+		return nil
+	}
+	n, bits := passwordStrength(pw)
+	switch {
+	case n < *f.minLen:
+		return usageErrorf("password is too weak: %d characters, want at least %d (-allow-weak-password seals anyway)", n, *f.minLen)
+	case bits < *f.minBits:
+		return usageErrorf("password is too weak: about %.0f bits, want at least %.0f; use a longer passphrase (-allow-weak-password seals anyway)", bits, *f.minBits)
+	}
+	return nil
+}
+
+// passwordStrength returns pw's length in characters and a rough
+// strength estimate: the bits of a random string drawn from the character
+// classes pw uses, counting a run of one repeated character only once and
+// capping the pool at the number of distinct characters, so "aaaa..." and
+// "abab..." score as little as they are worth. It is a floor against
+// accidents, not a cracking-cost model.
+func passwordStrength(pw string) (length int, bits float64) {
+	var lower, upper, digit, symbol, other bool
+	distinct := make(map[rune]bool)
+	runs := 0
+	prev := rune(-1)
+	for _, r := range pw {
+		length++
+		distinct[r] = true
+		if r != prev {
+			runs++
+		}
+		prev = r
+		switch {
+		case 'a' <= r && r <= 'z':
+			lower = true
+		case 'A' <= r && r <= 'Z':
+			upper = true
+		case '0' <= r && r <= '9':
+			digit = true
+		case r < 0x80:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	pool := 0
+	for _, c := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if c.used {
+			pool += c.size
+		}
+	}
+	pool = min(pool, len(distinct)*len(distinct))
+	if pool < 2 {
+		return length, 0
+	}
+	return length, float64(runs) * math.Log2(float64(pool))
+}
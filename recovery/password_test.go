@@ -0,0 +1,238 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/password_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"decrypt/n2s"
+)
+
+func TestDecryptNormalize(t *testing.T) {
+	const nfc, nfd = "caf\u00e9", "cafe\u0301"
+	blobid, ciphertext := sealClassic(t, []byte("menu"), nfc)
+	pwFile := filepath.Join(t.TempDir(), "pw")
+	if err := os.WriteFile(pwFile, []byte(nfd+"\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	b64 := base64.StdEncoding.EncodeToString(ciphertext)
+	for _, tc := range []struct {
+		form string
+		code int
+	}{
+		{"none", exitAuthFailed},
+		{"nfd", exitAuthFailed},
+		{"nfc", 0},
+	} {
+		var out, errOut bytes.Buffer
+		code := run([]string{"-normalize", tc.form, "-password-file", pwFile, string(blobid), b64}, nil, &out, &errOut)
+		if code != tc.code || (code == 0 && out.String() != "menu") {
+			t.Errorf("-normalize %s: exit %d, want %d: %q %s", tc.form, code, tc.code, out.String(), errOut.String())
+		}
+	}
+
+	var errOut bytes.Buffer
+	if code := run([]string{"-normalize", "nfkc", "-password-file", pwFile, string(blobid), b64}, nil, &bytes.Buffer{}, &errOut); code != exitUsage {
+		t.Errorf("-normalize nfkc: exit %d: %s", code, errOut.String())
+	}
+}
+
+func TestReadPasswordTrimsOneNewline(t *testing.T) {
+	cases := map[string]string{
+		"secret":      "secret",
+		"secret\n":    "secret",
+		"secret\n\n":  "secret\n",
+		" spaced pw ": " spaced pw ",
+		"trailing \n": "trailing ",
+		"windows\r\n": "windows",
+		"cr only\r":   "cr only\r",
+	}
+	for in, want := range cases {
+		got, err := readPassword(strings.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("readPassword(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestDecryptPasswordSources(t *testing.T) {
+	const password = "pass with spaces "
+	blobid, ciphertext := sealClassic(t, []byte("payload"), password)
+	b64 := base64.StdEncoding.EncodeToString(ciphertext)
+
+	pwFile := filepath.Join(t.TempDir(), "pw")
+	if err := os.WriteFile(pwFile, []byte(password+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name       string
+		args       []string
+		stdin      string
+		deprecWarn bool
+	}{
+		{"file", []string{"-password-file", pwFile, string(blobid), b64}, "", false},
+		{"stdin", []string{string(blobid), "-", b64}, password + "\n", false},
+		{"argv", []string{string(blobid), password, b64}, "", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var out, errOut bytes.Buffer
+			if code := run(tc.args, strings.NewReader(tc.stdin), &out, &errOut); code != 0 {
+				t.Fatalf("exit %d: %s", code, errOut.String())
+			}
+			if out.String() != "payload" {
+				t.Errorf("plaintext = %q", out.String())
+			}
+			if got := strings.Contains(errOut.String(), "deprecated"); got != tc.deprecWarn {
+				t.Errorf("deprecation warning = %v, want %v (stderr %q)", got, tc.deprecWarn, errOut.String())
+			}
+		})
+	}
+}
+
+func TestDecryptNoPasswordWithoutTerminal(t *testing.T) {
+	var out, errOut bytes.Buffer
+	if code := run([]string{"00", "AA=="}, strings.NewReader(""), &out, &errOut); code == 0 {
+		t.Fatal("expected failure without a password source")
+	}
+}
+
+// scriptedPrompt answers prompts from a fixed list, standing in for a
+// terminal.
+func scriptedPrompt(t *testing.T, answers ...string) func(string) (string, error) {
+	return func(prompt string) (string, error) {
+		if len(answers) == 0 {
+			t.Fatalf("unexpected prompt %q", prompt)
+		}
+		a := answers[0]
+		answers = answers[1:]
+		return a, nil
+	}
+}
+
+func TestConfirmPasswordMismatchThenMatch(t *testing.T) {
+	var errOut bytes.Buffer
+	pw, err := confirmPassword(scriptedPrompt(t, "hunter2", "hunter3", "hunter2", "hunter2"), &errOut)
+	if err != nil || pw != "hunter2" {
+		t.Fatalf("confirmPassword = %q, %v", pw, err)
+	}
+	if !strings.Contains(errOut.String(), "do not match") {
+		t.Errorf("stderr %q lacks mismatch notice", errOut.String())
+	}
+}
+
+func TestConfirmPasswordGivesUp(t *testing.T) {
+	var errOut bytes.Buffer
+	_, err := confirmPassword(scriptedPrompt(t, "a", "b", "c", "d", "e", "f"), &errOut)
+	if err == nil {
+		t.Fatal("three mismatches accepted")
+	}
+}
+
+func TestEncryptPasswordFromStdinLine(t *testing.T) {
+	var out, errOut bytes.Buffer
+	if code := run([]string{"encrypt", "-allow-weak-password"}, strings.NewReader("pw\nline one\nline two\n"), &out, &errOut); code != 0 {
+		t.Fatalf("exit %d: %s", code, errOut.String())
+	}
+	blobid, ctB64, _ := strings.Cut(strings.TrimSpace(out.String()), "\t")
+	ciphertext, _ := decodeBase64(ctB64)
+	got, err := n2s.Decrypt([]byte(blobid), ciphertext, nil, "pw")
+	if err != nil || string(got) != "line one\nline two\n" {
+		t.Errorf("round trip: %q, %v", got, err)
+	}
+}
+
+func TestWeakPasswordRefused(t *testing.T) {
+	weak := []string{"", "a", "pw", "tiny-pass", "123456789012", "aaaaaaaaaaaaaaaa", "abababababababab"}
+	strong := []string{"correct horse battery staple", "Tr0ub4dor&3-xyz", "café au lait, s'il vous plaît"}
+	for _, pw := range weak {
+		var out, errOut bytes.Buffer
+		if code := run([]string{"encrypt", "-password-file", writePasswordFile(t, pw)}, strings.NewReader("x"), &out, &errOut); code != exitUsage || out.Len() != 0 {
+			t.Errorf("encrypt under %q: exit %d, stdout %q", pw, code, out.String())
+		}
+		if !strings.Contains(errOut.String(), "password is too weak") {
+			t.Errorf("encrypt under %q: stderr %q", pw, errOut.String())
+		}
+		if code := run([]string{"encrypt", "-allow-weak-password", "-password-file", writePasswordFile(t, pw)}, strings.NewReader("x"), &out, &errOut); code != 0 {
+			t.Errorf("encrypt -allow-weak-password under %q: exit %d: %s", pw, code, errOut.String())
+		}
+	}
+	for _, pw := range strong {
+		var out, errOut bytes.Buffer
+		if code := run([]string{"encrypt", "-password-file", writePasswordFile(t, pw)}, strings.NewReader("x"), &out, &errOut); code != 0 {
+			t.Errorf("encrypt under %q: exit %d: %s", pw, code, errOut.String())
+		}
+	}
+
+	// Rekey checks the new password, never the old one.
+	blobid, ciphertext := sealClassic(t, []byte("x"), "pw")
+	args := []string{"rekey", "-old-password-file", writePasswordFile(t, "pw"), "-new-password-file", writePasswordFile(t, "pw2"),
+		string(blobid), base64.StdEncoding.EncodeToString(ciphertext)}
+	var out, errOut bytes.Buffer
+	if code := run(args, nil, &out, &errOut); code != exitUsage || !strings.Contains(errOut.String(), "password is too weak") {
+		t.Errorf("rekey to a weak password: exit %d: %s", code, errOut.String())
+	}
+	args[2] = writePasswordFile(t, "pw")
+	args[4] = writePasswordFile(t, "correct horse battery staple")
+	if code := run(args, nil, &out, &errOut); code != 0 {
+		t.Errorf("rekey from a weak password: exit %d: %s", code, errOut.String())
+	}
+}
+
+func writePasswordFile(t *testing.T, pw string) string {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(pw); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestDecryptPasswordEnv(t *testing.T) {
+	blobid, ciphertext := sealClassic(t, []byte("from env"), "env secret")
+	b64 := base64.StdEncoding.EncodeToString(ciphertext)
+	t.Setenv("N2S_TEST_PASSWORD", "env secret")
+	t.Setenv("N2S_TEST_EMPTY", "")
+
+	var out, errOut bytes.Buffer
+	if code := run([]string{"-password-env", "N2S_TEST_PASSWORD", string(blobid), b64}, nil, &out, &errOut); code != 0 || out.String() != "from env" {
+		t.Fatalf("exit %d, %q: %s", code, out.String(), errOut.String())
+	}
+
+	cases := map[string]struct {
+		args []string
+		want string
+	}{
+		"unset":          {[]string{"-password-env", "N2S_TEST_UNSET", string(blobid), b64}, "$N2S_TEST_UNSET is not set"},
+		"empty":          {[]string{"-password-env", "N2S_TEST_EMPTY", string(blobid), b64}, "$N2S_TEST_EMPTY is empty"},
+		"positional too": {[]string{"-password-env", "N2S_TEST_PASSWORD", string(blobid), "pw", b64}, "drop the password argument"},
+		"file too":       {[]string{"-password-env", "N2S_TEST_PASSWORD", "-password-file", "pw.txt", string(blobid), b64}, "drop the password argument"},
+		"key too":        {[]string{"-password-env", "N2S_TEST_PASSWORD", "-key", strings.Repeat("00", n2s.KeyLen), string(blobid), b64}, "mutually exclusive"},
+	}
+	for name, c := range cases {
+		out.Reset()
+		errOut.Reset()
+		if code := run(c.args, nil, &out, &errOut); code != exitUsage || !strings.Contains(errOut.String(), c.want) {
+			t.Errorf("%s: exit %d, stderr %q; want %d and %q", name, code, errOut.String(), exitUsage, c.want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/passwordlist.go
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"decrypt/n2s"
+)
+
+// candidate is one line of a -password-list file.
+type candidate struct {
+	line     int
+	password string
+}
+
+// readPasswordList reads one candidate password per line. Line endings
+// are trimmed as for -password-file; blank lines are skipped, and a
+// password repeated on a later line, after normalization to form, is
+// dropped so its key is only derived once.
+func readPasswordList(path, form string) ([]candidate, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening password list: %w", err)
+	}
+	defer f.Close()
+
+	var candidates []candidate
+	lines := 0
+	seen := make(map[string]bool)
+	br := bufio.NewReader(f)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			lines++
+			pw := normalizePassword(trimLineEnding(line), form)
+			if pw != "" && !seen[pw] {
+				seen[pw] = true
+				candidates = append(candidates, candidate{lines, pw})
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading password list: %w", err)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, usageErrorf("password list %s has no candidates", path)
+	}
+	return candidates, nil
+}
+
+// tryPasswords opens a single-shot blob with each candidate in turn and
+// returns the plaintext and the candidate of the first that
+// authenticates. Once none has, the error wraps n2s.ErrAuthFailed and
+// says how many were tried.
+func tryPasswords(ctx context.Context, blob *n2s.Blob, ciphertext, aad []byte, candidates []candidate, log *logger) ([]byte, candidate, error) {
+	for _, c := range candidates {
+		if err := ctx.Err(); err != nil {
+			return nil, candidate{}, err
+		}
+		key, err := n2s.DeriveKeyCtx(ctx, c.password, blob.Salt, blob.KDF, blob.Cipher.KeySize(), nil)
+		if err != nil {
+			return nil, candidate{}, err
+		}
+		plaintext, err := n2s.Open(blob, key, ciphertext, aad)
+		n2s.Wipe(key)
+		switch {
+		case err == nil:
+			return plaintext, c, nil
+		case !errors.Is(err, n2s.ErrAuthFailed):
+			return nil, candidate{}, err
+		}
+		log.infof("password list line %d: no match", c.line)
+	}
+	return nil, candidate{}, fmt.Errorf("%w: none of the %d candidates in the password list opened the blob", n2s.ErrAuthFailed, len(candidates))
+}
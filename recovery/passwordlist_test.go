@@ -0,0 +1,49 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/passwordlist_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecryptPasswordList(t *testing.T) {
+	blobid, ciphertext := sealClassic(t, []byte("rotated"), "spring-2023")
+	b64 := base64.StdEncoding.EncodeToString(ciphertext)
+	dir := t.TempDir()
+	list := filepath.Join(dir, "candidates")
+	os.WriteFile(list, []byte("winter-2022\r\nautumn-2022\nspring-2023\nsummer-2023\nwinter-2023\n"), 0o600)
+
+	var out, errOut bytes.Buffer
+	if code := run([]string{"-password-list", list, string(blobid), b64}, nil, &out, &errOut); code != 0 {
+		t.Fatalf("exit %d: %s", code, errOut.String())
+	}
+	if out.String() != "rotated" {
+		t.Errorf("plaintext %q", out.String())
+	}
+	if !strings.Contains(errOut.String(), "line 3 opens the blob") {
+		t.Errorf("stderr %q does not name line 3", errOut.String())
+	}
+
+	// Repeats are derived once: five lines, three candidates.
+	none := filepath.Join(dir, "none")
+	os.WriteFile(none, []byte("a\nb\na\n\nc\n"), 0o600)
+	out.Reset()
+	errOut.Reset()
+	if code := run([]string{"-password-list", none, string(blobid), b64}, nil, &out, &errOut); code != exitAuthFailed || out.Len() != 0 {
+		t.Errorf("no match: exit %d, stdout %q", code, out.String())
+	}
+	if !strings.Contains(errOut.String(), "none of the 3 candidates") {
+		t.Errorf("no match: stderr %q", errOut.String())
+	}
+}
@@ -0,0 +1,197 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/pipeline.go
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"decrypt/n2s"
+)
+
+// Transform rewrites a plaintext on its way out, e.g. decompressing it.
+type Transform func([]byte) ([]byte, error)
+
+// transforms are the -pipeline stages by name. A new stage only needs an
+// entry here. Each entry builds its Transform for the -max-plaintext
+// limit (0 is no limit): a stage that can expand its input holds its
+// output to it as it goes, where the runner could only check it once the
+// damage is done. One that cannot expand registers through unlimited.
+var transforms = map[string]func(limit int64) Transform{
+	"cat":    unlimited(func(b []byte) ([]byte, error) { return b, nil }),
+	"gunzip": gunzip,
+	"json":   indentJSON,
+	"zstd":   unzstd,
+}
+
+// unlimited registers fn, which never grows its input, as a stage.
+func unlimited(fn Transform) func(int64) Transform {
+	return func(int64) Transform { return fn }
+}
+
+type stage struct {
+	name  string
+	build func(limit int64) Transform
+}
+
+// parsePipeline resolves a comma-separated -pipeline value into stages.
+func parsePipeline(spec string) ([]stage, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	var stages []stage
+	for _, name := range strings.Split(spec, ",") {
+		build, ok := transforms[name]
+		if !ok {
+			return nil, usageErrorf("unknown -pipeline stage %q (have %s)", name, strings.Join(slices.Sorted(maps.Keys(transforms)), ", "))
+		}
+		stages = append(stages, stage{name, build})
+	}
+	return stages, nil
+}
+
+// runPipeline applies stages to data in order, each built for and held
+// to limit bytes of output; a stage that hands back more fails even if
+// it ignored the limit. With wipe, each buffer a stage replaces is zeroed
+// once the stage is done with it; the caller still owns data and the
+// result.
+func runPipeline(stages []stage, data []byte, wipe bool, limit int64) ([]byte, error) {
+	in := data
+	for i, st := range stages {
+		out, err := st.build(limit)(data)
+		if err == nil && limit > 0 && int64(len(out)) > limit {
+			if !sameBuffer(data, out) {
+				n2s.Wipe(out)
+			}
+			err = exceedsLimit(limit)
+		}
+		if err != nil {
+			if wipe && !sameBuffer(data, in) {
+				n2s.Wipe(data)
+			}
+			return nil, fmt.Errorf("pipeline stage %d (%s): %w", i+1, st.name, err)
+		}
+		if wipe && !sameBuffer(data, in) && !sameBuffer(data, out) {
+			n2s.Wipe(data)
+		}
+		data = out
+	}
+	return data, nil
+}
+
+// sameBuffer reports whether a stage handed back its input rather than a
+// new buffer.
+func sameBuffer(a, b []byte) bool {
+	return len(a) > 0 && len(b) > 0 && &a[0] == &b[0]
+}
+
+func gunzip(limit int64) Transform {
+	return func(data []byte) ([]byte, error) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return readExpanded(zr, limit)
+	}
+}
+
+func unzstd(limit int64) Transform {
+	return func(data []byte) ([]byte, error) {
+		zr, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return readExpanded(zr, limit)
+	}
+}
+
+// indentJSON pretty-prints a single JSON document as json.Indent would,
+// held to limit bytes of output. Indentation is not a bounded factor:
+// every level of nesting adds two spaces to each line below it, so a few
+// kilobytes of brackets indent to hundreds of megabytes. The document is
+// compacted first, which checks it and never grows it, then indented here
+// so the limit is checked as the output grows.
+func indentJSON(limit int64) Transform {
+	return func(data []byte) ([]byte, error) {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, data); err != nil {
+			return nil, err
+		}
+		defer n2s.Wipe(compact.Bytes())
+		src := compact.Bytes()
+		out := make([]byte, 0, len(src)+len(src)/2)
+		depth := 0
+		newline := func() bool {
+			out = append(out, '\n')
+			for i := 0; i < depth; i++ {
+				out = append(out, ' ', ' ')
+			}
+			return limit <= 0 || int64(len(out)) <= limit
+		}
+		inString, escaped := false, false
+		for i, c := range src {
+			if inString {
+				out = append(out, c)
+				switch {
+				case escaped:
+					escaped = false
+				case c == '\\':
+					escaped = true
+				case c == '"':
+					inString = false
+				}
+				continue
+			}
+			ok := true
+			switch c {
+			case '"':
+				inString = true
+				out = append(out, c)
+			case '{', '[':
+				out = append(out, c)
+				if i+1 < len(src) && src[i+1] != '}' && src[i+1] != ']' {
+					depth++
+					ok = newline()
+				}
+			case '}', ']':
+				if src[i-1] != '{' && src[i-1] != '[' {
+					depth--
+					ok = newline()
+				}
+				out = append(out, c)
+			case ',':
+				out = append(out, c)
+				ok = newline()
+			case ':':
+				out = append(out, c, ' ')
+			default:
+				out = append(out, c)
+			}
+			if !ok {
+				n2s.Wipe(out)
+				return nil, exceedsLimit(limit)
+			}
+		}
+		out = append(out, '\n')
+		if limit > 0 && int64(len(out)) > limit {
+			n2s.Wipe(out)
+			return nil, exceedsLimit(limit)
+		}
+		return out, nil
+	}
+}
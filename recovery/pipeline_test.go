@@ -0,0 +1,103 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/pipeline_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDecryptPipeline(t *testing.T) {
+	blobid, ciphertext := sealClassic(t, gzipBytes(t, []byte(`{"site":"A","n":[1,2]}`)), "pw")
+	b64 := base64.StdEncoding.EncodeToString(ciphertext)
+
+	var out, errOut bytes.Buffer
+	code := run([]string{"-pipeline", "gunzip,cat,json", string(blobid), "-", b64}, strings.NewReader("pw"), &out, &errOut)
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, errOut.String())
+	}
+	want := "{\n  \"site\": \"A\",\n  \"n\": [\n    1,\n    2\n  ]\n}\n"
+	if out.String() != want {
+		t.Errorf("output %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	errOut.Reset()
+	code = run([]string{"-pipeline", "json,gunzip", string(blobid), "-", b64}, strings.NewReader("pw"), &out, &errOut)
+	if code == 0 || out.Len() != 0 || !strings.Contains(errOut.String(), "pipeline stage 1 (json)") {
+		t.Errorf("json before gunzip: exit %d, stdout %q, stderr %q", code, out.String(), errOut.String())
+	}
+}
+
+func TestPipelineUnknownStage(t *testing.T) {
+	if _, err := parsePipeline("gunzip,lz4"); err == nil || !strings.Contains(err.Error(), `unknown -pipeline stage "lz4" (have cat, gunzip, json, zstd)`) {
+		t.Errorf("parsePipeline: %v", err)
+	}
+}
+
+func TestRunPipelineWipes(t *testing.T) {
+	in := zstdBytes(t, []byte("secret"))
+	var mid []byte
+	stages := []stage{
+		{"zstd", unzstd},
+		{"keep", unlimited(func(b []byte) ([]byte, error) { mid = b; return b, nil })},
+		{"upper", unlimited(func(b []byte) ([]byte, error) { return bytes.ToUpper(b), nil })},
+	}
+	out, err := runPipeline(stages, in, true, 0)
+	if err != nil || string(out) != "SECRET" {
+		t.Fatalf("runPipeline: %q, %v", out, err)
+	}
+	if !bytes.Equal(mid, make([]byte, len(mid))) {
+		t.Errorf("intermediate buffer %q not wiped", mid)
+	}
+	if bytes.Equal(in, make([]byte, len(in))) {
+		t.Error("caller's input was wiped")
+	}
+}
+
+func TestIndentJSONMatchesIndent(t *testing.T) {
+	for _, doc := range []string{
+		`{"site":"A","n":[1,2],"e":{},"l":[],"s":"a,b:{[\"]}"}`,
+		" [ {\"k\" : null} , true,\n\"\\\\\" ]",
+		`"bare"`,
+	} {
+		var want bytes.Buffer
+		if err := json.Indent(&want, []byte(doc), "", "  "); err != nil {
+			t.Fatal(err)
+		}
+		want.WriteByte('\n')
+		got, err := indentJSON(0)([]byte(doc))
+		if err != nil || string(got) != want.String() {
+			t.Errorf("indentJSON(%q) = %q, %v; want %q", doc, got, err, want.String())
+		}
+	}
+}
+
+func TestIndentJSONLimit(t *testing.T) {
+	// 2000 levels indent to about 4 MB from 4 KB.
+	deep := []byte(strings.Repeat("[", 2000) + strings.Repeat("]", 2000))
+	if _, err := indentJSON(64 << 10)(deep); err == nil || !strings.Contains(err.Error(), "decompressed output exceeds limit of 65536 bytes") {
+		t.Errorf("deep nesting over the limit: %v", err)
+	}
+	if out, err := indentJSON(0)(deep); err != nil || len(out) < 4<<20 {
+		t.Errorf("deep nesting with no limit: %d bytes, %v", len(out), err)
+	}
+}
+
+func TestRunPipelineHoldsStagesToLimit(t *testing.T) {
+	// A stage that ignores its limit is still stopped by the runner.
+	double := unlimited(func(b []byte) ([]byte, error) { return append(bytes.Clone(b), b...), nil })
+	stages := []stage{{"double", double}, {"double", double}}
+	if _, err := runPipeline(stages, []byte("0123456789"), true, 32); err == nil || !strings.Contains(err.Error(), "pipeline stage 2 (double): decompressed output exceeds limit of 32 bytes") {
+		t.Errorf("runPipeline: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/progress.go
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// progressInterval throttles -progress lines.
+var progressInterval = 250 * time.Millisecond
+
+// progress counts the ciphertext read for -progress and, once started,
+// prints a line at most every progressInterval. Percentages need the
+// input size, known only for a regular file. A nil *progress is off.
+type progress struct {
+	w     io.Writer
+	total int64
+	n     int64
+
+	started     bool
+	start, last time.Time
+	printed     bool
+}
+
+func newProgress(w io.Writer) *progress {
+	return &progress{w: w}
+}
+
+// input wraps a freshly opened input so its bytes are counted.
+func (p *progress) input(r io.ReadCloser) io.ReadCloser {
+	if p == nil {
+		return r
+	}
+	if f, ok := r.(*os.File); ok {
+		if st, err := f.Stat(); err == nil && st.Mode().IsRegular() {
+			p.total = st.Size()
+		}
+	}
+	return readCloser{progressReader{r, p}, r}
+}
+
+// begin starts reporting. Only streaming decryption calls it: a
+// single-shot blob is read in one go and reports nothing.
+func (p *progress) begin() {
+	if p == nil {
+		return
+	}
+	p.started = true
+	p.start = time.Now()
+	p.last = p.start
+}
+
+func (p *progress) add(n int) {
+	p.n += int64(n)
+	if !p.started || n == 0 {
+		return
+	}
+	if now := time.Now(); now.Sub(p.last) >= progressInterval {
+		p.last = now
+		p.print(now)
+	}
+}
+
+// finish prints a closing line if any progress was shown, so the last
+// line seen is the total.
+func (p *progress) finish() {
+	if p != nil && p.printed {
+		p.print(time.Now())
+	}
+}
+
+func (p *progress) print(now time.Time) {
+	p.printed = true
+	line := "progress: " + formatBytes(p.n)
+	if p.total > 0 {
+		line += fmt.Sprintf(" of %s (%.0f%%)", formatBytes(p.total), 100*float64(p.n)/float64(p.total))
+	}
+	if secs := now.Sub(p.start).Seconds(); secs > 0 {
+		line += fmt.Sprintf(", %s/s", formatBytes(int64(float64(p.n)/secs)))
+	}
+	fmt.Fprintln(p.w, line)
+}
+
+type progressReader struct {
+	r io.Reader
+	p *progress
+}
+
+func (r progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.p.add(n)
+	return n, err
+}
+
+// formatBytes renders n in binary units, e.g. "1.5 GiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
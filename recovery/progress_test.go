@@ -0,0 +1,72 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/progress_test.go
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecryptProgress(t *testing.T) {
+	saved := progressInterval
+	progressInterval = 0
+	defer func() { progressInterval = saved }()
+
+	plaintext := make([]byte, 64<<10)
+	rand.Read(plaintext)
+	dir := t.TempDir()
+	file, pwFile := filepath.Join(dir, "big.n2s"), filepath.Join(dir, "pw")
+	os.WriteFile(pwFile, []byte("pw"), 0o600)
+	var out, errOut bytes.Buffer
+	if code := run([]string{"encrypt", "-allow-weak-password", "-chunk-size", "4096", "-blobfile", file, "-password-file", pwFile}, bytes.NewReader(plaintext), &out, &errOut); code != 0 {
+		t.Fatalf("encrypt: exit %d: %s", code, errOut.String())
+	}
+
+	out.Reset()
+	if code := run([]string{"-progress", "-force-binary", "-blobfile", file, "-password-file", pwFile}, nil, &out, &errOut); code != 0 {
+		t.Fatalf("decrypt: exit %d: %s", code, errOut.String())
+	}
+	if !bytes.Equal(out.Bytes(), plaintext) {
+		t.Errorf("stdout is not the plaintext (%d bytes)", out.Len())
+	}
+	lines := strings.Split(strings.TrimSpace(errOut.String()), "\n")
+	if len(lines) < 2 || !strings.HasPrefix(lines[0], "progress: ") {
+		t.Fatalf("stderr %q", errOut.String())
+	}
+	if last := lines[len(lines)-1]; !strings.Contains(last, "(100%)") {
+		t.Errorf("last progress line %q, want 100%%", last)
+	}
+
+	// A single-shot blob reports nothing.
+	out.Reset()
+	errOut.Reset()
+	blobid, ciphertext := sealClassic(t, []byte("small"), "pw")
+	in := filepath.Join(dir, "small.bin")
+	if err := os.WriteFile(in, ciphertext, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if code := run([]string{"-progress", "-raw", "-in", in, "-password-file", pwFile, string(blobid)}, nil, &out, &errOut); code != 0 || out.String() != "small" {
+		t.Fatalf("single-shot: exit %d, %q: %s", code, out.String(), errOut.String())
+	}
+	if errOut.Len() != 0 {
+		t.Errorf("single-shot stderr %q", errOut.String())
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, want := range map[int64]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KiB", 3 << 30: "3.0 GiB"} {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/rekey.go
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"decrypt/n2s"
+)
+
+// rekeyPassword reads one of rekey's passwords from its file or, failing
+// that, the terminal; the new password must be confirmed.
+func rekeyPassword(file string, confirm bool, stdin io.Reader, stderr io.Writer, prompt string) (string, error) {
+	if file != "" {
+		return readPasswordFile(file)
+	}
+	tty, ok := terminalFile(stdin)
+	if !ok {
+		return "", usageErrorf("no password file given and stdin is not a terminal")
+	}
+	if confirm {
+		return confirmPassword(func(p string) (string, error) {
+			return promptPassword(tty, stderr, p)
+		}, stderr)
+	}
+	return promptPassword(tty, stderr, prompt)
+}
+
+// rekeyOptions starts from the old blob's settings and replaces those
+// given by flag or -config.
+func rekeyOptions(fs *flag.FlagSet, blob *n2s.Blob, kdfFlags kdfFlags, cipherName string, nonceLen int) (n2s.EncryptOptions, error) {
+	opts := n2s.RekeyOptions(blob)
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	if given["kdf"] || given["iterations"] || given["argon2-time"] || given["argon2-memory"] || given["argon2-threads"] {
+		kdf, err := kdfFlags.params()
+		if err != nil {
+			return opts, err
+		}
+		opts.KDF = kdf
+	}
+	if given["cipher"] {
+		id, err := parseCipher(cipherName)
+		if err != nil {
+			return opts, err
+		}
+		opts.Cipher = id
+		if id == n2s.CipherAES256GCM {
+			opts.NonceLen = n2s.NonceLen
+		}
+	}
+	if given["nonce-length"] {
+		opts.NonceLen = nonceLen
+	}
+	return opts, nil
+}
+
+// runRekey prints "new_blobid<TAB>new_ciphertext_b64" for one blob.
+func runRekey(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("rekey", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	oldFile := fs.String("old-password-file", "", "read the current password from `file`")
+	newFile := fs.String("new-password-file", "", "read the new password from `file`")
+	in := fs.String("in", "", "read base64 ciphertext from `file` ('-' for stdin)")
+	aad := fs.String("aad", "", "associated `data` the blob was sealed with; the new blob is bound to it too")
+	kdfFlags := addKDFFlags(fs)
+	cipherName := fs.String("cipher", "chacha20-poly1305", "AEAD for the new blob: chacha20-poly1305 or aes-256-gcm")
+	nonceLen := fs.Int("nonce-length", n2s.NonceLen, "nonce `bytes` for the new blob: 12, or 24 for XChaCha20-Poly1305")
+	normalize := fs.String("normalize", "none", "Unicode-normalize the new password: nfc, nfd or none; the current one is used as given")
+	strength := addStrengthFlags(fs)
+	configFile := fs.String("config", "", "read default -kdf, -iterations, -argon2-*, -cipher, -nonce-length and -normalize values from a JSON `file`; flags override it")
+	logf := addLogFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return exitUsage
+	}
+	level, err := logf.level()
+	log := newLogger(stderr, level)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	if *configFile != "" {
+		if err := applyConfig(fs, *configFile); err != nil {
+			log.errorf("%v", err)
+			return failureCode(err)
+		}
+	}
+	form, err := parseNormalize(*normalize)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	wantArgs := 2
+	if *in != "" {
+		wantArgs = 1
+	}
+	if fs.NArg() != wantArgs {
+		fmt.Fprintf(stderr, "Usage: %s rekey [-old-password-file file] [-new-password-file file] <blobid> <encrypted_b64>\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s rekey [flags] -in <file|-> <blobid>\n", os.Args[0])
+		return exitUsage
+	}
+
+	blob, err := n2s.ParseBlobID([]byte(fs.Arg(0)))
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	opts, err := rekeyOptions(fs, blob, kdfFlags, *cipherName, *nonceLen)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	var ciphertext []byte
+	if *in != "" {
+		ciphertext, err = readCiphertext(*in, stdin)
+	} else {
+		ciphertext, err = decodeBase64(fs.Arg(1))
+	}
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+
+	oldPassword, err := rekeyPassword(*oldFile, false, stdin, stderr, "Current password: ")
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	newPassword, err := rekeyPassword(*newFile, true, stdin, stderr, "")
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	newPassword = normalizePassword(newPassword, form)
+	if err := strength.check(newPassword); err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	if newPassword == oldPassword {
+		log.errorf("new password is the same as the current one")
+		return exitUsage
+	}
+
+	blobid, newCiphertext, err := n2s.RekeyWith(blob, ciphertext, []byte(*aad), oldPassword, newPassword, opts)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	fmt.Fprintf(stdout, "%s\t%s\n", hex.EncodeToString(blobid), base64.StdEncoding.EncodeToString(newCiphertext))
+	return 0
+}
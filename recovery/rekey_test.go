@@ -0,0 +1,74 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/rekey_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"decrypt/n2s"
+)
+
+func TestRekeyCommand(t *testing.T) {
+	blobid, ciphertext := sealClassic(t, []byte("rotated secret"), "departing")
+	dir := t.TempDir()
+	oldFile := filepath.Join(dir, "old")
+	newFile := filepath.Join(dir, "new")
+	os.WriteFile(oldFile, []byte("departing\n"), 0o600)
+	os.WriteFile(newFile, []byte("successor\n"), 0o600)
+
+	var out, errOut bytes.Buffer
+	args := []string{"rekey", "-allow-weak-password", "-old-password-file", oldFile, "-new-password-file", newFile,
+		string(blobid), base64.StdEncoding.EncodeToString(ciphertext)}
+	if code := run(args, nil, &out, &errOut); code != 0 {
+		t.Fatalf("exit %d: %s", code, errOut.String())
+	}
+	newID, newB64, ok := strings.Cut(strings.TrimSpace(out.String()), "\t")
+	if !ok || newID == string(blobid) {
+		t.Fatalf("output %q: want a fresh blobid and ciphertext", out.String())
+	}
+	newCT, _ := decodeBase64(newB64)
+
+	got, err := n2s.Decrypt([]byte(newID), newCT, nil, "successor")
+	if err != nil || string(got) != "rotated secret" {
+		t.Errorf("new password: %q, %v", got, err)
+	}
+	if _, err := n2s.Decrypt([]byte(newID), newCT, nil, "departing"); !errors.Is(err, n2s.ErrAuthFailed) {
+		t.Errorf("old password on rekeyed blob: %v", err)
+	}
+}
+
+func TestRekeyConfig(t *testing.T) {
+	blobid, ciphertext := sealClassic(t, []byte("moved"), "departing")
+	dir := t.TempDir()
+	oldFile, newFile, config := filepath.Join(dir, "old"), filepath.Join(dir, "new"), filepath.Join(dir, "n2s.json")
+	os.WriteFile(oldFile, []byte("departing\n"), 0o600)
+	os.WriteFile(newFile, []byte("successor\n"), 0o600)
+	os.WriteFile(config, []byte(`{"iterations": 200000, "nonce_length": 24}`), 0o600)
+
+	var out, errOut bytes.Buffer
+	args := []string{"rekey", "-allow-weak-password", "-config", config, "-old-password-file", oldFile, "-new-password-file", newFile,
+		string(blobid), base64.StdEncoding.EncodeToString(ciphertext)}
+	if code := run(args, nil, &out, &errOut); code != 0 {
+		t.Fatalf("exit %d: %s", code, errOut.String())
+	}
+	newID, _, _ := strings.Cut(out.String(), "\t")
+	blob, err := n2s.ParseBlobID([]byte(newID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob.KDF.Iterations != 200000 || len(blob.Nonce) != n2s.XNonceLen {
+		t.Errorf("rekeyed blob: %d iterations, %d-byte nonce", blob.KDF.Iterations, len(blob.Nonce))
+	}
+}
@@ -0,0 +1,106 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/report.go
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"decrypt/n2s"
+)
+
+// result is the -json report for one blob.
+type result struct {
+	BlobID         string `json:"blobid,omitempty"`
+	Status         string `json:"status"`
+	PlaintextBytes int    `json:"plaintext_bytes"`
+	KDF            string `json:"kdf,omitempty"`
+	Iterations     int    `json:"iterations,omitempty"`
+	Cipher         string `json:"cipher,omitempty"`
+	Created        string `json:"created,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+const (
+	statusOK       = "ok"
+	statusVerified = "verified"
+	statusError    = "error"
+	// A batch entry left undone by Ctrl-C; rerunning with -ledger
+	// picks it up.
+	statusInterrupted = "interrupted"
+	// A batch entry -on-duplicate skip left out.
+	statusSkipped = "skipped"
+)
+
+// describe fills in what the blobid alone says about a blob.
+func (r *result) describe(b *n2s.Blob) {
+	r.BlobID = b.ID()
+	r.KDF = b.KDF.ID.String()
+	if b.WrappedKey != nil {
+		r.KDF = "master-key"
+	}
+	r.Iterations = b.KDF.Iterations
+	r.Cipher = b.CipherName()
+	r.Created = createdString(b)
+}
+
+// createdString is a blob's creation time in RFC 3339, or "unknown" when
+// its header does not record one.
+func createdString(b *n2s.Blob) string {
+	if b.Created.IsZero() {
+		return "unknown"
+	}
+	return b.Created.Format(time.RFC3339)
+}
+
+// reporter prints either human-readable messages or, with -json, exactly
+// one JSON object: to stdout on success, to stderr on failure.
+type reporter struct {
+	json           bool
+	stdout, stderr io.Writer
+	log            *logger
+	res            result
+
+	// With -harden, every failure is held back until floor has passed
+	// since start, so a malformed blobid or bad base64 takes as long as a
+	// wrong password.
+	floor time.Duration
+	start time.Time
+}
+
+// fail reports err and returns its exit code.
+func (rp *reporter) fail(err error) int {
+	if rp.floor > 0 {
+		time.Sleep(time.Until(rp.start.Add(rp.floor)))
+	}
+	if !rp.json {
+		rp.log.errorf("%v", err)
+		return failureCode(err)
+	}
+	rp.res.Status = statusError
+	rp.res.Error = err.Error()
+	writeJSON(rp.stderr, rp.res)
+	return failureCode(err)
+}
+
+// done reports success with the given status. In text mode there is
+// nothing to print beyond what the caller already wrote.
+func (rp *reporter) done(status string) int {
+	if rp.json {
+		rp.res.Status = status
+		writeJSON(rp.stdout, rp.res)
+	}
+	return 0
+}
+
+func writeJSON(w io.Writer, v any) {
+	enc := json.NewEncoder(w)
+	enc.Encode(v)
+}
@@ -0,0 +1,113 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/report_test.go
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"decrypt/n2s"
+)
+
+func TestDecryptJSON(t *testing.T) {
+	blobid, ciphertext := sealClassic(t, []byte("twelve bytes"), "pw")
+	b64 := base64.StdEncoding.EncodeToString(ciphertext)
+	out := filepath.Join(t.TempDir(), "plain")
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-json", "-out", out, string(blobid), "-", b64}, strings.NewReader("pw"), &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr.String())
+	}
+	var res result
+	if err := json.Unmarshal(stdout.Bytes(), &res); err != nil {
+		t.Fatalf("stdout %q: %v", stdout.String(), err)
+	}
+	want := result{
+		BlobID: string(blobid), Status: statusOK, PlaintextBytes: 12,
+		KDF: "pbkdf2-sha256", Iterations: n2s.LegacyIterations, Cipher: "chacha20-poly1305", Created: "unknown",
+	}
+	if res != want {
+		t.Errorf("result = %+v, want %+v", res, want)
+	}
+	if data, _ := os.ReadFile(out); string(data) != "twelve bytes" {
+		t.Errorf("-out wrote %q", data)
+	}
+}
+
+func TestDecryptJSONFailure(t *testing.T) {
+	blobid, ciphertext := sealClassic(t, []byte("secret"), "pw")
+	b64 := base64.StdEncoding.EncodeToString(ciphertext)
+	out := filepath.Join(t.TempDir(), "plain")
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-json", "-out", out, string(blobid), "-", b64}, strings.NewReader("wrong"), &stdout, &stderr)
+	if code != exitAuthFailed {
+		t.Errorf("exit %d, want %d", code, exitAuthFailed)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("stdout %q, want empty", stdout.String())
+	}
+	var res result
+	if err := json.Unmarshal(stderr.Bytes(), &res); err != nil {
+		t.Fatalf("stderr %q: %v", stderr.String(), err)
+	}
+	if res.Status != statusError || !strings.Contains(res.Error, "authentication failed") || res.BlobID != string(blobid) {
+		t.Errorf("result = %+v", res)
+	}
+}
+
+func TestDecryptJSONNeedsOut(t *testing.T) {
+	blobid, ciphertext := sealClassic(t, []byte("secret"), "pw")
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-json", string(blobid), "-", base64.StdEncoding.EncodeToString(ciphertext)},
+		strings.NewReader("pw"), &stdout, &stderr)
+	if code == 0 || stdout.Len() != 0 {
+		t.Errorf("exit %d, stdout %q: plaintext must not share stdout with the report", code, stdout.String())
+	}
+}
+
+func TestBatchJSON(t *testing.T) {
+	entries := sealSharedSalt(t, "pw", 2)
+	entries = append(entries, manifestEntry{BlobID: "zz", Ciphertext: "AAAA"})
+	manifest := writeManifest(t, entries)
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"batch", "-json", "-out", t.TempDir(), manifest, "-"}, strings.NewReader("pw"), &stdout, &stderr)
+	if code != 1 {
+		t.Errorf("exit %d, want 1", code)
+	}
+
+	var got []result
+	sc := bufio.NewScanner(&stdout)
+	for sc.Scan() {
+		var res result
+		if err := json.Unmarshal(sc.Bytes(), &res); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		got = append(got, res)
+	}
+	if len(got) != len(entries) {
+		t.Fatalf("%d results, want %d", len(got), len(entries))
+	}
+	for i, res := range got[:2] {
+		if res.Status != statusOK || res.BlobID != entries[i].BlobID || res.PlaintextBytes != len("plaintext 0") {
+			t.Errorf("entry %d: %+v", i, res)
+		}
+	}
+	if got[2].Status != statusError || got[2].Error == "" {
+		t.Errorf("bad entry: %+v", got[2])
+	}
+}
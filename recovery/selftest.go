@@ -0,0 +1,170 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/selftest.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"decrypt/n2s"
+)
+
+// Known-answer vectors for selftest. The AEAD and PBKDF2 vectors come from
+// RFC 8439 section 2.8.2, the GCM specification's test case 16 and RFC 7914
+// section 11; the blob vectors pin the
+// whole decrypt path (blobid parsing, KDF, AEAD) and must never change,
+// since recovering existing blobs depends on them.
+const (
+	katAEADKey        = "808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f"
+	katAEADNonce      = "070000004041424344454647"
+	katAEADAAD        = "50515253c0c1c2c3c4c5c6c7"
+	katAEADPlaintext  = "Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it."
+	katAEADCiphertext = "d31a8d34648e60db7b86afbc53ef7ec2a4aded51296e08fea9e2b5a736ee62d63dbea45e8ca9671282fafb69da92728b" +
+		"1a71de0a9e060b2905d6a5b67ecd3b3692ddbd7f2d778b8c9803aee328091b58fab324e4fad675945585808b4831d7bc3ff4def08e" +
+		"4b7a9de576d26586cec64b61161ae10b594f09e26a7e902ecbd0600691"
+
+	katGCMKey        = "feffe9928665731c6d6a8f9467308308feffe9928665731c6d6a8f9467308308"
+	katGCMNonce      = "cafebabefacedbaddecaf888"
+	katGCMAAD        = "feedfacedeadbeeffeedfacedeadbeefabaddad2"
+	katGCMPlaintext  = "d9313225f88406e5a55909c5aff5269a86a7a9531534f7da2e4c303d8a318a721c3c0c95956809532fcf0e2449a6b525b16aedf5aa0de657ba637b39"
+	katGCMCiphertext = "522dc1f099567d07f47f37a32a84427d643a8cdcbfe5c0c97598a2bd2555d1aa8cb08e48590dbb3da7b08b1056828838c5f61e6393ba7a0abcc9f662" +
+		"76fc6ece0f4e1768cddf8853bb2d551b"
+
+	katPBKDF2Password = "Password"
+	katPBKDF2Salt     = "NaCl"
+	katPBKDF2Iter     = 80000
+	katPBKDF2Key      = "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56"
+
+	katBlobPassword  = "n2s selftest"
+	katBlobPlaintext = "n2s recovery known-answer test\n"
+	// A 32-byte producer-style blobid: legacy PBKDF2, ChaCha20-Poly1305.
+	katLegacyBlobID     = "000102030405060708090a0b0c0d0e0f00000000a0a1a2a3a4a5a6a7a8a9aaab"
+	katLegacyCiphertext = "624dcd71fe2b7ab5c139e850a6156af30e296abf199f57c01db48819bf3f3b278805ef3a8f68d942dd914ff2ce88a8"
+	// A version 2 header (Argon2id t=2 m=256 p=1) on a 40-byte XChaCha20 body.
+	katArgon2BlobID     = "400d010a0200000002000001000100101112131415161718191a1b1c1d1e1fb0b1b2b3b4b5b6b7b8b9babbbcbdbebfc0c1c2c3c4c5c6c7"
+	katArgon2Ciphertext = "71f2bb9511984fcc1c8710e4d024a61a2b9ab0e4f893751d973af8245f1b3b42a3009c8e444dd415452edbc401b72e"
+	// A version 2 header (PBKDF2 100000, cipher AES-256-GCM) on a 28-byte body.
+	katGCMBlobID     = "400b010501000186a003010200202122232425262728292a2b2c2d2e2fd0d1d2d3d4d5d6d7d8d9dadb"
+	katGCMBlobCipher = "5c4e88d316dc47da247704661f8d08a5d6d552c94c12ea8f75d5a022ed980b3620262955f5ed83efe6af1a0636e982"
+)
+
+// selftests are run in order by the selftest subcommand.
+var selftests = []struct {
+	name  string
+	check func() error
+}{
+	{"chacha20-poly1305 open (RFC 8439)", checkAEAD},
+	{"aes-256-gcm open (GCM test case 16)", checkGCM},
+	{"pbkdf2-sha256 derive (RFC 7914)", checkPBKDF2},
+	{"legacy blob decrypt", func() error { return checkBlob(katLegacyBlobID, katLegacyCiphertext) }},
+	{"argon2id xchacha20 blob decrypt", func() error { return checkBlob(katArgon2BlobID, katArgon2Ciphertext) }},
+	{"aes-256-gcm blob decrypt", func() error { return checkBlob(katGCMBlobID, katGCMBlobCipher) }},
+}
+
+func checkAEAD() error {
+	aead, err := chacha20poly1305.New(mustHex(katAEADKey))
+	if err != nil {
+		return err
+	}
+	got, err := aead.Open(nil, mustHex(katAEADNonce), mustHex(katAEADCiphertext), mustHex(katAEADAAD))
+	if err != nil {
+		return err
+	}
+	if string(got) != katAEADPlaintext {
+		return fmt.Errorf("plaintext %q, want %q", got, katAEADPlaintext)
+	}
+	return nil
+}
+
+func checkGCM() error {
+	aead, err := n2s.NewAEAD(mustHex(katGCMKey), n2s.CipherAES256GCM, n2s.NonceLen)
+	if err != nil {
+		return err
+	}
+	got, err := aead.Open(nil, mustHex(katGCMNonce), mustHex(katGCMCiphertext), mustHex(katGCMAAD))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, mustHex(katGCMPlaintext)) {
+		return fmt.Errorf("plaintext %x, want %s", got, katGCMPlaintext)
+	}
+	return nil
+}
+
+func checkPBKDF2() error {
+	key, err := n2s.DeriveKey(katPBKDF2Password, []byte(katPBKDF2Salt), n2s.KDFParams{ID: n2s.KDFPBKDF2, Iterations: katPBKDF2Iter}, n2s.KeyLen)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(key, mustHex(katPBKDF2Key)) {
+		return fmt.Errorf("key %x, want %s", key, katPBKDF2Key)
+	}
+	return nil
+}
+
+func checkBlob(blobid, ciphertext string) error {
+	got, err := n2s.Decrypt([]byte(blobid), mustHex(ciphertext), nil, katBlobPassword)
+	if err != nil {
+		return err
+	}
+	if string(got) != katBlobPlaintext {
+		return fmt.Errorf("plaintext %q, want %q", got, katBlobPlaintext)
+	}
+	return nil
+}
+
+// mustHex decodes one of the constants above.
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// runSelftest checks the embedded vectors, printing one line per vector.
+func runSelftest(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	logf := addLogFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return exitUsage
+	}
+	level, err := logf.level()
+	log := newLogger(stderr, level)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+
+	var failed int
+	for _, st := range selftests {
+		if err := st.check(); err != nil {
+			failed++
+			fmt.Fprintf(stdout, "FAIL %s: %v\n", st.name, err)
+			continue
+		}
+		fmt.Fprintf(stdout, "ok   %s\n", st.name)
+	}
+	if failed > 0 {
+		log.errorf("%d of %d known-answer tests failed; do not trust this binary with real blobs", failed, len(selftests))
+		return exitFailure
+	}
+	fmt.Fprintf(stdout, "selftest: all %d known-answer tests passed\n", len(selftests))
+	return 0
+}
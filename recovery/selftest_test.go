@@ -0,0 +1,52 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/selftest_test.go
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"decrypt/n2s"
+)
+
+func TestSelftestVectors(t *testing.T) {
+	for _, st := range selftests {
+		if err := st.check(); err != nil {
+			t.Errorf("%s: %v", st.name, err)
+		}
+	}
+}
+
+func TestSelftestCommand(t *testing.T) {
+	var out, errOut bytes.Buffer
+	if code := run([]string{"selftest"}, nil, &out, &errOut); code != 0 {
+		t.Fatalf("exit %d: %s%s", code, out.String(), errOut.String())
+	}
+	if strings.Count(out.String(), "ok   ") != len(selftests) {
+		t.Errorf("output %q", out.String())
+	}
+}
+
+func TestAESGCMKnownAnswers(t *testing.T) {
+	if err := checkGCM(); err != nil {
+		t.Errorf("GCM test case 16: %v", err)
+	}
+	blob, err := n2s.ParseBlobID([]byte(katGCMBlobID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob.Cipher != n2s.CipherAES256GCM || blob.CipherName() != "aes-256-gcm" {
+		t.Errorf("cipher %v (%s), want aes-256-gcm", blob.Cipher, blob.CipherName())
+	}
+	got, err := n2s.DecryptBlob(blob, mustHex(katGCMBlobCipher), nil, katBlobPassword)
+	if err != nil || string(got) != katBlobPlaintext {
+		t.Errorf("decrypt: %q, %v", got, err)
+	}
+}
@@ -0,0 +1,345 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/serve.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"time"
+
+	"decrypt/n2s"
+)
+
+// serveRequest is the body of POST /decrypt.
+type serveRequest struct {
+	BlobID     string `json:"blobid"`
+	Password   string `json:"password"`
+	Ciphertext string `json:"ciphertext_b64"`
+}
+
+// serveError is the JSON body of every failed request: the -json result
+// plus the failure kind batch tallies and the exit code the decrypt
+// command would have returned.
+type serveError struct {
+	result
+	Kind     string `json:"kind"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// minSecretLen keeps the shared secret from being guessable by another
+// local process.
+const minSecretLen = 16
+
+// server answers decrypt requests for local tooling, so a hot path costs
+// a round trip instead of a process. Every request but /healthz must
+// carry the shared secret as "Authorization: Bearer <secret>".
+type server struct {
+	secret  []byte
+	maxBody int64
+	// slots holds one token per request being decrypted; when it is full
+	// a further request gets 503 rather than queueing behind the KDF.
+	slots chan struct{}
+	// limit, if not nil, locks out clients that keep sending a wrong
+	// password or secret.
+	limit limiter
+	log   *logger
+}
+
+func newServer(secret string, maxBody int64, maxConcurrent int, limit limiter, log *logger) *server {
+	return &server{
+		secret:  []byte(secret),
+		maxBody: maxBody,
+		slots:   make(chan struct{}, max(maxConcurrent, 1)),
+		limit:   limit,
+		log:     log,
+	}
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /decrypt", s.decrypt)
+	mux.HandleFunc("GET /healthz", s.healthz)
+	return mux
+}
+
+// healthz reports readiness: the server runs only once the known-answer
+// tests have passed, so answering at all means it can decrypt. It needs
+// no secret and says nothing about any blob.
+func (s *server) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, struct {
+		Status        string `json:"status"`
+		InFlight      int    `json:"in_flight"`
+		MaxConcurrent int    `json:"max_concurrent"`
+	}{statusOK, len(s.slots), cap(s.slots)})
+}
+
+func (s *server) decrypt(w http.ResponseWriter, r *http.Request) {
+	// Each request is one try for its client, reserved before the secret
+	// is compared, so a client is locked out before the right guess at the
+	// secret or a password can get through. A wrong secret or password
+	// counts against the client; any successful decrypt clears its count.
+	client := clientOf(r)
+	done, ok := s.reserve(w, client, result{})
+	if !ok {
+		return
+	}
+	defer s.settle(done, client, tryReleased)
+	if !s.authorized(r) {
+		s.settle(done, client, tryFailed)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="n2s"`)
+		s.fail(w, http.StatusUnauthorized, result{}, errors.New("missing or wrong shared secret"))
+		return
+	}
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	default:
+		w.Header().Set("Retry-After", "1")
+		s.fail(w, http.StatusServiceUnavailable, result{}, fmt.Errorf("all %d decrypt slots are busy", cap(s.slots)))
+		return
+	}
+
+	var req serveRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.maxBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		if errors.As(err, new(*http.MaxBytesError)) {
+			s.fail(w, http.StatusRequestEntityTooLarge, result{}, fmt.Errorf("request body exceeds -max-body %d bytes", s.maxBody))
+			return
+		}
+		s.fail(w, http.StatusBadRequest, result{}, &decodeError{fmt.Errorf("parsing request: %w", err)})
+		return
+	}
+
+	start := time.Now()
+	res, plaintext, err := openRequest(r.Context(), req)
+	if err != nil {
+		if errors.Is(err, n2s.ErrAuthFailed) {
+			s.settle(done, client, tryFailed)
+		}
+		s.fail(w, httpStatus(err), res, err)
+		return
+	}
+	s.settle(done, client, trySucceeded)
+	defer n2s.Wipe(plaintext)
+	s.log.infof("serve: %s: opened %d bytes in %v", res.BlobID, len(plaintext), time.Since(start).Round(time.Millisecond))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(plaintext)))
+	w.Write(plaintext)
+}
+
+// reserve takes a try at key from the limiter, or answers 429 and
+// returns false. With no limiter every try is allowed and done is nil.
+func (s *server) reserve(w http.ResponseWriter, key string, res result) (done func(outcome) time.Duration, ok bool) {
+	if s.limit == nil {
+		return nil, true
+	}
+	wait, done := s.limit.attempt(key)
+	if done == nil {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		s.fail(w, http.StatusTooManyRequests, res, fmt.Errorf("%s: too many failed tries; retry in %v", key, wait.Round(time.Second)))
+		return nil, false
+	}
+	return done, true
+}
+
+// settle reports the outcome of a try reserve took, and logs the lockout
+// it trips, if any. Only a try's first outcome counts, so a deferred
+// tryReleased covers every path that reports none.
+func (s *server) settle(done func(outcome) time.Duration, key string, o outcome) {
+	if done == nil {
+		return
+	}
+	if lockout := done(o); lockout > 0 {
+		s.log.warnf("serve: locking out %s for %v after repeated failures", key, lockout.Round(time.Second))
+	}
+}
+
+// authorized compares the bearer token in constant time.
+func (s *server) authorized(r *http.Request) bool {
+	token, ok := bytes.CutPrefix([]byte(r.Header.Get("Authorization")), []byte("Bearer "))
+	return ok && subtle.ConstantTimeCompare(token, s.secret) == 1
+}
+
+// openRequest decrypts one request's blob, single-shot or chunked. The
+// KDF stops if the client goes away.
+func openRequest(ctx context.Context, req serveRequest) (result, []byte, error) {
+	res := result{BlobID: req.BlobID}
+	blob, err := n2s.ParseBlobID([]byte(req.BlobID))
+	if err != nil {
+		return res, nil, withFormatsHint(err)
+	}
+	res.describe(blob)
+	if blob.WrappedKey != nil {
+		return res, nil, usageErrorf("blob key is wrapped under a master key, which serve does not hold")
+	}
+	ciphertext, err := decodeBase64(req.Ciphertext)
+	if err != nil {
+		return res, nil, err
+	}
+	key, err := n2s.DeriveKeyCtx(ctx, req.Password, blob.Salt, blob.KDF, blob.Cipher.KeySize(), nil)
+	if err != nil {
+		return res, nil, err
+	}
+	defer n2s.Wipe(key)
+
+	var plaintext []byte
+	if blob.ChunkSize > 0 {
+		var buf bytes.Buffer
+		if err = n2s.OpenStreamContext(ctx, blob, key, bytes.NewReader(ciphertext), &buf, nil); err != nil {
+			n2s.Wipe(buf.Bytes())
+		}
+		plaintext = buf.Bytes()
+	} else {
+		plaintext, err = n2s.Open(blob, key, ciphertext, nil)
+	}
+	if err != nil {
+		return res, nil, err
+	}
+	res.PlaintextBytes = len(plaintext)
+	return res, plaintext, nil
+}
+
+// httpStatus maps a decrypt failure onto HTTP by its exit code: the
+// caller's fault is 4xx, with a wrong password 422 so it is not taken
+// for a missing secret.
+func httpStatus(err error) int {
+	switch failureCode(err) {
+	case exitUsage, exitDecode:
+		return http.StatusBadRequest
+	case exitAuthFailed:
+		return http.StatusUnprocessableEntity
+	}
+	if errors.Is(err, context.Canceled) {
+		// The client has gone; nobody reads this.
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func (s *server) fail(w http.ResponseWriter, status int, res result, err error) {
+	if res.BlobID != "" {
+		s.log.warnf("serve: %s: %v", res.BlobID, err)
+	} else {
+		s.log.warnf("serve: %v", err)
+	}
+	res.Status, res.Error = statusError, err.Error()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	writeJSON(w, serveError{res, failureKind(err), failureCode(err)})
+}
+
+// isLoopback reports whether addr's host is a loopback address or
+// "localhost"; an empty host listens on every interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func runServe(args []string, stderr io.Writer) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	addr := fs.String("addr", "127.0.0.1:8470", "listen on `host:port`")
+	allowRemote := fs.Bool("allow-remote", false, "allow an -addr that is not loopback; passwords and plaintexts then cross the network unencrypted")
+	secretFile := fs.String("secret-file", "", "read the shared secret every request must send as 'Authorization: Bearer <secret>' from `file`")
+	maxBody := fs.Int64("max-body", 64<<20, "refuse request bodies over this many `bytes` with 413")
+	maxConcurrent := fs.Int("max-concurrent", runtime.NumCPU(), "decrypt at most `N` requests at once; more get 503")
+	lockoutAfter := fs.Int("lockout-after", 5, "lock a client out after `N` wrong passwords or secrets in a row; 0 never does")
+	lockout := fs.Duration("lockout", 30*time.Second, "the first lockout lasts this long, doubling with each further failure up to an hour; requests meanwhile get 429")
+	logf := addLogFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return exitUsage
+	}
+	level, err := logf.level()
+	log := newLogger(stderr, level)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	if fs.NArg() != 0 || *secretFile == "" {
+		fmt.Fprintf(stderr, "Usage: %s serve -secret-file <file> [-addr host:port] [-max-body bytes] [-max-concurrent N]\n", os.Args[0])
+		return exitUsage
+	}
+	if !isLoopback(*addr) && !*allowRemote {
+		log.errorf("-addr %s is not loopback; pass -allow-remote to listen there anyway", *addr)
+		return exitUsage
+	}
+	if *maxBody <= 0 {
+		log.errorf("-max-body must be positive")
+		return exitUsage
+	}
+	if *lockoutAfter < 0 || *lockout <= 0 {
+		log.errorf("-lockout-after must not be negative and -lockout must be positive")
+		return exitUsage
+	}
+	secret, err := readPasswordFile(*secretFile)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	if len(secret) < minSecretLen {
+		log.errorf("shared secret is %d bytes; use at least %d", len(secret), minSecretLen)
+		return exitUsage
+	}
+	for _, st := range selftests {
+		if err := st.check(); err != nil {
+			log.errorf("selftest %s: %v; not serving", st.name, err)
+			return exitFailure
+		}
+	}
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.errorf("%v", err)
+		return exitIO
+	}
+	var limit limiter
+	if *lockoutAfter > 0 {
+		limit = newBackoffLimiter(*lockoutAfter, *lockout)
+	}
+	srv := newServer(secret, *maxBody, *maxConcurrent, limit, log)
+	hs := &http.Server{Handler: srv.handler(), ReadHeaderTimeout: 10 * time.Second}
+	// Ctrl-C lets requests in flight finish before exiting.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		hs.Shutdown(context.Background())
+	}()
+	log.logf(levelWarn, "serve: listening on http://%s", ln.Addr())
+	if err := hs.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		log.errorf("%v", err)
+		return exitIO
+	}
+	return 0
+}
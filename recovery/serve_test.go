@@ -0,0 +1,120 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/serve_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testSecret = "0123456789abcdef-secret"
+
+// postDecrypt sends body to srv's /decrypt with the given secret and
+// returns the status and response body.
+func postDecrypt(t *testing.T, srv *httptest.Server, secret string, body []byte) (int, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/decrypt", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret != "" {
+		req.Header.Set("Authorization", "Bearer "+secret)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	got, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, got
+}
+
+func TestServe(t *testing.T) {
+	var logs bytes.Buffer
+	srv := httptest.NewServer(newServer(testSecret, 4096, 2, nil, newLogger(&logs, levelWarn)).handler())
+	defer srv.Close()
+	blobid, ciphertext := sealClassic(t, []byte("served"), "pw")
+	request := func(password string) []byte {
+		b, _ := json.Marshal(serveRequest{string(blobid), password, base64.StdEncoding.EncodeToString(ciphertext)})
+		return b
+	}
+
+	code, body := postDecrypt(t, srv, testSecret, request("pw"))
+	if code != http.StatusOK || string(body) != "served" {
+		t.Errorf("success: %d %q", code, body)
+	}
+
+	code, body = postDecrypt(t, srv, testSecret, request("hunter2-guess"))
+	var e serveError
+	if err := json.Unmarshal(body, &e); err != nil {
+		t.Fatalf("wrong password: %d %q: %v", code, body, err)
+	}
+	if code != http.StatusUnprocessableEntity || e.Status != statusError || e.Kind != "auth" || e.ExitCode != exitAuthFailed || e.BlobID != string(blobid) {
+		t.Errorf("wrong password: %d %+v", code, e)
+	}
+	if strings.Contains(logs.String(), "hunter2") {
+		t.Errorf("log holds the password: %s", logs.String())
+	}
+
+	big := []byte(`{"blobid":"` + string(blobid) + `","password":"pw","ciphertext_b64":"` + strings.Repeat("A", 5000) + `"}`)
+	if code, body = postDecrypt(t, srv, testSecret, big); code != http.StatusRequestEntityTooLarge || !strings.Contains(string(body), "-max-body 4096") {
+		t.Errorf("oversized body: %d %q", code, body)
+	}
+
+	for _, secret := range []string{"", "guess"} {
+		if code, _ := postDecrypt(t, srv, secret, request("pw")); code != http.StatusUnauthorized {
+			t.Errorf("secret %q: %d, want %d", secret, code, http.StatusUnauthorized)
+		}
+	}
+	if code, body = postDecrypt(t, srv, testSecret, []byte(`{"blobid": "zz"`)); code != http.StatusBadRequest {
+		t.Errorf("bad JSON: %d %q", code, body)
+	}
+
+	resp, err := srv.Client().Get(srv.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	health, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(health), `"status":"ok"`) {
+		t.Errorf("healthz: %d %q", resp.StatusCode, health)
+	}
+}
+
+func TestServeBusy(t *testing.T) {
+	s := newServer(testSecret, 4096, 1, nil, newLogger(io.Discard, levelWarn))
+	s.slots <- struct{}{}
+	srv := httptest.NewServer(s.handler())
+	defer srv.Close()
+	if code, _ := postDecrypt(t, srv, testSecret, []byte(`{}`)); code != http.StatusServiceUnavailable {
+		t.Errorf("full: %d, want %d", code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestServeRefusesRemoteAddr(t *testing.T) {
+	secretFile := writePasswordFile(t, testSecret)
+	var out, errOut bytes.Buffer
+	if code := run([]string{"serve", "-secret-file", secretFile, "-addr", ":8470"}, nil, &out, &errOut); code != exitUsage {
+		t.Errorf("exit %d, want %d: %s", code, exitUsage, errOut.String())
+	}
+	if code := run([]string{"serve", "-secret-file", writePasswordFile(t, "short")}, nil, &out, &errOut); code != exitUsage {
+		t.Errorf("short secret: exit %d, want %d", code, exitUsage)
+	}
+	for addr, want := range map[string]bool{"127.0.0.1:1": true, "[::1]:1": true, "localhost:1": true, "0.0.0.0:1": false, ":1": false} {
+		if isLoopback(addr) != want {
+			t.Errorf("isLoopback(%q) = %v", addr, !want)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/setsig.go
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"decrypt/n2s"
+)
+
+// A set signature lets a bulk transfer be checked before anything is
+// decrypted. seal-manifest records an HMAC-SHA256 of each blob's blobid
+// and ciphertext under a separate integrity key, plus one over the
+// sorted list of those, and verify-manifest recomputes both. Unlike the
+// per-blob AEAD tags it needs no passphrase, and it also catches a blob
+// that was dropped from the set or added to it.
+type setSignature struct {
+	Version int       `json:"version"`
+	Blobs   []setBlob `json:"blobs"`
+	// MAC covers Blobs, so the file cannot be edited to match a damaged
+	// set.
+	MAC string `json:"mac"`
+}
+
+type setBlob struct {
+	BlobID string `json:"blobid"`
+	MAC    string `json:"mac"`
+}
+
+// setMACContext starts the whole-set MAC input, so it can never equal a
+// blob MAC input, which starts with a hex blobid.
+const setMACContext = "n2s set signature v1\n"
+
+// blobMAC authenticates one blob: its normalized blobid, a newline, then
+// the raw ciphertext.
+func blobMAC(key []byte, blobid string, ciphertext []byte) []byte {
+	m := hmac.New(sha256.New, key)
+	io.WriteString(m, blobid+"\n")
+	m.Write(ciphertext)
+	return m.Sum(nil)
+}
+
+// setMAC authenticates blobs, which must be sorted by blobid.
+func setMAC(key []byte, blobs []setBlob) []byte {
+	m := hmac.New(sha256.New, key)
+	io.WriteString(m, setMACContext)
+	for _, b := range blobs {
+		fmt.Fprintf(m, "%s\t%s\n", b.BlobID, b.MAC)
+	}
+	return m.Sum(nil)
+}
+
+// macEntries computes each entry's blob MAC, with errs[i] set for an
+// entry whose blobid does not parse or whose ciphertext cannot be read.
+// Blobids are normalized, so a manifest that changes only their case or
+// whitespace still matches.
+func macEntries(entries []manifestEntry, key []byte) ([]setBlob, []error) {
+	blobs := make([]setBlob, len(entries))
+	errs := make([]error, len(entries))
+	for i, e := range entries {
+		blob, err := n2s.ParseBlobID([]byte(e.BlobID))
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		ciphertext, err := e.ciphertext(blob)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		blobs[i] = setBlob{blob.ID(), hex.EncodeToString(blobMAC(key, blob.ID(), ciphertext))}
+	}
+	return blobs, errs
+}
+
+// setFlags are the flags seal-manifest and verify-manifest share.
+type setFlags struct {
+	keyFile *string
+	recurse *string
+}
+
+func addSetFlags(fs *flag.FlagSet) setFlags {
+	return setFlags{
+		keyFile: fs.String("key-file", "", "read the integrity `file`: a 32-byte key as 64 hex characters, kept apart from the blob passphrase"),
+		recurse: fs.String("recurse", "", "cover every <blobid>.b64 file under `dir` instead of reading a manifest"),
+	}
+}
+
+// argsOK reports whether n positional arguments suit the flags: the
+// manifest, or none with -recurse.
+func (f setFlags) argsOK(n int) bool {
+	if *f.recurse != "" {
+		return n == 0
+	}
+	return n == 1
+}
+
+// load reads the integrity key and the blob set, from the manifest named
+// by the one positional argument or from the -recurse tree.
+func (f setFlags) load(args []string, stdin io.Reader, log *logger) ([]byte, []manifestEntry, error) {
+	if *f.keyFile == "" {
+		return nil, nil, usageErrorf("-key-file is required")
+	}
+	var entries []manifestEntry
+	if *f.recurse != "" {
+		var skipped int
+		var err error
+		if entries, skipped, err = walkBlobTree(*f.recurse); err != nil {
+			return nil, nil, err
+		}
+		if skipped > 0 {
+			log.warnf("skipped %d files without a .b64 suffix", skipped)
+		}
+	} else {
+		r, err := openInput(args[0], stdin)
+		if err != nil {
+			return nil, nil, err
+		}
+		entries, err = parseManifest(r)
+		r.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	key, err := readKeyFile(*f.keyFile, "integrity key")
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, entries, nil
+}
+
+// runSealManifest writes the set signature of a manifest or -recurse
+// tree. Every blob must be readable and listed once: a signature over a
+// set with holes would vouch for them.
+func runSealManifest(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("seal-manifest", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	set := addSetFlags(fs)
+	outFile := fs.String("out", "", "write the signature to `file`")
+	logf := addLogFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return exitUsage
+	}
+	level, err := logf.level()
+	log := newLogger(stderr, level)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	if *outFile == "" || !set.argsOK(fs.NArg()) {
+		fmt.Fprintf(stderr, "Usage: %s seal-manifest -key-file <file> -out <signature> <manifest|->\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s seal-manifest -key-file <file> -out <signature> -recurse <dir>\n", os.Args[0])
+		return exitUsage
+	}
+	key, entries, err := set.load(fs.Args(), stdin, log)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	defer n2s.Wipe(key)
+
+	blobs, errs := macEntries(entries, key)
+	var failed error
+	seen := make(map[string]string)
+	for i, err := range errs {
+		if err == nil {
+			if prev, ok := seen[blobs[i].BlobID]; ok {
+				err = &decodeError{fmt.Errorf("blobid also listed as %s", prev)}
+			}
+			seen[blobs[i].BlobID] = entries[i].name()
+		}
+		if err != nil {
+			log.logf(levelError, "FAIL %s: %v", entries[i].name(), err)
+			failed = errors.Join(failed, err)
+		}
+	}
+	if failed != nil {
+		log.errorf("not signing a set with unreadable or duplicate blobs")
+		return failureCode(failed)
+	}
+	slices.SortFunc(blobs, func(a, b setBlob) int { return strings.Compare(a.BlobID, b.BlobID) })
+	sig := setSignature{Version: 1, Blobs: blobs, MAC: hex.EncodeToString(setMAC(key, blobs))}
+	data, err := json.MarshalIndent(sig, "", "  ")
+	if err != nil {
+		log.errorf("%v", err)
+		return exitFailure
+	}
+	if err := writeAtomic(*outFile, append(data, '\n')); err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	fmt.Fprintf(stdout, "seal-manifest: signed %d blobs\n", len(blobs))
+	return 0
+}
+
+// runVerifyManifest checks a blob set against its signature: first the
+// signature itself, then each blob. It exits 3 when the signature does
+// not authenticate under the key, and 1 when any blob is missing, altered
+// or not in the signature.
+func runVerifyManifest(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("verify-manifest", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	set := addSetFlags(fs)
+	sigFile := fs.String("sig", "", "read the signature from `file`, as written by seal-manifest -out")
+	logf := addLogFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return exitUsage
+	}
+	level, err := logf.level()
+	log := newLogger(stderr, level)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	if *sigFile == "" || !set.argsOK(fs.NArg()) {
+		fmt.Fprintf(stderr, "Usage: %s verify-manifest -key-file <file> -sig <signature> <manifest|->\n", os.Args[0])
+		fmt.Fprintf(stderr, "       %s verify-manifest -key-file <file> -sig <signature> -recurse <dir>\n", os.Args[0])
+		return exitUsage
+	}
+	key, entries, err := set.load(fs.Args(), stdin, log)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+	defer n2s.Wipe(key)
+	sig, err := readSetSignature(*sigFile, key)
+	if err != nil {
+		log.errorf("%v", err)
+		return failureCode(err)
+	}
+
+	signed := make(map[string][]byte, len(sig.Blobs))
+	for _, b := range sig.Blobs {
+		signed[b.BlobID], _ = hex.DecodeString(b.MAC)
+	}
+	var intact, altered, extra int
+	present := make(map[string]bool)
+	blobs, errs := macEntries(entries, key)
+	for i, err := range errs {
+		if err != nil {
+			log.logf(levelError, "ALTERED %s: %v", entries[i].name(), err)
+			altered++
+			continue
+		}
+		id := blobs[i].BlobID
+		want, ok := signed[id]
+		got, _ := hex.DecodeString(blobs[i].MAC)
+		switch {
+		case !ok:
+			log.logf(levelError, "EXTRA %s: not in the signature", entries[i].name())
+			extra++
+		case present[id]:
+			log.logf(levelError, "EXTRA %s: blobid listed more than once", entries[i].name())
+			extra++
+		case !hmac.Equal(got, want):
+			log.logf(levelError, "ALTERED %s: ciphertext does not match the signature", entries[i].name())
+			altered++
+		default:
+			intact++
+		}
+		present[id] = true
+	}
+	missing := 0
+	for _, b := range sig.Blobs {
+		if !present[b.BlobID] {
+			log.logf(levelError, "MISSING %s", b.BlobID)
+			missing++
+		}
+	}
+	fmt.Fprintf(stdout, "verify-manifest: %d intact, %d missing, %d altered, %d extra\n", intact, missing, altered, extra)
+	if missing+altered+extra > 0 {
+		return exitFailure
+	}
+	return 0
+}
+
+// readSetSignature parses a signature file and checks its MAC under key,
+// in constant time, before any of its entries are trusted.
+func readSetSignature(path string, key []byte) (*setSignature, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading signature: %w", err)
+	}
+	var sig setSignature
+	if err := json.Unmarshal(data, &sig); err != nil {
+		return nil, &decodeError{fmt.Errorf("parsing signature %s: %w", path, err)}
+	}
+	if sig.Version != 1 {
+		return nil, &decodeError{fmt.Errorf("signature %s: unsupported version %d", path, sig.Version)}
+	}
+	want, err := hex.DecodeString(sig.MAC)
+	if err != nil {
+		return nil, &decodeError{fmt.Errorf("signature %s: mac: %w", path, err)}
+	}
+	if !hmac.Equal(setMAC(key, sig.Blobs), want) {
+		return nil, fmt.Errorf("%w: signature %s does not authenticate under this integrity key (wrong key, or the signature was edited)", n2s.ErrAuthFailed, path)
+	}
+	return &sig, nil
+}
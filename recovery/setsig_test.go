@@ -0,0 +1,95 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/setsig_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeKeyFile(t *testing.T, hexKey string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "integrity.key")
+	if err := os.WriteFile(path, []byte(hexKey+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSealVerifyManifest(t *testing.T) {
+	entries := sealSharedSalt(t, "pw", 3)
+	keyFile := writeKeyFile(t, strings.Repeat("42", 32))
+	sig := filepath.Join(t.TempDir(), "set.sig")
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"seal-manifest", "-key-file", keyFile, "-out", sig, writeManifest(t, entries)}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("seal-manifest: exit %d: %s", code, stderr.String())
+	}
+
+	verify := func(entries []manifestEntry, keyFile, sig string) (int, string, string) {
+		stdout.Reset()
+		stderr.Reset()
+		code := run([]string{"verify-manifest", "-key-file", keyFile, "-sig", sig, writeManifest(t, entries)}, nil, &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+	if code, out, errOut := verify(entries, keyFile, sig); code != 0 || out != "verify-manifest: 3 intact, 0 missing, 0 altered, 0 extra\n" {
+		t.Fatalf("intact set: exit %d, %q: %s", code, out, errOut)
+	}
+
+	// A blob dropped in transit.
+	code, out, errOut := verify([]manifestEntry{entries[0], entries[2]}, keyFile, sig)
+	if code != exitFailure || !strings.Contains(out, "2 intact, 1 missing") || !strings.Contains(errOut, "MISSING "+entries[1].BlobID) {
+		t.Errorf("dropped blob: exit %d, %q: %s", code, out, errOut)
+	}
+
+	// A blob whose ciphertext changed, and one nobody signed.
+	altered := append([]manifestEntry(nil), entries...)
+	ct, _ := base64.StdEncoding.DecodeString(altered[0].Ciphertext)
+	ct[0] ^= 1
+	altered[0].Ciphertext = base64.StdEncoding.EncodeToString(ct)
+	altered = append(altered, sealSharedSalt(t, "pw", 4)[3])
+	code, out, errOut = verify(altered, keyFile, sig)
+	if code != exitFailure || !strings.Contains(out, "2 intact, 0 missing, 1 altered, 1 extra") || !strings.Contains(errOut, "ALTERED "+entries[0].BlobID) {
+		t.Errorf("altered and extra blobs: exit %d, %q: %s", code, out, errOut)
+	}
+
+	// A wrong key, or a signature edited to forget a blob, fails before
+	// any blob is looked at.
+	if code, _, errOut := verify(entries, writeKeyFile(t, strings.Repeat("43", 32)), sig); code != exitAuthFailed {
+		t.Errorf("wrong key: exit %d: %s", code, errOut)
+	}
+	var s setSignature
+	data, _ := os.ReadFile(sig)
+	json.Unmarshal(data, &s)
+	s.Blobs = s.Blobs[1:]
+	data, _ = json.Marshal(s)
+	edited := filepath.Join(t.TempDir(), "edited.sig")
+	os.WriteFile(edited, data, 0o600)
+	if code, _, errOut := verify(entries[1:], keyFile, edited); code != exitAuthFailed || !strings.Contains(errOut, "does not authenticate") {
+		t.Errorf("edited signature: exit %d: %s", code, errOut)
+	}
+}
+
+func TestSealManifestRefusesHoles(t *testing.T) {
+	entries := sealSharedSalt(t, "pw", 2)
+	entries = append(entries, manifestEntry{BlobID: entries[0].BlobID, Ciphertext: entries[0].Ciphertext}, manifestEntry{BlobID: "zz"})
+	sig := filepath.Join(t.TempDir(), "set.sig")
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"seal-manifest", "-key-file", writeKeyFile(t, strings.Repeat("42", 32)), "-out", sig, writeManifest(t, entries)}, nil, &stdout, &stderr)
+	if code != exitDecode || !strings.Contains(stderr.String(), "blobid also listed as "+entries[0].BlobID) {
+		t.Errorf("exit %d: %s", code, stderr.String())
+	}
+	if fileExists(sig) {
+		t.Error("signature written for a set with holes")
+	}
+}
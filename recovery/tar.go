@@ -0,0 +1,162 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/tar.go
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// openTar opens a -tar archive, or stdin for "-", gunzipping it when gz
+// is set or its name ends in .gz or .tgz.
+func openTar(name string, gz bool, stdin io.Reader) (io.ReadCloser, error) {
+	r, err := openInput(name, stdin)
+	if err != nil {
+		return nil, err
+	}
+	if !gz && !strings.HasSuffix(name, ".gz") && !strings.HasSuffix(name, ".tgz") {
+		return r, nil
+	}
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		r.Close()
+		return nil, &decodeError{fmt.Errorf("reading %s: %w", name, err)}
+	}
+	return readCloser{zr, r}, nil
+}
+
+// tarEntry reads a regular file of the archive as an entry. Its name, less
+// a .b64 or .n2s suffix, is the blobid; its contents are the base64
+// ciphertext or, for .n2s, a blob file. The plaintext goes to the same
+// relative path under -out. On error the entry is still named, so it can
+// be reported.
+func tarEntry(hdr *tar.Header, r io.Reader) (manifestEntry, error) {
+	e := manifestEntry{src: hdr.Name}
+	name := path.Clean(hdr.Name)
+	stem, ok := strings.CutSuffix(name, ".b64")
+	if !ok {
+		stem = strings.TrimSuffix(name, ".n2s")
+	}
+	e.BlobID = path.Base(stem)
+	e.dest = filepath.FromSlash(stem)
+	if !filepath.IsLocal(e.dest) {
+		return e, fmt.Errorf("entry name leaves the -out directory")
+	}
+	data, err := readAllCapped(r, defaultMaxCiphertext)
+	if err != nil {
+		return e, fmt.Errorf("reading tar entry: %w", err)
+	}
+	e.data = data
+	return e, nil
+}
+
+type tarJob struct {
+	i int
+	e manifestEntry
+}
+
+// decryptTar is decryptAll for a -tar archive read as the workers go:
+// each entry is finished by prepare and handed to a worker once read, and
+// its ciphertext dropped once opened, so the archive is never unpacked or
+// held whole. The entries come back in archive order with results[i] and
+// errs[i] belonging to entries[i]; those already in led are counted in resumed
+// instead. An entry whose output an earlier one claimed gets
+// duplicateError, so policy is error or skip: overwrite would need the
+// whole archive read first. An archive that stops reading cleanly ends
+// the run with err, and the entries already read keep their results.
+func decryptTar(ctx context.Context, cache *keyCache, r io.Reader, dir string, prepare func(*manifestEntry), policy string, jobs int, log *logger, led *ledger) (entries []manifestEntry, results []result, errs []error, resumed int, err error) {
+	var mu sync.Mutex
+	work := make(chan tarJob)
+	var wg sync.WaitGroup
+	for w := 0; w < max(jobs, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range work {
+				res, err := decryptEntry(ctx, cache, j.e, dir, log)
+				if err == nil && led != nil {
+					err = led.record(j.e.key())
+				}
+				mu.Lock()
+				results[j.i], errs[j.i] = res, err
+				mu.Unlock()
+			}
+		}()
+	}
+
+	claimed := make(map[string]string)
+	var skipped int
+	tr := tar.NewReader(r)
+read:
+	for ctx.Err() == nil {
+		hdr, terr := tr.Next()
+		if terr == io.EOF {
+			break
+		}
+		if terr != nil {
+			err = fmt.Errorf("reading tar: %w", terr)
+			break
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+		case tar.TypeDir:
+			continue
+		default:
+			skipped++
+			continue
+		}
+		e, eerr := tarEntry(hdr, tr)
+		prepare(&e)
+		if eerr == nil {
+			eerr = e.bad
+		}
+		if eerr == nil && led != nil && led.finished(e, dir) {
+			resumed++
+			continue
+		}
+		if eerr == nil && dir != "" {
+			out := e.outPath(dir)
+			if prev, ok := claimed[out]; ok {
+				eerr = duplicateError(out, prev, policy)
+			} else {
+				claimed[out] = e.name()
+			}
+		}
+		if eerr == nil {
+			eerr = errInterrupted
+		}
+		mu.Lock()
+		i := len(entries)
+		entries = append(entries, manifestEntry{BlobID: e.BlobID, src: e.src, dest: e.dest, id: e.id, shard: e.shard})
+		results = append(results, result{BlobID: e.BlobID})
+		errs = append(errs, eerr)
+		mu.Unlock()
+		if eerr != errInterrupted {
+			continue
+		}
+		select {
+		case work <- tarJob{i, e}:
+		case <-ctx.Done():
+			break read
+		}
+	}
+	close(work)
+	wg.Wait()
+	if skipped > 0 {
+		log.warnf("batch: skipped %d tar entries that are not regular files", skipped)
+	}
+	return entries, results, errs, resumed, err
+}
@@ -0,0 +1,112 @@
+// Author: PB & Claude
+// Maintainer: PB
+// Original date: 2025.05.13
+// License: (c) HRDAG, 2025, GPL-2 or newer
+//
+// ------
+// recovery/tar_test.go
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// buildTar returns a tar of files, name to contents, in the given order,
+// plus a symlink the batch must skip.
+func buildTar(t *testing.T, names []string, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range names {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(files[name])), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(files[name])
+	}
+	tw.WriteHeader(&tar.Header{Name: "link", Linkname: names[0], Typeflag: tar.TypeSymlink})
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestBatchTar(t *testing.T) {
+	pwFile := writePasswordFile(t, "pw")
+	id1, ct1 := sealClassic(t, []byte("first"), "pw")
+	id2, ct2 := sealClassic(t, []byte("second"), "pw")
+	raw2, _ := hex.DecodeString(string(id2))
+	id3, _ := sealClassic(t, []byte("third"), "pw")
+	names := []string{
+		"a/b/" + string(id1),
+		string(id2) + ".n2s",
+		string(id3),
+		"../" + string(id1),
+	}
+	archive := buildTar(t, names, map[string][]byte{
+		names[0]: []byte(base64.StdEncoding.EncodeToString(ct1) + "\n"),
+		names[1]: encodeBlobFile(raw2, ct2),
+		names[2]: []byte("not base64!"),
+		names[3]: []byte(base64.StdEncoding.EncodeToString(ct1)),
+	})
+	var zipped bytes.Buffer
+	zw := gzip.NewWriter(&zipped)
+	zw.Write(archive)
+	zw.Close()
+	gzFile := filepath.Join(t.TempDir(), "blobs.tar.gz")
+	if err := os.WriteFile(gzFile, zipped.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, c := range map[string]struct {
+		args  []string
+		stdin []byte
+	}{
+		"stdin":        {[]string{"-tar", "-"}, archive},
+		"gzip stdin":   {[]string{"-tar", "-", "-gzip"}, zipped.Bytes()},
+		"gzip by name": {[]string{"-tar", gzFile}, nil},
+	} {
+		dir := t.TempDir()
+		var out, errOut bytes.Buffer
+		args := append([]string{"-password-file", pwFile, "-out", dir, "-jobs", "2"}, c.args...)
+		code := run(append([]string{"batch"}, args...), bytes.NewReader(c.stdin), &out, &errOut)
+		if code != exitFailure {
+			t.Fatalf("%s: exit %d, want %d: %s", name, code, exitFailure, errOut.String())
+		}
+		if !strings.Contains(out.String(), "2 succeeded, 2 failed") {
+			t.Errorf("%s: summary %q", name, out.String())
+		}
+		for want, path := range map[string]string{"first": filepath.Join("a", "b", string(id1)), "second": string(id2)} {
+			if got, err := os.ReadFile(filepath.Join(dir, path)); err != nil || string(got) != want {
+				t.Errorf("%s: %s = %q, %v; want %q", name, path, got, err, want)
+			}
+		}
+		for _, want := range []string{"FAIL " + names[2], "FAIL " + names[3] + ": entry name leaves", "skipped 1 tar entries"} {
+			if !strings.Contains(errOut.String(), want) {
+				t.Errorf("%s: stderr lacks %q:\n%s", name, want, errOut.String())
+			}
+		}
+		want := []string{"a", string(id2)}
+		slices.Sort(want)
+		assertOnlyFiles(t, dir, want...)
+	}
+
+	var out, errOut bytes.Buffer
+	truncated := archive[:len(archive)-1500]
+	code := run([]string{"batch", "-password-file", pwFile, "-out", t.TempDir(), "-tar", "-"}, bytes.NewReader(truncated), &out, &errOut)
+	if code != exitFailure || !strings.Contains(errOut.String(), "the rest of the archive was not read") {
+		t.Errorf("truncated: exit %d: %s", code, errOut.String())
+	}
+	if code := run([]string{"batch", "-password-file", pwFile, "-out", t.TempDir(), "-tar", "-", "-on-duplicate", "overwrite"}, bytes.NewReader(archive), &out, &errOut); code != exitUsage {
+		t.Errorf("-on-duplicate overwrite: exit %d, want %d", code, exitUsage)
+	}
+}
@@ -108,7 +108,7 @@ if [ "$VERBOSE" = true ]; then
     echo "  Base64 length: ${#ENCRYPTED_B64}"
     echo "  Base64 first 50 chars: ${ENCRYPTED_B64:0:50}..."
     echo "  Base64 last 20 chars: ...${ENCRYPTED_B64: -20}"
-    echo "  Running: $DECRYPT_BIN $BLOBID [passphrase] /dev/stdin"
+    echo "  Running: $DECRYPT_BIN $BLOBID - [encrypted_b64] (passphrase on stdin)"
 fi
 
 # Decrypt and decompress to temp file
@@ -119,14 +119,14 @@ if [ "$VERBOSE" = true ]; then
     echo "Attempting decryption..."
 fi
 
-if ! "$DECRYPT_BIN" "$BLOBID" "$PASSPHRASE" "$ENCRYPTED_B64" | lz4 -d > "$TEMP_FILE"; then
+if ! printf '%s\n' "$PASSPHRASE" | "$DECRYPT_BIN" "$BLOBID" - "$ENCRYPTED_B64" | lz4 -d > "$TEMP_FILE"; then
     echo "Error: Decryption/decompression failed" >&2
     if [ "$VERBOSE" = true ]; then
         echo "Debug: Trying manual steps..."
         echo "1. Testing base64 decode:"
         echo -n "$ENCRYPTED_B64" | base64 -d | wc -c || echo "Base64 decode failed"
         echo "2. Testing decrypt without lz4:"
-        "$DECRYPT_BIN" "$BLOBID" "$PASSPHRASE" "$ENCRYPTED_B64" | wc -c || echo "Decrypt failed"
+        printf '%s\n' "$PASSPHRASE" | "$DECRYPT_BIN" "$BLOBID" - "$ENCRYPTED_B64" | wc -c || echo "Decrypt failed"
     fi
     exit 1
 fi